@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxLineLength is the maximum length of an Assuan line, including the final
+// line feed.
+const maxLineLength = 1000
+
+// Error codes of libgpg-error returned to gpg.
+const (
+	errSourceAgent = 4
+
+	errGeneral              = 1
+	errNoSecretKey          = 17
+	errNotFound             = 27
+	errNotSupported         = 60
+	errUnsupportedAlgorithm = 84
+	errWrongKeyUsage        = 125
+	errUnknownCommand       = 275
+	errCanceled             = 277
+	errParameter            = 280
+)
+
+// assuanError is an error reported to the client with an ERR line.
+type assuanError struct {
+	code    int
+	message string
+}
+
+func (e *assuanError) Error() string {
+	return e.message
+}
+
+func assuanErrorf(code int, format string, args ...interface{}) error {
+	return &assuanError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// assuanConn reads the requests and writes the responses of the Assuan
+// protocol on a connection.
+type assuanConn struct {
+	r *bufio.Reader
+	w *bufio.Writer
+	// err is the first error of the connection, it cannot be used anymore
+	err error
+}
+
+func newAssuanConn(rw io.ReadWriter) *assuanConn {
+	return &assuanConn{r: bufio.NewReaderSize(rw, maxLineLength), w: bufio.NewWriter(rw)}
+}
+
+// readLine returns the next line sent by the client, skipping the empty lines
+// and the comments.
+func (c *assuanConn) readLine() (string, error) {
+	for {
+		line, err := c.r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			err = errors.New("line too long")
+		}
+		if err != nil {
+			c.err = err
+			return "", err
+		}
+		line = bytes.TrimSuffix(line[:len(line)-1], []byte("\r"))
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		return string(line), nil
+	}
+}
+
+// writeLine writes a line and sends it to the client.
+func (c *assuanConn) writeLine(line string) error {
+	if _, err := c.w.WriteString(line + "\n"); err != nil {
+		c.err = err
+		return err
+	}
+	if err := c.w.Flush(); err != nil {
+		c.err = err
+		return err
+	}
+	return nil
+}
+
+func (c *assuanConn) ok() error {
+	return c.writeLine("OK")
+}
+
+// writeError writes the ERR line of the error. The errors not raised by the
+// agent are reported as general errors.
+func (c *assuanConn) writeError(err error) error {
+	var e *assuanError
+	if !errors.As(err, &e) {
+		e = &assuanError{code: errGeneral, message: err.Error()}
+	}
+	message := strings.NewReplacer("\r", " ", "\n", " ").Replace(e.message)
+	line := fmt.Sprintf("ERR %d %s", errSourceAgent<<24|e.code, message)
+	if len(line) >= maxLineLength {
+		line = line[:maxLineLength-1]
+	}
+	return c.writeLine(line)
+}
+
+// writeStatus writes a status line.
+func (c *assuanConn) writeStatus(keyword string, args ...string) error {
+	return c.writeLine(strings.Join(append([]string{"S", keyword}, args...), " "))
+}
+
+// writeData writes the data in as many D lines as needed, escaping the
+// characters that cannot appear in a line.
+func (c *assuanConn) writeData(data []byte) error {
+	line := []byte("D ")
+	for _, b := range data {
+		if len(line) > maxLineLength-4 {
+			if err := c.writeLine(string(line)); err != nil {
+				return err
+			}
+			line = []byte("D ")
+		}
+		switch b {
+		case '%', '\r', '\n':
+			line = fmt.Appendf(line, "%%%02X", b)
+		default:
+			line = append(line, b)
+		}
+	}
+	if len(line) > 2 {
+		return c.writeLine(string(line))
+	}
+	return nil
+}
+
+// inquire asks the client for the data of the keyword and returns it.
+func (c *assuanConn) inquire(keyword string) ([]byte, error) {
+	if err := c.writeLine("INQUIRE " + keyword); err != nil {
+		return nil, err
+	}
+	var data []byte
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case line == "END":
+			return data, nil
+		case line == "CAN":
+			return nil, assuanErrorf(errCanceled, "inquiry canceled")
+		case strings.HasPrefix(line, "D "):
+			decoded, err := unescape(line[2:])
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, decoded...)
+		default:
+			return nil, assuanErrorf(errParameter, "unexpected response to the inquiry")
+		}
+	}
+}
+
+// unescape decodes the %XX escapes of a data line.
+func unescape(s string) ([]byte, error) {
+	decoded := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			decoded = append(decoded, s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return nil, assuanErrorf(errParameter, "invalid escape in the data")
+		}
+		b, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return nil, assuanErrorf(errParameter, "invalid escape in the data")
+		}
+		decoded = append(decoded, b[0])
+		i += 2
+	}
+	return decoded, nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec // keygrips are SHA-1 hashes
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// keygrip identifies a key in the gpg-agent protocol, independently of the
+// OpenPGP packet holding it.
+type keygrip [sha1.Size]byte
+
+func (g keygrip) String() string {
+	return fmt.Sprintf("%X", g[:])
+}
+
+// parseKeygrip parses a keygrip given as 40 hexadecimal characters.
+func parseKeygrip(s string) (keygrip, error) {
+	var g keygrip
+	decoded, err := hex.DecodeString(s)
+	if err != nil || len(decoded) != len(g) {
+		return g, assuanErrorf(errParameter, "invalid keygrip %s", s)
+	}
+	copy(g[:], decoded)
+	return g, nil
+}
+
+// rsaKeygrip returns the keygrip of an RSA key, the SHA-1 hash of its modulus
+// as a signed big-endian integer.
+func rsaKeygrip(key *rsa.PublicKey) keygrip {
+	return sha1.Sum(signedBytes(key.N))
+}
+
+// signedBytes returns the big-endian representation of a positive integer,
+// prefixed by a zero byte when its most significant bit is set.
+func signedBytes(n *big.Int) []byte {
+	b := n.Bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return b
+}
+
+// vaultKey is a key of the backend, the primary key or a subkey of a Vault key.
+type vaultKey struct {
+	name      string
+	keyID     uint64
+	publicKey *rsa.PublicKey
+	primary   bool
+	// canSign is set when the agent signs with the key: for the signing
+	// subkeys, and for the primary keys when certifying keys are allowed
+	canSign bool
+}
+
+// agent serves the keys of the backend to the clients.
+type agent struct {
+	logical vaultLogical
+	mount   string
+	logger  *log.Logger
+	socket  string
+	// version is the version of gpg-agent reported to the clients
+	version string
+	// allowCertify allows the primary keys to sign. They certify the subkeys
+	// and the user IDs, a client able to sign any hash with them can forge
+	// certifications.
+	allowCertify bool
+	keys         map[keygrip]*vaultKey
+}
+
+// loadKeys reads the public keys of the named Vault keys and indexes their
+// RSA keys by keygrip.
+func (a *agent) loadKeys(names []string) error {
+	a.keys = map[keygrip]*vaultKey{}
+	for _, name := range names {
+		secret, err := a.logical.Read(a.mount + "/keys/" + name)
+		if err != nil {
+			return fmt.Errorf("unable to read the key %s: %w", name, err)
+		}
+		if secret == nil {
+			return fmt.Errorf("the key %s does not exist", name)
+		}
+		publicKey, _ := secret.Data["public_key"].(string)
+		entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(publicKey))
+		if err != nil {
+			return fmt.Errorf("unable to read the public key of %s: %w", name, err)
+		}
+
+		keys := []*packet.PublicKey{entities[0].PrimaryKey}
+		canSign := map[uint64]bool{entities[0].PrimaryKey.KeyId: a.allowCertify}
+		for _, subkey := range entities[0].Subkeys {
+			keys = append(keys, subkey.PublicKey)
+			canSign[subkey.PublicKey.KeyId] = subkey.Sig.FlagsValid && subkey.Sig.FlagSign
+		}
+		signs := false
+		for _, key := range keys {
+			rsaKey, ok := key.PublicKey.(*rsa.PublicKey)
+			if !ok {
+				a.logger.Printf("skipping the key %016X of %s: only RSA keys are supported", key.KeyId, name)
+				continue
+			}
+			grip := rsaKeygrip(rsaKey)
+			primary := key.KeyId == entities[0].PrimaryKey.KeyId
+			a.keys[grip] = &vaultKey{name: name, keyID: key.KeyId, publicKey: rsaKey, primary: primary, canSign: canSign[key.KeyId]}
+			a.logger.Printf("serving the key %016X of %s with the keygrip %s", key.KeyId, name, grip)
+			signs = signs || canSign[key.KeyId]
+		}
+		if !signs {
+			a.logger.Printf("the key %s has no RSA signing subkey, its primary key only signs with -allow-certify", name)
+		}
+	}
+	if len(a.keys) == 0 {
+		return errors.New("no key to serve")
+	}
+	return nil
+}
+
+// sexp is a canonical S-expression: a list of byte strings and S-expressions.
+type sexp []interface{}
+
+// encode returns the canonical encoding of the S-expression.
+func (s sexp) encode() []byte {
+	var b bytes.Buffer
+	b.WriteByte('(')
+	for _, element := range s {
+		switch element := element.(type) {
+		case sexp:
+			b.Write(element.encode())
+		case []byte:
+			fmt.Fprintf(&b, "%d:", len(element))
+			b.Write(element)
+		case string:
+			fmt.Fprintf(&b, "%d:%s", len(element), element)
+		}
+	}
+	b.WriteByte(')')
+	return b.Bytes()
+}
+
+// find returns the first list whose first element is the token, searching the
+// S-expression depth first.
+func (s sexp) find(token string) sexp {
+	if len(s) > 0 {
+		if first, ok := s[0].([]byte); ok && string(first) == token {
+			return s
+		}
+	}
+	for _, element := range s {
+		if list, ok := element.(sexp); ok {
+			if found := list.find(token); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+// value returns the byte string following the token in the list starting with
+// it, such as the value of a parameter of a key.
+func (s sexp) value(token string) []byte {
+	list := s.find(token)
+	if len(list) < 2 {
+		return nil
+	}
+	value, _ := list[1].([]byte)
+	return value
+}
+
+// parseSexp parses a canonical S-expression.
+func parseSexp(data []byte) (sexp, error) {
+	s, rest, err := parseList(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimRight(rest, "\x00")) != 0 {
+		return nil, errors.New("trailing data after the S-expression")
+	}
+	return s, nil
+}
+
+func parseList(data []byte) (sexp, []byte, error) {
+	if len(data) == 0 || data[0] != '(' {
+		return nil, nil, errors.New("invalid S-expression")
+	}
+	data = data[1:]
+	s := sexp{}
+	for {
+		switch {
+		case len(data) == 0:
+			return nil, nil, errors.New("truncated S-expression")
+		case data[0] == ')':
+			return s, data[1:], nil
+		case data[0] == '(':
+			list, rest, err := parseList(data)
+			if err != nil {
+				return nil, nil, err
+			}
+			s, data = append(s, list), rest
+		default:
+			i := bytes.IndexByte(data, ':')
+			if i <= 0 {
+				return nil, nil, errors.New("invalid S-expression")
+			}
+			length, err := strconv.Atoi(string(data[:i]))
+			if err != nil || length < 0 || length > len(data)-i-1 {
+				return nil, nil, errors.New("invalid length in the S-expression")
+			}
+			s, data = append(s, data[i+1:i+1+length]), data[i+1+length:]
+		}
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package main
+
+import (
+	"net"
+	"os"
+)
+
+// listenPrivate listens on the Unix socket and restricts it to the user. The
+// access to the socket is controlled by the permissions of its directory on
+// the systems without umask.
+func listenPrivate(socket string) (net.Listener, error) {
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socket, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
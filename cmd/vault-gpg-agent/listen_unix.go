@@ -0,0 +1,16 @@
+//go:build unix
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenPrivate listens on the Unix socket. The socket is created with the
+// umask 077 so no other user can connect to it before its permissions are set.
+func listenPrivate(socket string) (net.Listener, error) {
+	umask := syscall.Umask(0o077)
+	defer syscall.Umask(umask)
+	return net.Listen("unix", socket)
+}
@@ -0,0 +1,33 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestListenPrivateSocket(t *testing.T) {
+	// The socket is private whatever the umask of the process
+	umask := syscall.Umask(0)
+	defer syscall.Umask(umask)
+
+	socket := filepath.Join(t.TempDir(), agentSocketName)
+	listener, err := listen(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 && perm != 0o700 {
+		t.Fatalf("expected the socket to only be accessible by the user, got %o", perm)
+	}
+	if _, err := listen(socket); err == nil {
+		t.Fatal("expected the socket of a running agent not to be replaced")
+	}
+}
@@ -0,0 +1,151 @@
+// Command vault-gpg-agent serves the keys of the GPG secret backend to gpg. It
+// implements the part of the gpg-agent protocol used by gpg to sign and decrypt
+// on a Unix socket, so gpg can use the keys held by Vault as if they were its
+// own once their public keys are imported:
+//
+//	vault-gpg-agent -socket "$(gpgconf --list-dirs agent-socket)" <name of the key in Vault>...
+//
+// The private key operations are forwarded to the backend, the secret keys
+// never leave Vault. Only the RSA keys are supported, the other keys and
+// subkeys are skipped.
+//
+// gpg asks the agent to sign hashes, which the backend only signs for the keys
+// allowing prehashed inputs with the allow_prehashed parameter of
+// gpg/keys/<name>/config. Only the signing subkeys sign unless -allow-certify
+// is set and the key also sets allow_prehashed_certify: the primary key
+// certifies the key, signing any hash with it allows to forge certifications.
+// The keys generated by the backend have no signing subkey, import a key with
+// an RSA signing subkey to sign without -allow-certify.
+//
+// The Vault client is configured with the usual environment variables, such
+// as VAULT_ADDR and VAULT_TOKEN. The backend is expected to be mounted at gpg/,
+// VAULT_GPG_MOUNT can be set to use another mount.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	defaultMount  = "gpg"
+	messagePrefix = "vault-gpg-agent: "
+	// agentSocketName is the name of the socket of gpg-agent in the GnuPG
+	// home directory.
+	agentSocketName = "S.gpg-agent"
+	// defaultAgentVersion is the first version of gpg-agent whose protocol is
+	// implemented by the agent.
+	defaultAgentVersion = "2.1.0"
+)
+
+// vaultLogical is the part of the Vault logical API used by the agent.
+type vaultLogical interface {
+	Read(path string) (*api.Secret, error)
+	Write(path string, data map[string]interface{}) (*api.Secret, error)
+}
+
+func main() {
+	logger := log.New(os.Stderr, messagePrefix, log.LstdFlags)
+	socket := flag.String("socket", "", "Path of the socket to listen on. Defaults to S.gpg-agent in the GnuPG home directory.")
+	version := flag.String("agent-version", defaultAgentVersion, "Version of gpg-agent reported to gpg, which warns when it is older than itself.")
+	allowCertify := flag.Bool("allow-certify", false, "Also sign with the primary keys, which certify the keys. By default only the signing subkeys sign.")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-socket <path>] [-agent-version <version>] [-allow-certify] <key name>...\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprint(flag.CommandLine.Output(), `
+Only the RSA keys and subkeys are served. To sign, the keys must allow prehashed
+inputs with allow_prehashed in gpg/keys/<name>/config. Only the signing subkeys
+sign, the keys generated by Vault have none: their primary key signs with
+-allow-certify and allow_prehashed_certify set in the configuration of the key.
+`)
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	config := api.DefaultConfig()
+	if config.Error != nil {
+		logger.Fatal(config.Error)
+	}
+	client, err := api.NewClient(config)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	mount := os.Getenv("VAULT_GPG_MOUNT")
+	if mount == "" {
+		mount = defaultMount
+	}
+
+	a := &agent{
+		logical:      client.Logical(),
+		mount:        strings.Trim(mount, "/"),
+		logger:       logger,
+		version:      *version,
+		allowCertify: *allowCertify,
+	}
+	if err := a.loadKeys(flag.Args()); err != nil {
+		logger.Fatal(err)
+	}
+
+	if *socket == "" {
+		if *socket, err = defaultSocket(); err != nil {
+			logger.Fatal(err)
+		}
+	}
+	listener, err := listen(*socket)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	a.socket = *socket
+	logger.Printf("listening on %s", *socket)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	if err := a.serve(listener); err != nil && ctx.Err() == nil {
+		logger.Fatal(err)
+	}
+}
+
+// defaultSocket returns the path of the socket of gpg-agent in the GnuPG home
+// directory.
+func defaultSocket() (string, error) {
+	home := os.Getenv("GNUPGHOME")
+	if home == "" {
+		userHome, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		home = filepath.Join(userHome, ".gnupg")
+	}
+	return filepath.Join(home, agentSocketName), nil
+}
+
+// listen listens on the Unix socket, replacing a stale socket left by an agent
+// that is no longer running. The socket is only accessible by the user.
+func listen(socket string) (net.Listener, error) {
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("an agent is already listening on %s", socket)
+	}
+	if err := os.Remove(socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return listenPrivate(socket)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/LeSuisse/vault-gpg-plugin/internal/vaulttest"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// testClient is a client of the agent.
+type testClient struct {
+	t    *testing.T
+	conn *assuanConn
+}
+
+// connect starts a session of the agent and returns its client.
+func connect(t *testing.T, a *agent) *testClient {
+	server, client := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	go func() {
+		defer server.Close()
+		_ = a.newSession(server).run()
+	}()
+	c := &testClient{t: t, conn: newAssuanConn(client)}
+	if greeting, err := c.conn.readLine(); err != nil || !strings.HasPrefix(greeting, "OK ") {
+		t.Fatalf("unexpected greeting %q: %v", greeting, err)
+	}
+	return c
+}
+
+// transact sends a command and returns the lines of the response until its
+// last line, answering the inquiry with the data.
+func (c *testClient) transact(command string, inquiryData []byte) ([]string, string) {
+	if err := c.conn.writeLine(command); err != nil {
+		c.t.Fatal(err)
+	}
+	var lines []string
+	for {
+		line, err := c.conn.readLine()
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		switch {
+		case strings.HasPrefix(line, "INQUIRE "):
+			if err := c.conn.writeData(inquiryData); err != nil {
+				c.t.Fatal(err)
+			}
+			if err := c.conn.writeLine("END"); err != nil {
+				c.t.Fatal(err)
+			}
+		case line == "OK" || strings.HasPrefix(line, "ERR "):
+			return lines, line
+		default:
+			lines = append(lines, line)
+		}
+	}
+}
+
+// sign asks the agent to sign the digest with the key and returns the result
+// of PKSIGN with the signature.
+func (c *testClient) sign(grip keygrip, digest []byte) ([]byte, string) {
+	c.transact("RESET", nil)
+	if _, result := c.transact("SIGKEY "+grip.String(), nil); result != "OK" {
+		c.t.Fatalf("unable to set the signing key: %s", result)
+	}
+	if _, result := c.transact(fmt.Sprintf("SETHASH 8 %X", digest), nil); result != "OK" {
+		c.t.Fatalf("unable to set the hash: %s", result)
+	}
+	lines, result := c.transact("PKSIGN", nil)
+	if result != "OK" {
+		return nil, result
+	}
+	sigVal, err := parseSexp(data(lines))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return sigVal.value("s"), result
+}
+
+// data returns the data of the D lines of a response.
+func data(lines []string) []byte {
+	var d []byte
+	for _, line := range lines {
+		if decoded, err := unescape(strings.TrimPrefix(line, "D ")); err == nil && strings.HasPrefix(line, "D ") {
+			d = append(d, decoded...)
+		}
+	}
+	return d
+}
+
+func TestAgent(t *testing.T) {
+	l := vaulttest.NewLogical()
+	// The primary key certifies and signs, the subkeys encrypt and sign
+	entity, err := openpgp.NewEntity("Alice", "", "alice@example.com", &packet.Config{RSABits: 2048})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.AddSigningSubkey(&packet.Config{RSABits: 2048}); err != nil {
+		t.Fatal(err)
+	}
+	var key bytes.Buffer
+	w, err := armor.Encode(&key, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.SerializePrivateWithoutSigning(w, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Write("gpg/keys/alice", map[string]interface{}{"generate": false, "key": key.String()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Write("gpg/keys/alice/config", map[string]interface{}{"allow_prehashed": true}); err != nil {
+		t.Fatal(err)
+	}
+	a := &agent{logical: l, mount: "gpg", logger: log.New(io.Discard, "", 0), version: "2.4.0"}
+	if err := a.loadKeys([]string{"unknown"}); err == nil {
+		t.Fatal("expected the unknown key to be rejected")
+	}
+	if err := a.loadKeys([]string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.keys) != 3 {
+		t.Fatalf("expected the primary key and the subkeys, got %d keys", len(a.keys))
+	}
+	var primaryGrip, signingGrip, decryptionGrip keygrip
+	for grip, key := range a.keys {
+		switch key.keyID {
+		case entity.PrimaryKey.KeyId:
+			primaryGrip = grip
+		case entity.Subkeys[0].PublicKey.KeyId:
+			decryptionGrip = grip
+		case entity.Subkeys[1].PublicKey.KeyId:
+			signingGrip = grip
+		}
+	}
+
+	c := connect(t, a)
+	for _, test := range []struct {
+		command string
+		result  string
+	}{
+		{"OPTION ttytype=xterm", "OK"},
+		{"HAVEKEY " + strings.Repeat("00", 20) + " " + signingGrip.String(), "OK"},
+		{"HAVEKEY " + strings.Repeat("00", 20), "ERR 67108881 no secret key"},
+		{"HAVEKEY zz", "ERR 67109144 invalid keygrip zz"},
+		{"KEYINFO " + strings.Repeat("00", 20), "ERR 67108891 no key with the keygrip " + strings.Repeat("00", 20)},
+		{"SETHASH 2 " + strings.Repeat("00", 20), "ERR 67108948 unsupported hash algorithm 2"},
+		{"PKSIGN", "ERR 67108881 no signing key set"},
+		{"UNKNOWN", "ERR 67109139 unknown command UNKNOWN"},
+	} {
+		if _, result := c.transact(test.command, nil); result != test.result {
+			t.Fatalf("expected %q for %s, got %q", test.result, test.command, result)
+		}
+	}
+
+	if lines, _ := c.transact("GETINFO version", nil); string(data(lines)) != "2.4.0" {
+		t.Fatalf("unexpected version: %v", lines)
+	}
+	lines, _ := c.transact("KEYINFO "+decryptionGrip.String(), nil)
+	if len(lines) != 1 || lines[0] != "S KEYINFO "+decryptionGrip.String()+" D - - - C - - -" {
+		t.Fatalf("unexpected key information: %v", lines)
+	}
+	if lines, _ := c.transact("HAVEKEY --list=1000", nil); len(data(lines)) != 3*len(keygrip{}) {
+		t.Fatalf("expected three keygrips: %v", lines)
+	}
+
+	// The public key is the one of the keygrip
+	lines, _ = c.transact("READKEY "+signingGrip.String(), nil)
+	publicKey, err := parseSexp(data(lines))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsaKeygrip(&rsa.PublicKey{N: new(big.Int).SetBytes(publicKey.value("n"))}) != signingGrip {
+		t.Fatalf("unexpected public key: %q", data(lines))
+	}
+
+	// Signatures are PKCS #1 v1.5 signatures of the hash, the primary key
+	// only signs with -allow-certify
+	digest := sha256.Sum256([]byte("Hello, World!"))
+	signature, result := c.sign(signingGrip, digest[:])
+	if result != "OK" {
+		t.Fatalf("unable to sign: %s", result)
+	}
+	if err := rsa.VerifyPKCS1v15(a.keys[signingGrip].publicKey, crypto.SHA256, digest[:], signature); err != nil {
+		t.Fatalf("invalid signature: %s", err)
+	}
+	if _, result := c.sign(primaryGrip, digest[:]); !strings.HasPrefix(result, "ERR 67108989 ") || !strings.Contains(result, "-allow-certify") {
+		t.Fatalf("expected the primary key not to sign: %s", result)
+	}
+	if _, result := c.sign(decryptionGrip, digest[:]); result != fmt.Sprintf("ERR 67108989 the key %016X of alice is not a signing subkey", entity.Subkeys[0].PublicKey.KeyId) {
+		t.Fatalf("expected the encryption subkey not to sign: %s", result)
+	}
+	certifying := &agent{logical: l, mount: "gpg", logger: log.New(io.Discard, "", 0), allowCertify: true}
+	if err := certifying.loadKeys([]string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+	// The backend also refuses to sign hashes with the primary key unless
+	// allowed by the configuration of the key
+	expected := fmt.Sprintf("ERR 67108924 the key %016X of alice cannot sign hashes, set allow_prehashed and allow_prehashed_certify in gpg/keys/alice/config", entity.PrimaryKey.KeyId)
+	if _, result := connect(t, certifying).sign(primaryGrip, digest[:]); result != expected {
+		t.Fatalf("expected the backend to refuse the primary key: %s", result)
+	}
+	if _, err := l.Write("gpg/keys/alice/config", map[string]interface{}{"allow_prehashed": false}); err != nil {
+		t.Fatal(err)
+	}
+	expected = fmt.Sprintf("ERR 67108924 the key %016X of alice cannot sign hashes, set allow_prehashed in gpg/keys/alice/config", entity.Subkeys[1].PublicKey.KeyId)
+	if _, result := connect(t, a).sign(signingGrip, digest[:]); result != expected {
+		t.Fatalf("expected the backend to refuse the hash: %s", result)
+	}
+	if _, err := l.Write("gpg/keys/alice/config", map[string]interface{}{"allow_prehashed": true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Write("gpg/keys/alice/config", map[string]interface{}{"allow_prehashed_certify": true}); err != nil {
+		t.Fatal(err)
+	}
+	signature, result = connect(t, certifying).sign(primaryGrip, digest[:])
+	if result != "OK" {
+		t.Fatalf("unable to sign with the primary key: %s", result)
+	}
+	if err := rsa.VerifyPKCS1v15(a.keys[primaryGrip].publicKey, crypto.SHA256, digest[:], signature); err != nil {
+		t.Fatalf("invalid signature: %s", err)
+	}
+
+	// The session key is returned in an unpadded PKCS #1 v1.5 encryption block
+	sessionKey := make([]byte, 32)
+	if _, err := rand.Read(sessionKey); err != nil {
+		t.Fatal(err)
+	}
+	var checksum uint16
+	for _, b := range sessionKey {
+		checksum += uint16(b)
+	}
+	message := binary.BigEndian.AppendUint16(append([]byte{9}, sessionKey...), checksum)
+	ciphertext, err := rsa.EncryptPKCS1v15(rand.Reader, a.keys[decryptionGrip].publicKey, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encVal := sexp{"enc-val", sexp{"rsa", sexp{"a", ciphertext}}}
+	c.transact("RESET", nil)
+	if _, result := c.transact("SETKEY "+decryptionGrip.String(), nil); result != "OK" {
+		t.Fatalf("unable to set the decryption key: %s", result)
+	}
+	lines, result = c.transact("PKDECRYPT", encVal.encode())
+	if result != "OK" {
+		t.Fatalf("unable to decrypt: %s", result)
+	}
+	value, err := parseSexp(data(lines))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := value.value("value")
+	size := (a.keys[decryptionGrip].publicKey.N.BitLen() + 7) / 8
+	if len(frame) != size-1 || frame[0] != 2 || frame[len(frame)-len(message)-1] != 0 || !bytes.HasSuffix(frame, message) {
+		t.Fatalf("unexpected decryption result: %X", frame)
+	}
+	if bytes.IndexByte(frame[1:len(frame)-len(message)-1], 0) != -1 {
+		t.Fatalf("unexpected zero byte in the padding: %X", frame)
+	}
+
+	if _, result := c.transact("BYE", nil); result != "OK" {
+		t.Fatalf("unexpected result for BYE: %s", result)
+	}
+}
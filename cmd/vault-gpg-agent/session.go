@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// hashAlgorithms are the names used by the backend of the hash algorithms
+// identified by their libgcrypt number.
+var hashAlgorithms = map[int]string{
+	8:   "sha2-256",
+	9:   "sha2-384",
+	10:  "sha2-512",
+	11:  "sha2-224",
+	313: "sha3-256",
+	315: "sha3-512",
+}
+
+// hashNames are the names of the hash algorithms given with SETHASH --hash.
+var hashNames = map[string]string{
+	"sha224":   "sha2-224",
+	"sha256":   "sha2-256",
+	"sha384":   "sha2-384",
+	"sha512":   "sha2-512",
+	"sha3-256": "sha3-256",
+	"sha3-512": "sha3-512",
+}
+
+// errPrehashedNotAllowed is the error of the backend when the key does not
+// allow to sign hashes.
+const errPrehashedNotAllowed = "prehashed inputs are not allowed"
+
+// serve accepts the connections of the clients until the listener is closed.
+func (a *agent) serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := a.newSession(conn).run(); err != nil && !errors.Is(err, io.EOF) {
+				a.logger.Print(err)
+			}
+		}()
+	}
+}
+
+// session is the state of a client connection, set by the commands preceding
+// an operation.
+type session struct {
+	agent *agent
+	conn  *assuanConn
+	key   *vaultKey
+	hash  string
+	// digest is the hash of the data to sign
+	digest []byte
+}
+
+func (a *agent) newSession(rw io.ReadWriter) *session {
+	return &session{agent: a, conn: newAssuanConn(rw)}
+}
+
+// run greets the client and handles its commands until it says goodbye.
+func (s *session) run() error {
+	if err := s.conn.writeLine("OK Pleased to meet you, process " + strconv.Itoa(os.Getpid())); err != nil {
+		return err
+	}
+	for {
+		line, err := s.conn.readLine()
+		if err != nil {
+			return err
+		}
+		command, args, _ := strings.Cut(line, " ")
+		command = strings.ToUpper(command)
+		if command == "BYE" {
+			return s.conn.ok()
+		}
+		err = s.handle(command, strings.TrimSpace(args))
+		// The client is gone when the connection failed during the command
+		if s.conn.err != nil {
+			return s.conn.err
+		}
+		if err != nil {
+			err = s.conn.writeError(err)
+		} else {
+			err = s.conn.ok()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handle runs a command, its data and status lines are written before the OK
+// line written by the caller.
+func (s *session) handle(command, args string) error {
+	switch command {
+	case "NOP", "OPTION", "SETKEYDESC":
+		return nil
+	case "RESET":
+		s.key, s.hash, s.digest = nil, "", nil
+		return nil
+	case "GETINFO":
+		return s.getInfo(args)
+	case "HAVEKEY":
+		return s.haveKey(args)
+	case "KEYINFO":
+		return s.keyInfo(args)
+	case "READKEY":
+		return s.readKey(args)
+	case "SIGKEY", "SETKEY":
+		key, err := s.lookup(args)
+		if err != nil {
+			return err
+		}
+		s.key = key
+		return nil
+	case "SETHASH":
+		return s.setHash(args)
+	case "PKSIGN":
+		return s.sign()
+	case "PKDECRYPT":
+		return s.decrypt()
+	default:
+		return assuanErrorf(errUnknownCommand, "unknown command %s", command)
+	}
+}
+
+// lookup returns the key with the keygrip.
+func (s *session) lookup(hexKeygrip string) (*vaultKey, error) {
+	grip, err := parseKeygrip(hexKeygrip)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := s.agent.keys[grip]
+	if !ok {
+		return nil, assuanErrorf(errNoSecretKey, "no secret key with the keygrip %s", hexKeygrip)
+	}
+	return key, nil
+}
+
+func (s *session) getInfo(what string) error {
+	switch what {
+	case "version":
+		return s.conn.writeData([]byte(s.agent.version))
+	case "pid":
+		return s.conn.writeData([]byte(strconv.Itoa(os.Getpid())))
+	case "socket_name":
+		return s.conn.writeData([]byte(s.agent.socket))
+	default:
+		return assuanErrorf(errParameter, "unknown value for WHAT")
+	}
+}
+
+// haveKey succeeds when one of the keygrips is served by the agent. With
+// --list, the keygrips of all the keys are written instead.
+func (s *session) haveKey(args string) error {
+	fields := strings.Fields(args)
+	if len(fields) > 0 && (fields[0] == "--list" || strings.HasPrefix(fields[0], "--list=")) {
+		var grips []byte
+		for grip := range s.agent.keys {
+			grips = append(grips, grip[:]...)
+		}
+		return s.conn.writeData(grips)
+	}
+	for _, field := range fields {
+		grip, err := parseKeygrip(field)
+		if err != nil {
+			return err
+		}
+		if _, ok := s.agent.keys[grip]; ok {
+			return nil
+		}
+	}
+	return assuanErrorf(errNoSecretKey, "no secret key")
+}
+
+// keyInfo writes the information about a key, or about all the keys with
+// --list. The keys are reported as regular keys that can be used without a
+// passphrase.
+func (s *session) keyInfo(args string) error {
+	var list, data bool
+	var grips []keygrip
+	for _, field := range strings.Fields(args) {
+		switch {
+		case field == "--list":
+			list = true
+		case field == "--data":
+			data = true
+		case strings.HasPrefix(field, "--"):
+		default:
+			grip, err := parseKeygrip(field)
+			if err != nil {
+				return err
+			}
+			if _, ok := s.agent.keys[grip]; !ok {
+				return assuanErrorf(errNotFound, "no key with the keygrip %s", field)
+			}
+			grips = append(grips, grip)
+		}
+	}
+	if list {
+		grips = grips[:0]
+		for grip := range s.agent.keys {
+			grips = append(grips, grip)
+		}
+	}
+	if len(grips) == 0 {
+		return assuanErrorf(errParameter, "no keygrip given")
+	}
+
+	for _, grip := range grips {
+		info := []string{grip.String(), "D", "-", "-", "-", "C", "-", "-", "-"}
+		var err error
+		if data {
+			err = s.conn.writeData([]byte("KEYINFO " + strings.Join(info, " ") + "\n"))
+		} else {
+			err = s.conn.writeStatus("KEYINFO", info...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readKey writes the public key with the keygrip as an S-expression.
+func (s *session) readKey(args string) error {
+	key, err := s.lookup(args)
+	if err != nil {
+		return err
+	}
+	publicKey := sexp{"public-key", sexp{"rsa",
+		sexp{"n", signedBytes(key.publicKey.N)},
+		sexp{"e", signedBytes(big.NewInt(int64(key.publicKey.E)))},
+	}}
+	return s.conn.writeData(publicKey.encode())
+}
+
+// setHash sets the hash to sign, given as the number of the hash algorithm or
+// with --hash followed by the hexadecimal digest.
+func (s *session) setHash(args string) error {
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		return assuanErrorf(errParameter, "invalid arguments")
+	}
+	var algorithm string
+	var ok bool
+	if name, isName := strings.CutPrefix(fields[0], "--hash="); isName {
+		algorithm, ok = hashNames[name]
+	} else if number, err := strconv.Atoi(fields[0]); err == nil {
+		algorithm, ok = hashAlgorithms[number]
+	}
+	if !ok {
+		return assuanErrorf(errUnsupportedAlgorithm, "unsupported hash algorithm %s", fields[0])
+	}
+	digest, err := parseHex(fields[1])
+	if err != nil {
+		return err
+	}
+	s.hash, s.digest = algorithm, digest
+	return nil
+}
+
+// sign writes the signature of the hash made by the key, computed by the
+// backend as the signature of a prehashed input. The signature packet made by
+// the backend is discarded, the signature value only depends on the hash.
+func (s *session) sign() error {
+	if s.key == nil {
+		return assuanErrorf(errNoSecretKey, "no signing key set")
+	}
+	if !s.key.canSign && s.key.primary {
+		return assuanErrorf(errWrongKeyUsage, "the key %016X of %s is a primary key, primary keys only sign with -allow-certify", s.key.keyID, s.key.name)
+	}
+	if !s.key.canSign {
+		return assuanErrorf(errWrongKeyUsage, "the key %016X of %s is not a signing subkey", s.key.keyID, s.key.name)
+	}
+	if s.digest == nil {
+		return assuanErrorf(errParameter, "no hash set")
+	}
+	secret, err := s.agent.logical.Write(s.agent.mount+"/sign/"+s.key.name, map[string]interface{}{
+		"input":          base64.StdEncoding.EncodeToString(s.digest),
+		"algorithm":      s.hash,
+		"prehashed":      true,
+		"creation_time":  time.Now().UTC().Format(time.RFC3339),
+		"signing_key_id": fmt.Sprintf("%016X", s.key.keyID),
+	})
+	if err != nil && strings.Contains(err.Error(), errPrehashedNotAllowed) {
+		setting := "allow_prehashed"
+		if s.key.primary {
+			setting = "allow_prehashed and allow_prehashed_certify"
+		}
+		return assuanErrorf(errNotSupported, "the key %016X of %s cannot sign hashes, set %s in %s/keys/%s/config",
+			s.key.keyID, s.key.name, setting, s.agent.mount, s.key.name)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to sign with the key %s: %w", s.key.name, err)
+	}
+	if secret == nil {
+		return fmt.Errorf("unable to sign with the key %s: empty response", s.key.name)
+	}
+	encoded, _ := secret.Data["signature"].(string)
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("unable to decode the signature: %w", err)
+	}
+	p, err := packet.Read(bytes.NewReader(signature))
+	if err != nil {
+		return fmt.Errorf("unable to read the signature: %w", err)
+	}
+	sig, ok := p.(*packet.Signature)
+	if !ok || sig.PubKeyAlgo != packet.PubKeyAlgoRSA {
+		return errors.New("unexpected signature")
+	}
+
+	sigVal := sexp{"sig-val", sexp{"rsa", sexp{"s", sig.RSASignature.Bytes()}}}
+	return s.conn.writeData(sigVal.encode())
+}
+
+// decrypt asks the client for the encrypted session key and writes it
+// decrypted by the key. The backend decrypts a public-key encrypted session
+// key packet built from it, then the session key is padded as gpg expects the
+// plain RSA decryption result.
+func (s *session) decrypt() error {
+	if s.key == nil {
+		return assuanErrorf(errNoSecretKey, "no decryption key set")
+	}
+	data, err := s.conn.inquire("CIPHERTEXT")
+	if err != nil {
+		return err
+	}
+	encVal, err := parseSexp(data)
+	if err != nil {
+		return assuanErrorf(errParameter, "invalid ciphertext: %s", err)
+	}
+	if encVal.find("rsa") == nil || encVal.value("a") == nil {
+		return assuanErrorf(errNotSupported, "only RSA ciphertexts are supported")
+	}
+
+	encryptedKey, err := encryptedKeyPacket(s.key.keyID, encVal.value("a"))
+	if err != nil {
+		return err
+	}
+	secret, err := s.agent.logical.Write(s.agent.mount+"/show-session-key/"+s.key.name, map[string]interface{}{
+		"ciphertext": base64.StdEncoding.EncodeToString(encryptedKey),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to decrypt with the key %s: %w", s.key.name, err)
+	}
+	if secret == nil {
+		return fmt.Errorf("unable to decrypt with the key %s: empty response", s.key.name)
+	}
+	sessionKey, _ := secret.Data["session_key"].(string)
+	frame, err := sessionKeyFrame(sessionKey, (s.key.publicKey.N.BitLen()+7)/8)
+	if err != nil {
+		return err
+	}
+
+	value := sexp{"value", frame}
+	return s.conn.writeData(value.encode())
+}
+
+// encryptedKeyPacket returns a public-key encrypted session key packet for the
+// key holding the RSA ciphertext.
+func encryptedKeyPacket(keyID uint64, ciphertext []byte) ([]byte, error) {
+	ciphertext = bytes.TrimLeft(ciphertext, "\x00")
+	if len(ciphertext) == 0 || len(ciphertext) > 0xffff/8 {
+		return nil, assuanErrorf(errParameter, "invalid ciphertext")
+	}
+	body := []byte{3}
+	body = binary.BigEndian.AppendUint64(body, keyID)
+	body = append(body, byte(packet.PubKeyAlgoRSA))
+	body = binary.BigEndian.AppendUint16(body, uint16(new(big.Int).SetBytes(ciphertext).BitLen()))
+	body = append(body, ciphertext...)
+
+	// New format header of the public-key encrypted session key packet
+	encrypted := []byte{0xc1}
+	switch {
+	case len(body) < 192:
+		encrypted = append(encrypted, byte(len(body)))
+	case len(body) < 8384:
+		length := len(body) - 192
+		encrypted = append(encrypted, byte(length>>8)+192, byte(length))
+	default:
+		encrypted = append(encrypted, 0xff)
+		encrypted = binary.BigEndian.AppendUint32(encrypted, uint32(len(body)))
+	}
+	return append(encrypted, body...), nil
+}
+
+// sessionKeyFrame returns the session key returned by the backend, of the form
+// <algorithm>:<hex>, encoded and padded as in a PKCS #1 v1.5 encryption block
+// of the size of the modulus, without its leading zero byte.
+func sessionKeyFrame(sessionKey string, size int) ([]byte, error) {
+	algorithm, encoded, _ := strings.Cut(sessionKey, ":")
+	cipher, err := strconv.ParseUint(algorithm, 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session key returned by Vault")
+	}
+	key, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session key returned by Vault")
+	}
+	var checksum uint16
+	for _, b := range key {
+		checksum += uint16(b)
+	}
+	message := append([]byte{byte(cipher)}, key...)
+	message = binary.BigEndian.AppendUint16(message, checksum)
+
+	// At least 8 bytes of non-zero padding separated from the message by a
+	// zero byte
+	paddingLength := size - 1 - 2 - len(message)
+	if paddingLength < 8 {
+		return nil, fmt.Errorf("the session key is too long for the key")
+	}
+	padding := make([]byte, paddingLength)
+	for i := range padding {
+		for padding[i] == 0 {
+			if _, err := rand.Read(padding[i : i+1]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	frame := append([]byte{2}, padding...)
+	frame = append(frame, 0)
+	return append(frame, message...), nil
+}
+
+// parseHex decodes a hexadecimal string given by the client.
+func parseHex(s string) ([]byte, error) {
+	decoded, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, assuanErrorf(errParameter, "invalid hexadecimal string")
+	}
+	return decoded, nil
+}
@@ -0,0 +1,228 @@
+// Command vault-gpg-git signs and verifies git commits and tags with the keys
+// of the GPG secret backend. It implements the subset of the command line and
+// of the status protocol of gpg used by git and can be set as gpg.program:
+//
+//	git config gpg.program vault-gpg-git
+//	git config user.signingkey <name of the key in Vault>
+//
+// The Vault client is configured with the usual environment variables, such
+// as VAULT_ADDR and VAULT_TOKEN. The backend is expected to be mounted at gpg/,
+// VAULT_GPG_MOUNT can be set to use another mount. VAULT_GPG_KEY can be set to
+// sign with a key other than the one given by git.
+//
+// Every key of the mount is trusted: a signature made by any of them is
+// reported to git as a good signature with a full trust (TRUST_FULLY), whether
+// or not it is the key expected for the commit or the tag. Check the key name
+// or the fingerprint reported by git, e.g. with git log --show-signature, when
+// several keys are stored in the mount.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// Exit codes of gpg.
+const (
+	exitOK      = 0
+	exitBadSig  = 1
+	exitFailure = 2
+)
+
+const (
+	defaultMount  = "gpg"
+	statusPrefix  = "[GNUPG:] "
+	messagePrefix = "vault-gpg-git: "
+	stdinArgument = "-"
+	// statusDisabled is the status file descriptor when --status-fd is not set
+	statusDisabled = -1
+	stdoutFd       = 1
+	stderrFd       = 2
+)
+
+// vaultLogical is the part of the Vault logical API used by the command.
+type vaultLogical interface {
+	Read(path string) (*api.Secret, error)
+	Write(path string, data map[string]interface{}) (*api.Secret, error)
+}
+
+// options are the parsed gpg command line options.
+type options struct {
+	sign      bool
+	detach    bool
+	armor     bool
+	verify    bool
+	localUser string
+	statusFd  int
+	args      []string
+}
+
+func main() {
+	config := api.DefaultConfig()
+	if config.Error != nil {
+		fmt.Fprintln(os.Stderr, messagePrefix+config.Error.Error())
+		os.Exit(exitFailure)
+	}
+	client, err := api.NewClient(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, messagePrefix+err.Error())
+		os.Exit(exitFailure)
+	}
+
+	mount := os.Getenv("VAULT_GPG_MOUNT")
+	if mount == "" {
+		mount = defaultMount
+	}
+	c := &command{
+		logical: client.Logical(),
+		mount:   strings.Trim(mount, "/"),
+		key:     os.Getenv("VAULT_GPG_KEY"),
+		stdin:   os.Stdin,
+		stdout:  os.Stdout,
+		stderr:  os.Stderr,
+	}
+	os.Exit(c.run(os.Args[1:]))
+}
+
+// command runs gpg commands against the backend.
+type command struct {
+	logical vaultLogical
+	mount   string
+	key     string
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+	status  io.Writer
+}
+
+func (c *command) run(args []string) int {
+	opts, err := parseArgs(args)
+	if err != nil {
+		fmt.Fprintln(c.stderr, messagePrefix+err.Error())
+		return exitFailure
+	}
+
+	switch opts.statusFd {
+	case statusDisabled:
+		c.status = io.Discard
+	case stdoutFd:
+		c.status = c.stdout
+	case stderrFd:
+		c.status = c.stderr
+	default:
+		// os.NewFile does not check the file descriptor
+		status := os.NewFile(uintptr(opts.statusFd), "status")
+		if _, err := status.Stat(); err != nil {
+			fmt.Fprintf(c.stderr, "%sinvalid status file descriptor %d: %s\n", messagePrefix, opts.statusFd, err)
+			return exitFailure
+		}
+		defer status.Close()
+		c.status = status
+	}
+
+	switch {
+	case opts.verify:
+		return c.verify(opts)
+	case opts.sign && opts.detach:
+		return c.sign(opts)
+	default:
+		fmt.Fprintln(c.stderr, messagePrefix+"only detached signatures (-bs) and --verify are supported")
+		return exitFailure
+	}
+}
+
+// statusf writes a line on the status file descriptor.
+func (c *command) statusf(format string, args ...interface{}) {
+	fmt.Fprintf(c.status, statusPrefix+format+"\n", args...)
+}
+
+// parseArgs parses the gpg options used by git: --status-fd=2 -bsau <key> to
+// sign and --status-fd=1 --verify <signature> - to verify.
+func parseArgs(args []string) (*options, error) {
+	opts := &options{statusFd: statusDisabled}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			opts.args = append(opts.args, args[i+1:]...)
+			return opts, nil
+		case strings.HasPrefix(arg, "--"):
+			name, value, hasValue := strings.Cut(arg[2:], "=")
+			takeValue := func() (string, error) {
+				if hasValue {
+					return value, nil
+				}
+				if i+1 >= len(args) {
+					return "", fmt.Errorf("missing argument for option --%s", name)
+				}
+				i++
+				return args[i], nil
+			}
+			switch name {
+			case "detach-sign":
+				opts.sign, opts.detach = true, true
+			case "sign":
+				opts.sign = true
+			case "armor":
+				opts.armor = true
+			case "verify":
+				opts.verify = true
+			case "local-user":
+				user, err := takeValue()
+				if err != nil {
+					return nil, err
+				}
+				opts.localUser = user
+			case "status-fd":
+				fd, err := takeValue()
+				if err != nil {
+					return nil, err
+				}
+				opts.statusFd, err = strconv.Atoi(fd)
+				if err != nil || opts.statusFd < 0 {
+					return nil, fmt.Errorf("invalid status file descriptor %q", fd)
+				}
+			case "keyid-format":
+				if _, err := takeValue(); err != nil {
+					return nil, err
+				}
+			case "batch", "no-tty", "yes":
+			default:
+				return nil, fmt.Errorf("unsupported option --%s", name)
+			}
+		case strings.HasPrefix(arg, "-") && arg != stdinArgument:
+			for j := 1; j < len(arg); j++ {
+				switch arg[j] {
+				case 'b':
+					opts.sign, opts.detach = true, true
+				case 's':
+					opts.sign = true
+				case 'a':
+					opts.armor = true
+				case 'u':
+					// The key is the rest of the option or the next argument
+					if j+1 < len(arg) {
+						opts.localUser = arg[j+1:]
+					} else if i+1 < len(args) {
+						i++
+						opts.localUser = args[i]
+					} else {
+						return nil, errors.New("missing argument for option -u")
+					}
+					j = len(arg)
+				default:
+					return nil, fmt.Errorf("unsupported option -%c", arg[j])
+				}
+			}
+		default:
+			opts.args = append(opts.args, arg)
+		}
+	}
+	return opts, nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/LeSuisse/vault-gpg-plugin/internal/vaulttest"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+func TestGitSignVerify(t *testing.T) {
+	l := vaulttest.NewLogical()
+	if _, err := l.Write("gpg/keys/test", map[string]interface{}{
+		"real_name": "Vault GPG test",
+		"email":     "vault@example.com",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	key, err := l.Read("gpg/keys/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprint := strings.ToUpper(key.Data["fingerprint"].(string))
+
+	run := func(stdin string, args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		c := &command{
+			logical: l,
+			mount:   "gpg",
+			stdin:   strings.NewReader(stdin),
+			stdout:  &stdout,
+			stderr:  &stderr,
+		}
+		code := c.run(args)
+		return code, stdout.String(), stderr.String()
+	}
+
+	commit := "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+		"author Vault GPG <vault@example.com> 1700000000 +0100\n" +
+		"committer Vault GPG <vault@example.com> 1700000000 +0100\n" +
+		"\n" +
+		"Release 1.0\n"
+
+	// Arguments used by git to sign commits and tags
+	code, signature, status := run(commit, "--status-fd=2", "-bsau", "test")
+	if code != exitOK {
+		t.Fatalf("signing failed: %s", status)
+	}
+	if !regexp.MustCompile(`\n\[GNUPG:\] SIG_CREATED D 1 8 00 \d+ ` + fingerprint + `\n`).MatchString(status) {
+		t.Fatalf("expected a SIG_CREATED status line: %s", status)
+	}
+	if !strings.HasPrefix(signature, "-----BEGIN PGP SIGNATURE-----\n") {
+		t.Fatalf("expected an armored signature: %s", signature)
+	}
+
+	signatureFile := filepath.Join(t.TempDir(), "signature")
+	if err := os.WriteFile(signatureFile, []byte(signature), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Arguments used by git to verify commits and tags
+	verify := func(payload string) (int, string) {
+		code, status, _ := run(payload, "--keyid-format=long", "--status-fd=1", "--verify", signatureFile, "-")
+		return code, status
+	}
+	code, status = verify(commit)
+	if code != exitOK {
+		t.Fatalf("verification failed: %s", status)
+	}
+	keyID := fingerprint[len(fingerprint)-16:]
+	for _, line := range []string{
+		"[GNUPG:] GOODSIG " + keyID + " Vault GPG test <vault@example.com>\n",
+		" 0 4 0 1 8 00 " + fingerprint + "\n",
+		"[GNUPG:] TRUST_FULLY 0 pgp\n",
+	} {
+		if !strings.Contains(status, line) {
+			t.Fatalf("expected %q in the status: %s", line, status)
+		}
+	}
+
+	code, status = verify(strings.Replace(commit, "1.0", "2.0", 1))
+	if code != exitBadSig || !strings.Contains(status, "[GNUPG:] BADSIG "+keyID+" ") {
+		t.Fatalf("expected a bad signature: %s", status)
+	}
+
+	if _, err := l.Delete("gpg/keys/test"); err != nil {
+		t.Fatal(err)
+	}
+	code, status = verify(commit)
+	if code != exitFailure || !strings.Contains(status, "[GNUPG:] NO_PUBKEY "+keyID+"\n") {
+		t.Fatalf("expected an unknown key: %s", status)
+	}
+
+	code, _, status = run(commit, "--status-fd=2", "-bsau", "test")
+	if code != exitFailure || strings.Contains(status, "SIG_CREATED") {
+		t.Fatalf("expected the signing to fail: %s", status)
+	}
+	code, _, _ = run(commit, "--clearsign")
+	if code != exitFailure {
+		t.Fatal("expected unsupported options to be rejected")
+	}
+}
+
+func TestGitVerifyV6Signature(t *testing.T) {
+	entity, err := openpgp.NewEntity("Vault GPG v6", "", "v6@example.com", &packet.Config{
+		V6Keys:    true,
+		Algorithm: packet.PubKeyAlgoEd25519,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var key bytes.Buffer
+	w, err := armor.Encode(&key, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.SerializePrivate(w, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	l := vaulttest.NewLogical()
+	if _, err := l.Write("gpg/keys/v6", map[string]interface{}{
+		"generate": false,
+		"key":      key.String(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = l.Delete("gpg/keys/v6")
+	})
+
+	run := func(stdin string, args ...string) (int, string) {
+		var stdout, stderr bytes.Buffer
+		c := &command{
+			logical: l,
+			mount:   "gpg",
+			stdin:   strings.NewReader(stdin),
+			stdout:  &stdout,
+			stderr:  &stderr,
+		}
+		code := c.run(args)
+		return code, stdout.String() + stderr.String()
+	}
+
+	code, signature := run("payload", "-bsau", "v6")
+	if code != exitOK {
+		t.Fatalf("signing failed: %s", signature)
+	}
+	signatureFile := filepath.Join(t.TempDir(), "signature")
+	if err := os.WriteFile(signatureFile, []byte(signature), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	code, status := run("payload", "--status-fd=1", "--verify", signatureFile, "-")
+	if code != exitOK {
+		t.Fatalf("verification failed: %s", status)
+	}
+	fingerprint := strings.ToUpper(hex.EncodeToString(entity.PrimaryKey.Fingerprint))
+	line := fmt.Sprintf(" 0 6 0 %d 8 00 %s\n", packet.PubKeyAlgoEd25519, fingerprint)
+	if !strings.Contains(status, line) {
+		t.Fatalf("expected %q in the status: %s", line, status)
+	}
+}
+
+func TestGitInvalidStatusFd(t *testing.T) {
+	var stderr bytes.Buffer
+	c := &command{
+		logical: vaulttest.NewLogical(),
+		mount:   "gpg",
+		stdin:   strings.NewReader(""),
+		stdout:  &bytes.Buffer{},
+		stderr:  &stderr,
+	}
+	if code := c.run([]string{"--status-fd=1000", "-bsau", "test"}); code != exitFailure {
+		t.Fatalf("expected a failure, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "invalid status file descriptor 1000") {
+		t.Fatalf("expected the file descriptor to be reported as invalid: %s", stderr.String())
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	for _, test := range []struct {
+		args     []string
+		expected options
+	}{
+		{
+			[]string{"--status-fd=2", "-bsau", "key"},
+			options{sign: true, detach: true, armor: true, localUser: "key", statusFd: 2},
+		},
+		{
+			[]string{"--status-fd", "2", "-bsaukey"},
+			options{sign: true, detach: true, armor: true, localUser: "key", statusFd: 2},
+		},
+		{
+			[]string{"--detach-sign", "--armor", "--local-user=key", "-"},
+			options{sign: true, detach: true, armor: true, localUser: "key", statusFd: statusDisabled, args: []string{"-"}},
+		},
+		{
+			[]string{"--keyid-format=long", "--status-fd=1", "--verify", "signature", "-"},
+			options{verify: true, statusFd: 1, args: []string{"signature", "-"}},
+		},
+	} {
+		opts, err := parseArgs(test.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*opts, test.expected) {
+			t.Fatalf("unexpected options for %v: %#v", test.args, opts)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"-bsau"},
+		{"--status-fd=stderr"},
+		{"-e"},
+		{"--encrypt"},
+	} {
+		if _, err := parseArgs(args); err == nil {
+			t.Fatalf("expected an error for %v", args)
+		}
+	}
+}
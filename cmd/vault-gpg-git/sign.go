@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// sign writes the detached signature of the standard input made by the key
+// named after the local user, or VAULT_GPG_KEY when it is set.
+func (c *command) sign(opts *options) int {
+	name := c.key
+	if name == "" {
+		name = opts.localUser
+	}
+	if name == "" {
+		fmt.Fprintln(c.stderr, messagePrefix+"no signing key; use -u or set VAULT_GPG_KEY")
+		return exitFailure
+	}
+	if len(opts.args) > 1 || (len(opts.args) == 1 && opts.args[0] != stdinArgument) {
+		fmt.Fprintln(c.stderr, messagePrefix+"only the standard input can be signed")
+		return exitFailure
+	}
+
+	input, err := io.ReadAll(c.stdin)
+	if err != nil {
+		fmt.Fprintln(c.stderr, messagePrefix+err.Error())
+		return exitFailure
+	}
+	secret, err := c.logical.Write(c.mount+"/sign/"+name, map[string]interface{}{
+		"input": base64.StdEncoding.EncodeToString(input),
+	})
+	if err != nil {
+		fmt.Fprintf(c.stderr, "%ssigning failed: %s\n", messagePrefix, err)
+		return exitFailure
+	}
+	if secret == nil {
+		fmt.Fprintln(c.stderr, messagePrefix+"signing failed: empty response")
+		return exitFailure
+	}
+	encoded, _ := secret.Data["signature"].(string)
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "%sunable to decode the signature: %s\n", messagePrefix, err)
+		return exitFailure
+	}
+	sig, _, err := readSignature(signature)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "%sunable to read the signature: %s\n", messagePrefix, err)
+		return exitFailure
+	}
+
+	hashID, _ := openpgp.HashToHashId(sig.Hash)
+	c.statusf("BEGIN_SIGNING H%d", hashID)
+	c.statusf("SIG_CREATED D %d %d %02x %d %s", sig.PubKeyAlgo, hashID, uint8(sig.SigType), sig.CreationTime.Unix(), issuerFingerprint(sig))
+
+	if !opts.armor {
+		if _, err := c.stdout.Write(signature); err != nil {
+			fmt.Fprintln(c.stderr, messagePrefix+err.Error())
+			return exitFailure
+		}
+		return exitOK
+	}
+	w, err := armor.Encode(c.stdout, openpgp.SignatureType, nil)
+	if err == nil {
+		_, err = w.Write(signature)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		_, err = io.WriteString(c.stdout, "\n")
+	}
+	if err != nil {
+		fmt.Fprintln(c.stderr, messagePrefix+err.Error())
+		return exitFailure
+	}
+	return exitOK
+}
+
+// readSignature returns the first signature packet of a binary or armored
+// signature and the binary signature.
+func readSignature(data []byte) (*packet.Signature, []byte, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN ")) {
+		block, err := armor.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, nil, err
+		}
+		if block.Type != openpgp.SignatureType {
+			return nil, nil, fmt.Errorf("unexpected armor type %s", block.Type)
+		}
+		if data, err = io.ReadAll(block.Body); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	p, err := packet.Read(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	sig, ok := p.(*packet.Signature)
+	if !ok {
+		return nil, nil, errors.New("not a signature")
+	}
+	return sig, data, nil
+}
+
+// issuerFingerprint returns the fingerprint of the key that made the
+// signature, or its key ID when the signature has no issuer fingerprint.
+func issuerFingerprint(sig *packet.Signature) string {
+	if sig.IssuerFingerprint != nil {
+		return strings.ToUpper(fmt.Sprintf("%x", sig.IssuerFingerprint))
+	}
+	return issuerKeyID(sig)
+}
+
+// issuerKeyID returns the long key ID of the key that made the signature.
+func issuerKeyID(sig *packet.Signature) string {
+	switch {
+	case sig.IssuerKeyId != nil:
+		return fmt.Sprintf("%016X", *sig.IssuerKeyId)
+	case len(sig.IssuerFingerprint) >= 8:
+		// The key ID of v4 keys are the last 8 bytes of the fingerprint
+		return fmt.Sprintf("%X", sig.IssuerFingerprint[len(sig.IssuerFingerprint)-8:])
+	default:
+		return "0000000000000000"
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// Reasons returned by the verify endpoint, reported with their gpg status.
+var verifyStatuses = map[string]struct {
+	status  string
+	message string
+}{
+	"bad_signature":     {"BADSIG", "BAD signature from"},
+	"key_expired":       {"EXPKEYSIG", "Good signature from an expired key"},
+	"key_revoked":       {"REVKEYSIG", "Good signature from a revoked key"},
+	"signature_expired": {"EXPSIG", "Expired signature from"},
+}
+
+// verify verifies a detached signature of the standard input, or of a file,
+// with the keys of the mount.
+func (c *command) verify(opts *options) int {
+	if len(opts.args) != 2 {
+		fmt.Fprintln(c.stderr, messagePrefix+"only detached signatures can be verified: --verify <signature> <file|->")
+		return exitFailure
+	}
+	signatureData, err := os.ReadFile(opts.args[0])
+	if err != nil {
+		fmt.Fprintln(c.stderr, messagePrefix+err.Error())
+		return exitFailure
+	}
+	var input []byte
+	if opts.args[1] == stdinArgument {
+		input, err = io.ReadAll(c.stdin)
+	} else {
+		input, err = os.ReadFile(opts.args[1])
+	}
+	if err != nil {
+		fmt.Fprintln(c.stderr, messagePrefix+err.Error())
+		return exitFailure
+	}
+
+	sig, signature, err := readSignature(signatureData)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "%sno valid signature found: %s\n", messagePrefix, err)
+		c.statusf("NODATA 3")
+		return exitFailure
+	}
+	c.statusf("NEWSIG")
+	fmt.Fprintf(c.stderr, "%sSignature made %s\n", messagePrefix, sig.CreationTime.UTC().Format("Mon Jan 2 15:04:05 2006 MST"))
+	fmt.Fprintf(c.stderr, "%s               using key %s\n", messagePrefix, issuerFingerprint(sig))
+
+	secret, err := c.logical.Write(c.mount+"/verify", map[string]interface{}{
+		"input":     base64.StdEncoding.EncodeToString(input),
+		"signature": base64.StdEncoding.EncodeToString(signature),
+	})
+	if err != nil {
+		fmt.Fprintf(c.stderr, "%sverification failed: %s\n", messagePrefix, err)
+		return exitFailure
+	}
+	if secret == nil {
+		fmt.Fprintln(c.stderr, messagePrefix+"verification failed: empty response")
+		return exitFailure
+	}
+
+	hashID, _ := openpgp.HashToHashId(sig.Hash)
+	keyID := issuerKeyID(sig)
+	valid, _ := secret.Data["valid"].(bool)
+	reason := stringField(secret.Data, "reason")
+	name := stringField(secret.Data, "key_name")
+
+	if valid {
+		userID := c.userID(name)
+		fingerprint := strings.ToUpper(stringField(secret.Data, "fingerprint"))
+		signingFingerprint := fingerprint
+		if subkey := stringField(secret.Data, "subkey_fingerprint"); subkey != "" {
+			signingFingerprint = strings.ToUpper(subkey)
+		}
+		c.statusf("GOODSIG %s %s", keyID, userID)
+		c.statusf("VALIDSIG %s %s %d 0 %d 0 %d %d %02x %s", signingFingerprint, sig.CreationTime.UTC().Format("2006-01-02"),
+			sig.CreationTime.Unix(), sig.Version, sig.PubKeyAlgo, hashID, uint8(sig.SigType), fingerprint)
+		// The keys of the mount are the keys trusted by the operator of Vault,
+		// whichever key of the mount made the signature
+		c.statusf("TRUST_FULLY 0 pgp")
+		fmt.Fprintf(c.stderr, "%sGood signature from \"%s\" (key %s in Vault)\n", messagePrefix, userID, name)
+		return exitOK
+	}
+
+	if status, ok := verifyStatuses[reason]; ok && name != "" {
+		userID := c.userID(name)
+		c.statusf("%s %s %s", status.status, keyID, userID)
+		fmt.Fprintf(c.stderr, "%s%s \"%s\" (key %s in Vault)\n", messagePrefix, status.message, userID, name)
+		return exitBadSig
+	}
+
+	// The signature could not be checked, the return code 9 means that the
+	// key is missing and 4 that the signature uses an unsupported algorithm
+	code := 4
+	if reason == "unknown_issuer" {
+		code = 9
+	}
+	c.statusf("ERRSIG %s %d %d %02x %d %d", keyID, sig.PubKeyAlgo, hashID, uint8(sig.SigType), sig.CreationTime.Unix(), code)
+	if code == 9 {
+		c.statusf("NO_PUBKEY %s", keyID)
+	}
+	fmt.Fprintf(c.stderr, "%sCan't check signature: %s\n", messagePrefix, reason)
+	return exitFailure
+}
+
+// userID returns the primary user ID of the named key, or the name of the key
+// when it cannot be read.
+func (c *command) userID(name string) string {
+	secret, err := c.logical.Read(c.mount + "/keys/" + name)
+	if err != nil || secret == nil {
+		return name
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(stringField(secret.Data, "public_key")))
+	if err != nil || len(keyring) == 0 {
+		return name
+	}
+	if identity := keyring[0].PrimaryIdentity(); identity != nil {
+		return identity.Name
+	}
+	return name
+}
+
+func stringField(data map[string]interface{}, name string) string {
+	value, _ := data[name].(string)
+	return value
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// sessionCipher is the cipher of the messages encrypted by encrypt, supported
+// by all the OpenPGP implementations.
+const sessionCipher = packet.CipherAES256
+
+// sessionKey is the session key of an encrypted message.
+type sessionKey struct {
+	cipher packet.CipherFunction
+	key    []byte
+}
+
+func (k *sessionKey) String() string {
+	return fmt.Sprintf("%d:%X", k.cipher, k.key)
+}
+
+// parseSessionKey parses a session key of the form <algorithm>:<hex>, as
+// returned by the show-session-key endpoint.
+func parseSessionKey(value string) (*sessionKey, error) {
+	algorithm, encoded, ok := strings.Cut(strings.TrimSpace(value), ":")
+	cipher, err := strconv.ParseUint(algorithm, 10, 8)
+	if !ok || err != nil {
+		return nil, sopErrorf(exitBadData, "invalid session key")
+	}
+	key, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, sopErrorf(exitBadData, "invalid session key")
+	}
+	return &sessionKey{cipher: packet.CipherFunction(cipher), key: key}, nil
+}
+
+// encrypt writes the standard input encrypted for the certificates and the
+// passwords, optionally signed by the keys given with --sign-with.
+func (c *command) encrypt(args *arguments) error {
+	if profile := args.value("profile"); profile != "" && profile != "default" {
+		return sopErrorf(exitUnsupportedProfile, "unsupported profile %s", profile)
+	}
+	if len(args.positional) == 0 && len(args.values["with-password"]) == 0 {
+		return sopErrorf(exitMissingArg, "at least one certificate or password is required")
+	}
+	var signers []string
+	if len(args.values["sign-with"]) > 0 {
+		var err error
+		if signers, err = keyNames(args.values["sign-with"]); err != nil {
+			return err
+		}
+	}
+
+	var encryptionKeys []*packet.PublicKey
+	if len(args.positional) > 0 {
+		certs, err := readCerts(args.positional)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, cert := range certs {
+			key, ok := cert.EncryptionKey(now)
+			if !ok {
+				return sopErrorf(exitCertCannotEncrypt, "the certificate %X has no valid encryption key", cert.PrimaryKey.Fingerprint)
+			}
+			encryptionKeys = append(encryptionKeys, key.PublicKey)
+		}
+	}
+	var passwords [][]byte
+	for _, name := range args.values["with-password"] {
+		password, err := readPassword(name)
+		if err != nil {
+			return err
+		}
+		passwords = append(passwords, password)
+	}
+
+	input, err := io.ReadAll(c.stdin)
+	if err != nil {
+		return err
+	}
+	mode, err := signatureMode(args, input, "binary", "text")
+	if err != nil {
+		return err
+	}
+	signatures := make([]*vaultSignature, 0, len(signers))
+	for _, name := range signers {
+		signature, err := c.signWithVault(name, input, map[string]interface{}{"mode": mode})
+		if err != nil {
+			return err
+		}
+		signatures = append(signatures, signature)
+	}
+	message, err := signedMessage(input, mode == "binary", signatures)
+	if err != nil {
+		return err
+	}
+
+	key := make([]byte, sessionCipher.KeySize())
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	config := &packet.Config{DefaultCipher: sessionCipher}
+	var encrypted bytes.Buffer
+	for _, encryptionKey := range encryptionKeys {
+		if err := packet.SerializeEncryptedKey(&encrypted, encryptionKey, sessionCipher, key, config); err != nil {
+			return sopErrorf(exitCertCannotEncrypt, "unable to encrypt for the key %X: %s", encryptionKey.Fingerprint, err)
+		}
+	}
+	for _, password := range passwords {
+		if err := packet.SerializeSymmetricKeyEncryptedReuseKey(&encrypted, key, password, config); err != nil {
+			return err
+		}
+	}
+	w, err := packet.SerializeSymmetricallyEncrypted(&encrypted, sessionCipher, false, packet.CipherSuite{}, key, config)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(message); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	return c.writeArmored(args, "PGP MESSAGE", encrypted.Bytes())
+}
+
+// decrypt writes the plaintext of the encrypted message read on the standard
+// input. The session key is given, decrypted with a password or decrypted by
+// Vault with one of the keys.
+func (c *command) decrypt(args *arguments) error {
+	sessionKeyOut := args.value("session-key-out")
+	verificationsOut := args.value("verifications-out")
+	if err := checkOutputs(sessionKeyOut, verificationsOut); err != nil {
+		return err
+	}
+	p, err := parsePeriod(args.value("verify-not-before"), args.value("verify-not-after"))
+	if err != nil {
+		return err
+	}
+	var certs openpgp.EntityList
+	if len(args.values["verify-with"]) > 0 {
+		if certs, err = readCerts(args.values["verify-with"]); err != nil {
+			return err
+		}
+	}
+	var names []string
+	if len(args.positional) > 0 {
+		if names, err = keyNames(args.positional); err != nil {
+			return err
+		}
+	}
+	var sessionKeys []*sessionKey
+	for _, name := range args.values["with-session-key"] {
+		data, err := readInput(name)
+		if err != nil {
+			return err
+		}
+		key, err := parseSessionKey(string(data))
+		if err != nil {
+			return err
+		}
+		sessionKeys = append(sessionKeys, key)
+	}
+	if len(names) == 0 && len(sessionKeys) == 0 && len(args.values["with-password"]) == 0 {
+		return sopErrorf(exitMissingArg, "at least one key, session key or password is required")
+	}
+
+	data, err := io.ReadAll(c.stdin)
+	if err != nil {
+		return err
+	}
+	ciphertext, err := dearmor(data)
+	if err != nil {
+		return err
+	}
+
+	// The packets before the encrypted data hold the encrypted session keys
+	var symmetricKeys []*packet.SymmetricKeyEncrypted
+	r := bytes.NewReader(ciphertext)
+	dataStart := -1
+	for dataStart == -1 {
+		start := len(ciphertext) - r.Len()
+		pkt, err := packet.Read(r)
+		if err != nil {
+			return sopErrorf(exitBadData, "unable to read the encrypted message: %s", err)
+		}
+		switch pkt := pkt.(type) {
+		case *packet.SymmetricKeyEncrypted:
+			symmetricKeys = append(symmetricKeys, pkt)
+		case packet.EncryptedDataPacket:
+			dataStart = start
+		}
+	}
+
+	for _, name := range args.values["with-password"] {
+		password, err := readPassword(name)
+		if err != nil {
+			return err
+		}
+		for _, symmetricKey := range symmetricKeys {
+			if key, cipher, err := symmetricKey.Decrypt(password); err == nil {
+				sessionKeys = append(sessionKeys, &sessionKey{cipher: cipher, key: key})
+			}
+		}
+	}
+
+	try := func(key *sessionKey) (bool, error) {
+		plaintext, verifications, err := decryptData(ciphertext[dataStart:], key, certs, p)
+		if err != nil {
+			return false, nil
+		}
+		if sessionKeyOut != "" {
+			if err := writeOutput(sessionKeyOut, []byte(key.String()+"\n")); err != nil {
+				return true, err
+			}
+		}
+		if verificationsOut != "" {
+			output := ""
+			if len(verifications) > 0 {
+				output = strings.Join(verifications, "\n") + "\n"
+			}
+			if err := writeOutput(verificationsOut, []byte(output)); err != nil {
+				return true, err
+			}
+		}
+		_, err = c.stdout.Write(plaintext)
+		return true, err
+	}
+
+	for _, key := range sessionKeys {
+		if ok, err := try(key); ok {
+			return err
+		}
+	}
+	// The keys in Vault are only used when the other ones do not work
+	for _, name := range names {
+		key, err := c.sessionKeyFromVault(name, ciphertext)
+		if err != nil {
+			continue
+		}
+		if ok, err := try(key); ok {
+			return err
+		}
+	}
+	return sopErrorf(exitCannotDecrypt, "unable to decrypt the message")
+}
+
+// sessionKeyFromVault returns the session key of the message decrypted by the
+// named key.
+func (c *command) sessionKeyFromVault(name string, ciphertext []byte) (*sessionKey, error) {
+	logical, err := c.vault()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := logical.Write(c.mount+"/show-session-key/"+name, map[string]interface{}{
+		"ciphertext": base64.StdEncoding.EncodeToString(ciphertext),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("empty response")
+	}
+	value, _ := secret.Data["session_key"].(string)
+	return parseSessionKey(value)
+}
+
+// decryptData decrypts the encrypted data packet with the session key and
+// returns the plaintext and the verifications of its signatures.
+func decryptData(data []byte, key *sessionKey, certs openpgp.EntityList, p period) ([]byte, []string, error) {
+	pkt, err := packet.Read(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	encrypted, ok := pkt.(packet.EncryptedDataPacket)
+	if !ok {
+		return nil, nil, fmt.Errorf("not an encrypted data packet")
+	}
+	decrypted, err := encrypted.Decrypt(key.cipher, key.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer decrypted.Close()
+
+	// The signatures are verified below, all of them and not only the first
+	// one made by a certificate
+	md, err := openpgp.ReadMessage(decrypted, nil, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	plaintext, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The integrity of the message is checked once all the data is read
+	if _, err = io.Copy(io.Discard, decrypted); err != nil {
+		return nil, nil, err
+	}
+	if err = decrypted.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	if !md.IsSigned || md.SignatureError != nil {
+		return plaintext, nil, nil
+	}
+	var signatures bytes.Buffer
+	for _, sig := range md.UnverifiedSignatures {
+		if err = sig.Serialize(&signatures); err != nil {
+			return nil, nil, err
+		}
+	}
+	verifications, err := verifyDetached(certs, plaintext, signatures.Bytes(), p)
+	if err != nil {
+		return nil, nil, err
+	}
+	return plaintext, verifications, nil
+}
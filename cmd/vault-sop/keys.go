@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/hashicorp/go-uuid"
+)
+
+// keyReferencePrefix starts the key references, followed by the name of the
+// key in Vault.
+const keyReferencePrefix = "vault-gpg:"
+
+// keyNames returns the names of the keys referenced by the KEYS arguments.
+func keyNames(references []string) ([]string, error) {
+	if len(references) == 0 {
+		return nil, sopErrorf(exitMissingArg, "at least one key is required")
+	}
+	names := make([]string, 0, len(references))
+	for _, reference := range references {
+		data, err := readInput(reference)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := strings.CutPrefix(strings.TrimSpace(string(data)), keyReferencePrefix)
+		if !ok || name == "" || strings.ContainsAny(name, "/ \t\r\n") {
+			return nil, sopErrorf(exitBadData, "%s is not a key reference; the secret keys must be held by Vault and referenced as %s<name>", reference, keyReferencePrefix)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// readCerts reads the certificates given as CERTS arguments.
+func readCerts(names []string) (openpgp.EntityList, error) {
+	if len(names) == 0 {
+		return nil, sopErrorf(exitMissingArg, "at least one certificate is required")
+	}
+	var keyring openpgp.EntityList
+	for _, name := range names {
+		data, err := readInput(name)
+		if err != nil {
+			return nil, err
+		}
+		var certs openpgp.EntityList
+		if isArmored(data) {
+			certs, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+		} else {
+			certs, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+		}
+		if err != nil {
+			return nil, sopErrorf(exitBadData, "unable to read the certificates of %s: %s", name, err)
+		}
+		keyring = append(keyring, certs...)
+	}
+	return keyring, nil
+}
+
+// isArmored returns whether the data is ASCII-armored.
+func isArmored(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN "))
+}
+
+// dearmor returns the binary content of armored data, and binary data as is.
+func dearmor(data []byte) ([]byte, error) {
+	if !isArmored(data) {
+		return data, nil
+	}
+	block, err := armor.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, sopErrorf(exitBadData, "unable to decode the armor: %s", err)
+	}
+	binary, err := io.ReadAll(block.Body)
+	if err != nil {
+		return nil, sopErrorf(exitBadData, "unable to decode the armor: %s", err)
+	}
+	return binary, nil
+}
+
+// writeArmored writes binary data, ASCII-armored unless --no-armor is set.
+func (c *command) writeArmored(args *arguments, blockType string, data []byte) error {
+	if args.flags["no-armor"] {
+		_, err := c.stdout.Write(data)
+		return err
+	}
+	return writeArmor(c.stdout, blockType, data)
+}
+
+// writeArmor writes the ASCII-armored data, followed by a line break.
+func writeArmor(w io.Writer, blockType string, data []byte) error {
+	armored, err := armor.Encode(w, blockType, nil)
+	if err != nil {
+		return err
+	}
+	if _, err = armored.Write(data); err != nil {
+		return err
+	}
+	if err = armored.Close(); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// generateKey generates a key in Vault and writes its reference. The name of
+// the key is random.
+func (c *command) generateKey(args *arguments) error {
+	switch {
+	case args.value("with-key-password") != "":
+		return sopErrorf(exitUnsupportedOption, "the keys held by Vault are not protected by a password")
+	case args.flags["signing-only"]:
+		return sopErrorf(exitUnsupportedOption, "the keys generated by Vault always have an encryption subkey")
+	case args.value("profile") != "" && args.value("profile") != "default":
+		return sopErrorf(exitUnsupportedProfile, "unsupported profile %s", args.value("profile"))
+	case len(args.positional) > 1:
+		return sopErrorf(exitUnsupportedOption, "the keys generated by Vault have a single user ID")
+	}
+
+	data := map[string]interface{}{}
+	if len(args.positional) == 1 {
+		realName, comment, email, err := parseUserID(args.positional[0])
+		if err != nil {
+			return err
+		}
+		data["real_name"] = realName
+		data["comment"] = comment
+		data["email"] = email
+	}
+
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return err
+	}
+	name := "sop-" + id
+	logical, err := c.vault()
+	if err != nil {
+		return err
+	}
+	if _, err := logical.Write(c.mount+"/keys/"+name, data); err != nil {
+		return fmt.Errorf("unable to generate the key: %w", err)
+	}
+
+	_, err = fmt.Fprintln(c.stdout, keyReferencePrefix+name)
+	return err
+}
+
+// parseUserID splits a user ID of the form "Name (Comment) <email>".
+func parseUserID(userID string) (string, string, string, error) {
+	rest := strings.TrimSpace(userID)
+	var email, comment string
+	if strings.HasSuffix(rest, ">") {
+		i := strings.LastIndex(rest, "<")
+		if i == -1 {
+			return "", "", "", sopErrorf(exitBadData, "invalid user ID %q", userID)
+		}
+		email = rest[i+1 : len(rest)-1]
+		rest = strings.TrimSpace(rest[:i])
+	}
+	if strings.HasSuffix(rest, ")") {
+		i := strings.LastIndex(rest, "(")
+		if i == -1 {
+			return "", "", "", sopErrorf(exitBadData, "invalid user ID %q", userID)
+		}
+		comment = rest[i+1 : len(rest)-1]
+		rest = strings.TrimSpace(rest[:i])
+	}
+	if strings.ContainsAny(rest+comment+email, "()<>\x00") {
+		return "", "", "", sopErrorf(exitBadData, "invalid user ID %q", userID)
+	}
+	return rest, comment, email, nil
+}
+
+// extractCert writes the certificate of the referenced key.
+func (c *command) extractCert(args *arguments) error {
+	if len(args.positional) > 0 {
+		return sopErrorf(exitUnsupportedOption, "unexpected argument %s", args.positional[0])
+	}
+	data, err := io.ReadAll(c.stdin)
+	if err != nil {
+		return err
+	}
+	name, ok := strings.CutPrefix(strings.TrimSpace(string(data)), keyReferencePrefix)
+	if !ok || name == "" {
+		return sopErrorf(exitBadData, "the input is not a key reference")
+	}
+
+	logical, err := c.vault()
+	if err != nil {
+		return err
+	}
+	secret, err := logical.Read(c.mount + "/keys/" + name)
+	if err != nil {
+		return fmt.Errorf("unable to read the key %s: %w", name, err)
+	}
+	if secret == nil {
+		return sopErrorf(exitBadData, "the key %s does not exist", name)
+	}
+	publicKey, _ := secret.Data["public_key"].(string)
+	cert, err := dearmor([]byte(publicKey))
+	if err != nil {
+		return err
+	}
+	return c.writeArmored(args, openpgp.PublicKeyType, cert)
+}
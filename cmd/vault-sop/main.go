@@ -0,0 +1,341 @@
+// Command vault-sop implements the Stateless OpenPGP command line interface
+// (SOP) with the keys of the GPG secret backend.
+//
+// The secret keys never leave Vault: the KEYS given to the subcommands are key
+// references, small text files produced by generate-key containing
+// "vault-gpg:<name of the key>". The operations needing a secret key are
+// delegated to the backend, the other ones are done locally.
+//
+// The Vault client is configured with the usual environment variables, such
+// as VAULT_ADDR and VAULT_TOKEN. The backend is expected to be mounted at gpg/,
+// VAULT_GPG_MOUNT can be set to use another mount.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// Exit codes defined by the SOP specification.
+const (
+	exitOK                       = 0
+	exitNoSignature              = 3
+	exitCertCannotEncrypt        = 17
+	exitMissingArg               = 19
+	exitCannotDecrypt            = 29
+	exitUnsupportedOption        = 37
+	exitBadData                  = 41
+	exitExpectedText             = 53
+	exitOutputExists             = 59
+	exitMissingInput             = 61
+	exitUnsupportedSubcommand    = 69
+	exitUnsupportedSpecialPrefix = 71
+	exitKeyCannotSign            = 79
+	exitIncompatibleOptions      = 83
+	exitUnsupportedProfile       = 89
+)
+
+const (
+	defaultMount  = "gpg"
+	messagePrefix = "vault-sop: "
+	// sopSpec is the version of the specification, the tilde marks a partial
+	// implementation
+	sopSpec = "~draft-dkg-openpgp-stateless-cli-10"
+)
+
+// vaultLogical is the part of the Vault logical API used by the command.
+type vaultLogical interface {
+	Read(path string) (*api.Secret, error)
+	Write(path string, data map[string]interface{}) (*api.Secret, error)
+}
+
+// sopError is an error reported with one of the exit codes of SOP.
+type sopError struct {
+	code int
+	err  error
+}
+
+func (e *sopError) Error() string {
+	return e.err.Error()
+}
+
+func sopErrorf(code int, format string, args ...interface{}) error {
+	return &sopError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+func main() {
+	c := &command{
+		mount:  os.Getenv("VAULT_GPG_MOUNT"),
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	}
+	if c.mount == "" {
+		c.mount = defaultMount
+	}
+	c.mount = strings.Trim(c.mount, "/")
+	// The client is only created when a subcommand needs Vault
+	c.newLogical = func() (vaultLogical, error) {
+		config := api.DefaultConfig()
+		if config.Error != nil {
+			return nil, config.Error
+		}
+		client, err := api.NewClient(config)
+		if err != nil {
+			return nil, err
+		}
+		return client.Logical(), nil
+	}
+	os.Exit(c.run(os.Args[1:]))
+}
+
+// command runs SOP subcommands.
+type command struct {
+	newLogical func() (vaultLogical, error)
+	logical    vaultLogical
+	mount      string
+	stdin      io.Reader
+	stdout     io.Writer
+	stderr     io.Writer
+}
+
+// subcommands are the implemented subcommands, with the options they accept.
+// The options taking a value are suffixed by "=".
+var subcommands = map[string]struct {
+	options []string
+	run     func(c *command, args *arguments) error
+}{
+	"version":      {[]string{"backend", "extended", "sop-spec"}, (*command).version},
+	"generate-key": {[]string{"no-armor", "with-key-password=", "profile=", "signing-only"}, (*command).generateKey},
+	"extract-cert": {[]string{"no-armor"}, (*command).extractCert},
+	"sign":         {[]string{"no-armor", "as=", "micalg-out=", "with-key-password="}, (*command).sign},
+	"verify":       {[]string{"not-before=", "not-after="}, (*command).verify},
+	"inline-sign":  {[]string{"no-armor", "as=", "with-key-password="}, (*command).inlineSign},
+	"encrypt": {[]string{"no-armor", "as=", "with-password=", "sign-with=", "with-key-password=", "profile="},
+		(*command).encrypt},
+	"decrypt": {[]string{"session-key-out=", "with-session-key=", "with-password=", "with-key-password=",
+		"verifications-out=", "verify-with=", "verify-not-before=", "verify-not-after="}, (*command).decrypt},
+}
+
+func (c *command) run(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(c.stderr, messagePrefix+"missing subcommand")
+		return exitMissingArg
+	}
+	subcommand, ok := subcommands[args[0]]
+	if !ok {
+		fmt.Fprintf(c.stderr, "%sunsupported subcommand %s\n", messagePrefix, args[0])
+		return exitUnsupportedSubcommand
+	}
+
+	err := func() error {
+		parsed, err := parseArguments(args[1:], subcommand.options)
+		if err != nil {
+			return err
+		}
+		return subcommand.run(c, parsed)
+	}()
+	if err == nil {
+		return exitOK
+	}
+	fmt.Fprintln(c.stderr, messagePrefix+err.Error())
+	var sopErr *sopError
+	if errors.As(err, &sopErr) {
+		return sopErr.code
+	}
+	return 1
+}
+
+// vault returns the client of the Vault logical API.
+func (c *command) vault() (vaultLogical, error) {
+	if c.logical == nil {
+		logical, err := c.newLogical()
+		if err != nil {
+			return nil, err
+		}
+		c.logical = logical
+	}
+	return c.logical, nil
+}
+
+// arguments are the parsed arguments of a subcommand.
+type arguments struct {
+	flags      map[string]bool
+	values     map[string][]string
+	positional []string
+}
+
+// value returns the last value of an option.
+func (a *arguments) value(name string) string {
+	values := a.values[name]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
+
+// parseArguments parses the options of a subcommand, given as --name or
+// --name=value.
+func parseArguments(args []string, options []string) (*arguments, error) {
+	parsed := &arguments{
+		flags:  make(map[string]bool),
+		values: make(map[string][]string),
+	}
+	for i, arg := range args {
+		if arg == "--" {
+			parsed.positional = append(parsed.positional, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "--") {
+			parsed.positional = append(parsed.positional, arg)
+			continue
+		}
+		name, value, hasValue := strings.Cut(arg[2:], "=")
+		switch {
+		case hasValue && slices.Contains(options, name+"="):
+			parsed.values[name] = append(parsed.values[name], value)
+		case !hasValue && slices.Contains(options, name+"="):
+			return nil, sopErrorf(exitMissingArg, "missing value for option --%s", name)
+		case !hasValue && slices.Contains(options, name):
+			parsed.flags[name] = true
+		default:
+			return nil, sopErrorf(exitUnsupportedOption, "unsupported option %s", arg)
+		}
+	}
+	return parsed, nil
+}
+
+// readInput reads an input given on the command line. Besides file names, the
+// special designators @ENV:<variable> and @FD:<file descriptor> are supported.
+func readInput(name string) ([]byte, error) {
+	if !strings.HasPrefix(name, "@") {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, sopErrorf(exitMissingInput, "unable to read %s: %s", name, err)
+		}
+		return data, nil
+	}
+
+	switch {
+	case strings.HasPrefix(name, "@ENV:"):
+		value, ok := os.LookupEnv(strings.TrimPrefix(name, "@ENV:"))
+		if !ok {
+			return nil, sopErrorf(exitMissingInput, "environment variable %s is not set", strings.TrimPrefix(name, "@ENV:"))
+		}
+		return []byte(value), nil
+	case strings.HasPrefix(name, "@FD:"):
+		fd, err := strconv.ParseUint(strings.TrimPrefix(name, "@FD:"), 10, 32)
+		if err != nil {
+			return nil, sopErrorf(exitMissingInput, "invalid file descriptor in %s", name)
+		}
+		f := os.NewFile(uintptr(fd), name)
+		if f == nil {
+			return nil, sopErrorf(exitMissingInput, "invalid file descriptor in %s", name)
+		}
+		defer f.Close()
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return nil, sopErrorf(exitMissingInput, "unable to read %s: %s", name, err)
+		}
+		return data, nil
+	default:
+		return nil, sopErrorf(exitUnsupportedSpecialPrefix, "unsupported special designator %s", name)
+	}
+}
+
+// writeOutput writes an indirect output given on the command line. Files must
+// not already exist, @FD:<file descriptor> is supported.
+func writeOutput(name string, data []byte) error {
+	if strings.HasPrefix(name, "@FD:") {
+		fd, err := strconv.ParseUint(strings.TrimPrefix(name, "@FD:"), 10, 32)
+		if err != nil {
+			return sopErrorf(exitUnsupportedSpecialPrefix, "invalid file descriptor in %s", name)
+		}
+		f := os.NewFile(uintptr(fd), name)
+		if f == nil {
+			return sopErrorf(exitUnsupportedSpecialPrefix, "invalid file descriptor in %s", name)
+		}
+		defer f.Close()
+		_, err = f.Write(data)
+		return err
+	}
+	if strings.HasPrefix(name, "@") {
+		return sopErrorf(exitUnsupportedSpecialPrefix, "unsupported special designator %s", name)
+	}
+
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		return sopErrorf(exitOutputExists, "%s already exists", name)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// checkOutputs checks that the indirect outputs can be created before the
+// operation is done.
+func checkOutputs(names ...string) error {
+	for _, name := range names {
+		if name == "" || strings.HasPrefix(name, "@") {
+			continue
+		}
+		if _, err := os.Stat(name); err == nil {
+			return sopErrorf(exitOutputExists, "%s already exists", name)
+		}
+	}
+	return nil
+}
+
+// readPassword reads a password, without its trailing whitespace.
+func readPassword(name string) ([]byte, error) {
+	password, err := readInput(name)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(string(password), " \t\r\n")), nil
+}
+
+func (c *command) version(args *arguments) error {
+	if len(args.positional) > 0 {
+		return sopErrorf(exitUnsupportedOption, "unexpected argument %s", args.positional[0])
+	}
+	if len(args.flags) > 1 {
+		return sopErrorf(exitIncompatibleOptions, "--backend, --extended and --sop-spec are mutually exclusive")
+	}
+
+	version := "(devel)"
+	backend := "github.com/ProtonMail/go-crypto"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		version = info.Main.Version
+		for _, dep := range info.Deps {
+			if dep.Path == backend {
+				backend += " " + dep.Version
+			}
+		}
+	}
+
+	switch {
+	case args.flags["backend"]:
+		fmt.Fprintln(c.stdout, backend)
+	case args.flags["extended"]:
+		fmt.Fprintf(c.stdout, "vault-sop %s\n%s\nsecret keys held by the Vault GPG secret backend\n", version, backend)
+	case args.flags["sop-spec"]:
+		fmt.Fprintln(c.stdout, sopSpec)
+	default:
+		fmt.Fprintf(c.stdout, "vault-sop %s\n", version)
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// micalgNames are the names of the hash algorithms in the micalg parameter of
+// PGP/MIME messages.
+var micalgNames = map[string]string{
+	"sha2-224": "pgp-sha224",
+	"sha2-256": "pgp-sha256",
+	"sha2-384": "pgp-sha384",
+	"sha2-512": "pgp-sha512",
+	"sha3-256": "pgp-sha3-256",
+	"sha3-512": "pgp-sha3-512",
+}
+
+// vaultSignature is a detached signature made by Vault.
+type vaultSignature struct {
+	data          []byte
+	signature     *packet.Signature
+	hashAlgorithm string
+}
+
+// signWithVault asks Vault for a detached signature of the input made by the
+// named key.
+func (c *command) signWithVault(name string, input []byte, data map[string]interface{}) (*vaultSignature, error) {
+	logical, err := c.vault()
+	if err != nil {
+		return nil, err
+	}
+	data["input"] = base64.StdEncoding.EncodeToString(input)
+	secret, err := logical.Write(c.mount+"/sign/"+name, data)
+	if err != nil {
+		return nil, sopErrorf(exitKeyCannotSign, "unable to sign with the key %s: %s", name, err)
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("unable to sign with the key %s: empty response", name)
+	}
+
+	encoded, _ := secret.Data["signature"].(string)
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode the signature of the key %s: %w", name, err)
+	}
+	p, err := packet.Read(bytes.NewReader(signature))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the signature of the key %s: %w", name, err)
+	}
+	sig, ok := p.(*packet.Signature)
+	if !ok {
+		return nil, fmt.Errorf("unable to read the signature of the key %s: not a signature", name)
+	}
+	hashAlgorithm, _ := secret.Data["hash_algorithm"].(string)
+	return &vaultSignature{data: signature, signature: sig, hashAlgorithm: hashAlgorithm}, nil
+}
+
+// signatureMode returns the mode of the signatures given by --as, and checks
+// that the input is text in the text mode.
+func signatureMode(args *arguments, input []byte, modes ...string) (string, error) {
+	mode := args.value("as")
+	if mode == "" {
+		mode = "binary"
+	}
+	if !slices.Contains(modes, mode) {
+		return "", sopErrorf(exitUnsupportedOption, "unsupported value %s for --as; must be one of %s", mode, strings.Join(modes, ", "))
+	}
+	if mode != "binary" && !utf8.Valid(input) {
+		return "", sopErrorf(exitExpectedText, "the input is not UTF-8 text")
+	}
+	return mode, nil
+}
+
+// sign writes the detached signatures of the standard input made by each key.
+func (c *command) sign(args *arguments) error {
+	names, err := keyNames(args.positional)
+	if err != nil {
+		return err
+	}
+	micalgOut := args.value("micalg-out")
+	if err := checkOutputs(micalgOut); err != nil {
+		return err
+	}
+	input, err := io.ReadAll(c.stdin)
+	if err != nil {
+		return err
+	}
+	mode, err := signatureMode(args, input, "binary", "text")
+	if err != nil {
+		return err
+	}
+
+	var signatures []byte
+	micalg := ""
+	for i, name := range names {
+		signature, err := c.signWithVault(name, input, map[string]interface{}{"mode": mode})
+		if err != nil {
+			return err
+		}
+		signatures = append(signatures, signature.data...)
+		// The micalg is empty when the signatures use several hash algorithms
+		if i == 0 {
+			micalg = micalgNames[signature.hashAlgorithm]
+		} else if micalg != micalgNames[signature.hashAlgorithm] {
+			micalg = ""
+		}
+	}
+
+	if err := c.writeArmored(args, openpgp.SignatureType, signatures); err != nil {
+		return err
+	}
+	if micalgOut != "" {
+		return writeOutput(micalgOut, []byte(micalg))
+	}
+	return nil
+}
+
+// inlineSign writes the standard input signed by each key, as a signed message
+// or a cleartext signed document.
+func (c *command) inlineSign(args *arguments) error {
+	names, err := keyNames(args.positional)
+	if err != nil {
+		return err
+	}
+	input, err := io.ReadAll(c.stdin)
+	if err != nil {
+		return err
+	}
+	mode, err := signatureMode(args, input, "binary", "text", "clearsigned")
+	if err != nil {
+		return err
+	}
+
+	if mode == "clearsigned" {
+		if args.flags["no-armor"] {
+			return sopErrorf(exitIncompatibleOptions, "cleartext signed documents are always armored")
+		}
+		document, err := c.clearsign(names, input)
+		if err != nil {
+			return err
+		}
+		_, err = c.stdout.Write(document)
+		return err
+	}
+
+	signatures := make([]*vaultSignature, 0, len(names))
+	for _, name := range names {
+		signature, err := c.signWithVault(name, input, map[string]interface{}{"mode": mode})
+		if err != nil {
+			return err
+		}
+		signatures = append(signatures, signature)
+	}
+	message, err := signedMessage(input, mode == "binary", signatures)
+	if err != nil {
+		return err
+	}
+	return c.writeArmored(args, "PGP MESSAGE", message)
+}
+
+// signedMessage returns a signed message made of the detached signatures of
+// the message: one-pass signature packets, the literal data and the
+// signatures in the reverse order.
+func signedMessage(message []byte, isBinary bool, signatures []*vaultSignature) ([]byte, error) {
+	var signed bytes.Buffer
+	for i, signature := range signatures {
+		ops, err := onePassSignature(signature.signature, i == len(signatures)-1)
+		if err != nil {
+			return nil, err
+		}
+		if err := ops.Serialize(&signed); err != nil {
+			return nil, err
+		}
+	}
+
+	w, err := packet.SerializeLiteral(nopWriteCloser{&signed}, isBinary, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(message); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+
+	for i := len(signatures) - 1; i >= 0; i-- {
+		signed.Write(signatures[i].data)
+	}
+	return signed.Bytes(), nil
+}
+
+// onePassSignature returns the one-pass signature packet announcing the
+// signature. v6 signatures are announced by v6 packets carrying their salt and
+// the fingerprint of the issuer, the other signatures by v3 packets.
+func onePassSignature(sig *packet.Signature, isLast bool) (*packet.OnePassSignature, error) {
+	ops := &packet.OnePassSignature{
+		Version:    3,
+		SigType:    sig.SigType,
+		Hash:       sig.Hash,
+		PubKeyAlgo: sig.PubKeyAlgo,
+		IsLast:     isLast,
+	}
+	if sig.Version == 6 {
+		if len(sig.IssuerFingerprint) == 0 {
+			return nil, fmt.Errorf("the signature has no issuer fingerprint")
+		}
+		ops.Version = 6
+		ops.Salt = sig.Salt()
+		ops.KeyFingerprint = sig.IssuerFingerprint
+		ops.KeyId = binary.BigEndian.Uint64(sig.IssuerFingerprint[:8])
+		return ops, nil
+	}
+	if sig.IssuerKeyId == nil {
+		return nil, fmt.Errorf("the signature has no issuer")
+	}
+	ops.KeyId = *sig.IssuerKeyId
+	return ops, nil
+}
+
+// clearsign returns the cleartext signed document of the message signed by
+// each key. The document is made by the first key, the signatures of the
+// other keys are text signatures of the signed text added to it.
+func (c *command) clearsign(names []string, message []byte) ([]byte, error) {
+	logical, err := c.vault()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := logical.Write(c.mount+"/sign/"+names[0], map[string]interface{}{
+		"input":          base64.StdEncoding.EncodeToString(message),
+		"signature_type": "cleartext",
+		"format":         "ascii-armor",
+	})
+	if err != nil {
+		return nil, sopErrorf(exitKeyCannotSign, "unable to sign with the key %s: %s", names[0], err)
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("unable to sign with the key %s: empty response", names[0])
+	}
+	document, _ := secret.Data["signature"].(string)
+	if len(names) == 1 {
+		return []byte(document), nil
+	}
+
+	block, _ := clearsign.Decode([]byte(document))
+	if block == nil {
+		return nil, fmt.Errorf("unable to decode the cleartext signed document")
+	}
+	signatures, err := io.ReadAll(block.ArmoredSignature.Body)
+	if err != nil {
+		return nil, err
+	}
+	// The Hash header of the document is right as long as all the
+	// signatures use the same algorithm
+	hashAlgorithm, _ := secret.Data["hash_algorithm"].(string)
+	for _, name := range names[1:] {
+		signature, err := c.signWithVault(name, block.Bytes, map[string]interface{}{
+			"mode":      "text",
+			"algorithm": hashAlgorithm,
+		})
+		if err != nil {
+			return nil, err
+		}
+		signatures = append(signatures, signature.data...)
+	}
+
+	var multi bytes.Buffer
+	multi.WriteString(document[:strings.Index(document, "-----BEGIN PGP SIGNATURE-----")])
+	if err := writeArmor(&multi, openpgp.SignatureType, signatures); err != nil {
+		return nil, err
+	}
+	return multi.Bytes(), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
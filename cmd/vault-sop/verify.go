@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// dateLayouts are the accepted formats of the ISO-8601 dates of --not-before
+// and --not-after.
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"20060102T150405Z",
+	"2006-01-02",
+}
+
+// period is the period in which the signatures must have been made. A zero
+// bound is not checked.
+type period struct {
+	notBefore time.Time
+	notAfter  time.Time
+}
+
+func (p period) contains(t time.Time) bool {
+	return (p.notBefore.IsZero() || !t.Before(p.notBefore)) && (p.notAfter.IsZero() || !t.After(p.notAfter))
+}
+
+// parsePeriod parses the bounds of the period. By default the signatures can
+// be made at any time up to now, "-" removes a bound.
+func parsePeriod(notBefore, notAfter string) (period, error) {
+	var p period
+	var err error
+	if notBefore != "" && notBefore != "-" {
+		if p.notBefore, err = parseDate(notBefore); err != nil {
+			return p, err
+		}
+	}
+	switch notAfter {
+	case "":
+		p.notAfter = time.Now()
+	case "-":
+	default:
+		if p.notAfter, err = parseDate(notAfter); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+func parseDate(value string) (time.Time, error) {
+	if value == "now" {
+		return time.Now(), nil
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, sopErrorf(exitUnsupportedOption, "invalid date %s", value)
+}
+
+// verification returns the line describing a valid signature: its creation
+// time, the fingerprints of the signing key and of the primary key, and its
+// mode.
+func verification(sig *packet.Signature, signer *openpgp.Entity) string {
+	signingKey := signer.PrimaryKey
+	if sig.IssuerKeyId != nil {
+		for _, subkey := range signer.Subkeys {
+			if subkey.PublicKey.KeyId == *sig.IssuerKeyId {
+				signingKey = subkey.PublicKey
+			}
+		}
+	}
+	mode := "binary"
+	if sig.SigType == packet.SigTypeText {
+		mode = "text"
+	}
+	return fmt.Sprintf("%s %X %X mode:%s", sig.CreationTime.UTC().Format("2006-01-02T15:04:05Z"),
+		signingKey.Fingerprint, signer.PrimaryKey.Fingerprint, mode)
+}
+
+// verifyDetached returns the verifications of the valid signatures of the
+// input. Each signature is checked with the keys as they were when it was
+// made.
+func verifyDetached(certs openpgp.EntityList, input, signatures []byte, p period) ([]string, error) {
+	var verifications []string
+	r := bytes.NewReader(signatures)
+	for r.Len() > 0 {
+		start := len(signatures) - r.Len()
+		pkt, err := packet.Read(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, sopErrorf(exitBadData, "unable to read the signatures: %s", err)
+		}
+		sig, ok := pkt.(*packet.Signature)
+		if !ok {
+			return nil, sopErrorf(exitBadData, "unexpected packet in the signatures")
+		}
+		if !p.contains(sig.CreationTime) {
+			continue
+		}
+
+		signature := signatures[start : len(signatures)-r.Len()]
+		config := &packet.Config{
+			Time: func() time.Time {
+				return sig.CreationTime
+			},
+		}
+		verified, signer, err := openpgp.VerifyDetachedSignature(certs, bytes.NewReader(input), bytes.NewReader(signature), config)
+		if err != nil {
+			continue
+		}
+		verifications = append(verifications, verification(verified, signer))
+	}
+	return verifications, nil
+}
+
+// verify writes the verifications of the detached signatures of the standard
+// input made by the certificates.
+func (c *command) verify(args *arguments) error {
+	if len(args.positional) < 2 {
+		return sopErrorf(exitMissingArg, "the signatures and at least one certificate are required")
+	}
+	p, err := parsePeriod(args.value("not-before"), args.value("not-after"))
+	if err != nil {
+		return err
+	}
+	data, err := readInput(args.positional[0])
+	if err != nil {
+		return err
+	}
+	signatures, err := dearmor(data)
+	if err != nil {
+		return err
+	}
+	certs, err := readCerts(args.positional[1:])
+	if err != nil {
+		return err
+	}
+	input, err := io.ReadAll(c.stdin)
+	if err != nil {
+		return err
+	}
+
+	verifications, err := verifyDetached(certs, input, signatures, p)
+	if err != nil {
+		return err
+	}
+	if len(verifications) == 0 {
+		return sopErrorf(exitNoSignature, "no valid signature found")
+	}
+	_, err = io.WriteString(c.stdout, strings.Join(verifications, "\n")+"\n")
+	return err
+}
@@ -2,6 +2,9 @@ package gpg
 
 import (
 	"context"
+	"errors"
+	"strings"
+	"sync"
 
 	"github.com/hashicorp/vault/sdk/helper/locksutil"
 
@@ -27,27 +30,117 @@ func Backend() *backend {
 			pathKeys(&b),
 			pathListKeys(&b),
 			pathExportKeys(&b),
+			pathSignTrailer(&b),
+			pathSignManifest(&b),
+			pathSignAptRelease(&b),
+			pathSignContainerImage(&b),
+			pathSignGit(&b),
+			pathSignSessions(&b),
+			pathSignSession(&b),
+			pathSignSessionFinalize(&b),
 			pathSign(&b),
+			pathSignMultiple(&b),
 			pathVerify(&b),
+			pathVerifyContainerImage(&b),
+			pathVerifyGit(&b),
+			pathVerifyKeyring(&b),
 			pathDecrypt(&b),
 			pathShowSessionKey(&b),
 			pathConfig(&b),
+			pathKeyUsage(&b),
+			pathListJobs(&b),
+			pathJobs(&b),
+			pathConfigPool(&b),
+			pathStorageVersion(&b),
 		},
 		PathsSpecial: &logical.Paths{
 			SealWrapStorage: []string{
 				"key/",
+				"pool/",
+				"session/",
 			},
 		},
-		Secrets:     []*framework.Secret{},
-		BackendType: logical.TypeLogical,
+		Secrets:        []*framework.Secret{},
+		BackendType:    logical.TypeLogical,
+		Invalidate:     b.invalidate,
+		Clean:          b.cleanup,
+		InitializeFunc: b.initialize,
+		PeriodicFunc:   b.periodicFunc,
 	}
 	b.keyLocks = locksutil.CreateLocks()
+	b.entityCache = newEntityCache()
+	b.usage = newUsageTracker()
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	b.jobs = make(map[string]context.CancelFunc)
 	return &b
 }
 
 type backend struct {
 	*framework.Backend
-	keyLocks []*locksutil.LockEntry
+	keyLocks    []*locksutil.LockEntry
+	entityCache *entityCache
+	usage       *usageTracker
+
+	// ctx is the parent of the background work started by the backend, it is
+	// cancelled when the backend is unloaded.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	jobsLock sync.Mutex
+	jobs     map[string]context.CancelFunc
+
+	keyPoolLock sync.Mutex
+
+	// storage is the storage of the mount, used by the background work that
+	// outlives the request starting it. It is set by Setup.
+	storage logical.Storage
+}
+
+// Setup keeps the storage of the mount for the background work.
+func (b *backend) Setup(ctx context.Context, conf *logical.BackendConfig) error {
+	b.storage = conf.StorageView
+	return b.Backend.Setup(ctx, conf)
+}
+
+// initialize is called by Vault when the mount is initialized.
+func (b *backend) initialize(ctx context.Context, req *logical.InitializationRequest) error {
+	if err := b.upgradeStorage(ctx, req); err != nil {
+		return err
+	}
+	return b.failInterruptedJobs(ctx, req.Storage)
+}
+
+// periodicFunc is called by Vault every minute or so to do the housekeeping
+// of the mount.
+func (b *backend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	// The tasks are independent, the failure of one must not prevent the
+	// others from running
+	return errors.Join(
+		b.flushKeyUsage(ctx, req.Storage),
+		b.pruneSigningSessions(ctx, req.Storage),
+		b.pruneJobs(ctx, req.Storage),
+		b.refillKeyPool(ctx, req.Storage),
+	)
+}
+
+// cleanup is called by Vault when the backend is unloaded. The usage not
+// flushed yet would be lost otherwise.
+func (b *backend) cleanup(ctx context.Context) {
+	b.cancel()
+	if b.storage == nil {
+		return
+	}
+	if err := b.flushKeyUsage(ctx, b.storage); err != nil {
+		b.Logger().Error("failed to flush the usage of the keys", "error", err)
+	}
+}
+
+// invalidate is called by Vault when a storage entry has been modified by
+// another node of the cluster, e.g. on a performance standby.
+func (b *backend) invalidate(_ context.Context, key string) {
+	if strings.HasPrefix(key, "key/") {
+		b.entityCache.invalidate(strings.TrimPrefix(key, "key/"))
+	}
 }
 
 const backendHelp = `
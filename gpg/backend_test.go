@@ -1,6 +1,7 @@
 package gpg
 
 import (
+	"bytes"
 	"context"
 	"encoding/hex"
 	"reflect"
@@ -8,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
@@ -196,6 +198,23 @@ func testAccStepListKey(t *testing.T, b logical.Backend, storage logical.Storage
 	}
 }
 
+// testArmoredPrivateKey returns the armored private key of the entity, to be
+// imported in the backend.
+func testArmoredPrivateKey(t *testing.T, entity *openpgp.Entity) string {
+	var key bytes.Buffer
+	w, err := armor.Encode(&key, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = entity.SerializePrivateWithoutSigning(w, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return key.String()
+}
+
 func getTestBackend(t *testing.T) (logical.Backend, logical.Storage) {
 	config := logical.TestBackendConfig()
 	config.StorageView = &logical.InmemStorage{}
@@ -0,0 +1,131 @@
+package gpg
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"runtime"
+	"sync"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// batchInputField is the field of the endpoints processing several items in
+// one request.
+var batchInputField = &framework.FieldSchema{
+	Type: framework.TypeSlice,
+	Description: `Items to process in a single request. Each item is an object with the
+fields of the request, the fields missing from an item are taken from the
+request. The results are returned in batch_results, in the same order.`,
+}
+
+// handleBatch calls handle with the fields of the request or, when the
+// request has a batch input, with the fields of each item of the batch. The
+// items are processed concurrently by a bounded number of workers. handle must
+// be safe for concurrent use.
+func (b *backend) handleBatch(ctx context.Context, req *logical.Request, data *framework.FieldData, handle func(*framework.FieldData) (*logical.Response, error)) (*logical.Response, error) {
+	if _, ok := data.Raw["batch_input"]; !ok {
+		return handle(data)
+	}
+	// The policies are evaluated on the path of the request, the items must
+	// not change the fields it captures
+	var pathFields []string
+	if path := b.Route(req.Path); path != nil {
+		pathFields = regexp.MustCompile(path.Pattern).SubexpNames()
+	}
+
+	items := data.Get("batch_input").([]interface{})
+	if len(items) == 0 {
+		return logical.ErrorResponse("the batch input must not be empty"), logical.ErrInvalidRequest
+	}
+
+	results := make([]map[string]interface{}, len(items))
+	indexes := make(chan int)
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(items) {
+		workers = len(items)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if ctx.Err() != nil {
+					results[i] = map[string]interface{}{"error": ctx.Err().Error()}
+					continue
+				}
+				item, err := batchItem(data, pathFields, items[i])
+				if err != nil {
+					results[i] = map[string]interface{}{"error": err.Error()}
+					continue
+				}
+				results[i] = batchResult(handle(item))
+			}
+		}()
+	}
+	for i := range items {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"batch_results": results,
+		},
+	}, nil
+}
+
+// batchItem returns the fields of an item of the batch input. The fields
+// missing from the item are taken from the request, the fields captured from
+// the path of the request cannot be overridden.
+func batchItem(data *framework.FieldData, pathFields []string, input interface{}) (*framework.FieldData, error) {
+	fields, ok := input.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("the item is not an object")
+	}
+	raw := make(map[string]interface{}, len(data.Raw)+len(fields))
+	for name, value := range data.Raw {
+		raw[name] = value
+	}
+	for name, value := range fields {
+		raw[name] = value
+	}
+	delete(raw, "batch_input")
+	for _, name := range pathFields {
+		if name == "" {
+			continue
+		}
+		if value, ok := data.Raw[name]; ok {
+			raw[name] = value
+		} else {
+			delete(raw, name)
+		}
+	}
+
+	item := &framework.FieldData{
+		Raw:    raw,
+		Schema: data.Schema,
+	}
+	if err := item.Validate(); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// batchResult returns the result of an item of a batch, the error of the
+// item is returned in the error field.
+func batchResult(resp *logical.Response, err error) map[string]interface{} {
+	switch {
+	case resp != nil && resp.IsError():
+		return map[string]interface{}{"error": resp.Error().Error()}
+	case err != nil:
+		return map[string]interface{}{"error": err.Error()}
+	case resp == nil:
+		return map[string]interface{}{}
+	default:
+		return resp.Data
+	}
+}
@@ -0,0 +1,169 @@
+package gpg
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestGPG_Batch(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"generate": false,
+		"key":      gpgKey,
+	}, false)
+	testAccStepCreateKey(t, b, storage, "decrypt", map[string]interface{}{
+		"generate": false,
+		"key":      privateDecryptKey,
+	}, false)
+
+	request := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// Sign, the fields missing from the items are taken from the request
+	var batchInput []interface{}
+	for i := 0; i < 20; i++ {
+		batchInput = append(batchInput, map[string]interface{}{
+			"input": base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("package %d", i))),
+		})
+	}
+	batchInput = append(batchInput,
+		map[string]interface{}{"input": "not base64"},
+		map[string]interface{}{"input": batchInput[0].(map[string]interface{})["input"], "algorithm": "sha2-384"},
+		map[string]interface{}{"input": batchInput[0].(map[string]interface{})["input"], "prehashed": "not a bool"},
+		"not an object",
+	)
+	resp := request("sign/test", map[string]interface{}{
+		"algorithm":   "sha2-512",
+		"batch_input": batchInput,
+	})
+	if resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+	results := resp.Data["batch_results"].([]map[string]interface{})
+	if len(results) != len(batchInput) {
+		t.Fatalf("expected one result per item: %#v", resp.Data)
+	}
+	for i := 0; i < 20; i++ {
+		if results[i]["error"] != nil || results[i]["hash_algorithm"] != "sha2-512" {
+			t.Fatalf("unexpected result of item %d: %#v", i, results[i])
+		}
+	}
+	if results[21]["error"] != nil || results[21]["hash_algorithm"] != "sha2-384" {
+		t.Fatalf("expected the algorithm of the item to be used: %#v", results[21])
+	}
+	for _, i := range []int{20, 22, 23} {
+		if results[i]["error"] == nil {
+			t.Fatalf("expected an error for item %d: %#v", i, results[i])
+		}
+	}
+	if b.usage.get("test").Counts[usageSign] != 21 {
+		t.Fatalf("expected the signatures to be recorded: %#v", b.usage.get("test"))
+	}
+
+	// The items cannot change the fields captured from the path the policies
+	// are evaluated on
+	item := map[string]interface{}{
+		"input":        batchInput[0].(map[string]interface{})["input"],
+		"name":         "decrypt",
+		"urlalgorithm": "sha2-384",
+	}
+	for path, algorithm := range map[string]string{"sign/test": "sha2-512", "sign/test/sha2-256": "sha2-256"} {
+		resp = request(path, map[string]interface{}{
+			"algorithm":   "sha2-512",
+			"batch_input": []interface{}{item},
+		})
+		if resp.IsError() {
+			t.Fatal(resp.Error())
+		}
+		result := resp.Data["batch_results"].([]map[string]interface{})[0]
+		if result["error"] != nil || result["hash_algorithm"] != algorithm {
+			t.Fatalf("expected the item to be signed with %s by %s: %#v", algorithm, path, result)
+		}
+	}
+	if b.usage.get("decrypt").Counts[usageSign] != 0 {
+		t.Fatalf("expected the items to be signed by the key of the path: %#v", b.usage.get("decrypt"))
+	}
+
+	// Verify
+	var verifyInput []interface{}
+	for i := 0; i < 20; i++ {
+		verifyInput = append(verifyInput, map[string]interface{}{
+			"input":     batchInput[i].(map[string]interface{})["input"],
+			"signature": results[i]["signature"],
+		})
+	}
+	verifyInput = append(verifyInput, map[string]interface{}{
+		"input":     batchInput[1].(map[string]interface{})["input"],
+		"signature": results[0]["signature"],
+	}, map[string]interface{}{
+		"signature":      results[0]["signature"],
+		"signature_type": "unknown",
+	})
+	resp = request("verify/test", map[string]interface{}{
+		"batch_input": verifyInput,
+	})
+	results = resp.Data["batch_results"].([]map[string]interface{})
+	for i := 0; i < 20; i++ {
+		if results[i]["valid"] != true {
+			t.Fatalf("expected a valid signature for item %d: %#v", i, results[i])
+		}
+	}
+	if results[20]["valid"] != false || results[20]["reason"] != reasonBadSignature {
+		t.Fatalf("expected a bad signature: %#v", results[20])
+	}
+	if results[21]["error"] == nil {
+		t.Fatalf("expected an error: %#v", results[21])
+	}
+
+	// Decrypt
+	resp = request("decrypt/decrypt", map[string]interface{}{
+		"format": "ascii-armor",
+		"batch_input": []interface{}{
+			map[string]interface{}{"ciphertext": encryptedMessageASCIIArmored},
+			map[string]interface{}{"ciphertext": encryptedMessageBase64Encoded, "format": "base64"},
+			map[string]interface{}{"ciphertext": encryptedAndSignedMessageASCIIArmored, "signer_key": publicSignerKey},
+			map[string]interface{}{"ciphertext": "not a message"},
+		},
+	})
+	results = resp.Data["batch_results"].([]map[string]interface{})
+	for i := 0; i < 3; i++ {
+		if results[i]["plaintext"] != "QWxwYWNhcwo=" {
+			t.Fatalf("unexpected plaintext of item %d: %#v", i, results[i])
+		}
+	}
+	if results[3]["error"] == nil {
+		t.Fatalf("expected an error: %#v", results[3])
+	}
+
+	// The key is checked once for the whole batch
+	for _, path := range []string{"sign/missing", "verify/missing", "decrypt/missing"} {
+		resp = request(path, map[string]interface{}{
+			"batch_input": batchInput[:1],
+		})
+		if !resp.IsError() {
+			t.Fatalf("expected an error for %s: %#v", path, resp)
+		}
+	}
+	resp = request("sign/test", map[string]interface{}{
+		"batch_input": []interface{}{},
+	})
+	if !resp.IsError() {
+		t.Fatalf("expected an error for an empty batch: %#v", resp)
+	}
+}
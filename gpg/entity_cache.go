@@ -0,0 +1,61 @@
+package gpg
+
+import (
+	"crypto/sha256"
+	"sync"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// entityCache keeps the parsed form of the stored keys so the hot paths do not
+// have to read the key ring and validate its self-signatures on every request.
+// Entries are keyed by the name of the key and by the digest of the serialized
+// key, which acts as the version of the storage entry: an entry that has been
+// replaced behind our back is never served.
+type entityCache struct {
+	lock    sync.RWMutex
+	entries map[string]*cachedEntity
+}
+
+type cachedEntity struct {
+	version [sha256.Size]byte
+	entity  *openpgp.Entity
+}
+
+func newEntityCache() *entityCache {
+	return &entityCache{
+		entries: make(map[string]*cachedEntity),
+	}
+}
+
+func (c *entityCache) get(name string, serializedKey []byte) *openpgp.Entity {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	cached, ok := c.entries[name]
+	if !ok {
+		return nil
+	}
+	version := sha256.Sum256(serializedKey)
+	if cached.version != version {
+		return nil
+	}
+	return cached.entity
+}
+
+func (c *entityCache) put(name string, serializedKey []byte, entity *openpgp.Entity) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries[name] = &cachedEntity{
+		version: sha256.Sum256(serializedKey),
+		entity:  entity,
+	}
+}
+
+func (c *entityCache) invalidate(name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.entries, name)
+}
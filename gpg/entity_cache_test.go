@@ -0,0 +1,143 @@
+package gpg
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestGPG_EntityCache(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	readFingerprint := func(name string) string {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "keys/" + name,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || resp.IsError() {
+			t.Fatalf("not expected response: %#v", resp)
+		}
+		return resp.Data["fingerprint"].(string)
+	}
+
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/test",
+		Data: map[string]interface{}{
+			"generate": false,
+			"key":      gpgKey,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	importedFingerprint := readFingerprint("test")
+	entry, err := b.key(context.Background(), storage, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.entityCache.get("test", entry.SerializedKey) == nil {
+		t.Fatal("expected the parsed key to be cached")
+	}
+
+	// Invalidation requested by Vault, e.g. on a performance standby
+	b.Invalidate(context.Background(), "key/test")
+	if b.entityCache.get("test", entry.SerializedKey) != nil {
+		t.Fatal("expected the parsed key to be evicted")
+	}
+
+	// Storage entry replaced without going through the backend
+	readFingerprint("test")
+	generated := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/other",
+		Data: map[string]interface{}{
+			"real_name": "Vault GPG test",
+		},
+	}
+	if _, err = b.HandleRequest(context.Background(), generated); err != nil {
+		t.Fatal(err)
+	}
+	otherEntry, err := storage.Get(context.Background(), "key/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherEntry.Key = "key/test"
+	if err = storage.Put(context.Background(), otherEntry); err != nil {
+		t.Fatal(err)
+	}
+	if readFingerprint("test") == importedFingerprint {
+		t.Fatal("expected the replaced storage entry to be read")
+	}
+	if readFingerprint("test") != readFingerprint("other") {
+		t.Fatal("expected the fingerprint of the replacement key")
+	}
+
+	// Deletion
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Storage:   storage,
+		Operation: logical.DeleteOperation,
+		Path:      "keys/test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.entityCache.entries) != 1 {
+		t.Fatalf("expected only one cached key, got %d", len(b.entityCache.entries))
+	}
+}
+
+func BenchmarkGPG_SignCached(b *testing.B) {
+	benchmarkSign(b, false)
+}
+
+func BenchmarkGPG_SignUncached(b *testing.B) {
+	benchmarkSign(b, true)
+}
+
+func benchmarkSign(bench *testing.B, invalidate bool) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/test",
+		Data: map[string]interface{}{
+			"generate": false,
+			"key":      gpgKey,
+		},
+	})
+	if err != nil {
+		bench.Fatal(err)
+	}
+
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "sign/test",
+		Data: map[string]interface{}{
+			"input": "dGhlIHF1aWNrIGJyb3duIGZveA==",
+		},
+	}
+
+	bench.ResetTimer()
+	for i := 0; i < bench.N; i++ {
+		if invalidate {
+			b.entityCache.invalidate("test")
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp.IsError() {
+			bench.Fatalf("unexpected error: %v %#v", err, resp)
+		}
+	}
+}
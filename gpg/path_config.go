@@ -17,6 +17,14 @@ func pathConfig(b *backend) *framework.Path {
 				Type:        framework.TypeString,
 				Description: "Name of the key",
 			},
+			"allow_prehashed": {
+				Type:        framework.TypeBool,
+				Description: "Allows the signing subkeys of the key to sign digests computed by the clients with the prehashed parameter of the sign endpoint. Defaults to false.",
+			},
+			"allow_prehashed_certify": {
+				Type:        framework.TypeBool,
+				Description: "Also allows the primary key, which certifies the subkeys and the user IDs, to sign digests computed by the clients. Defaults to false.",
+			},
 		},
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.UpdateOperation: &framework.PathOperation{
@@ -43,8 +51,28 @@ func (b *backend) pathConfigWrite(ctx context.Context, req *logical.Request, dat
 		return logical.ErrorResponse(fmt.Sprintf("no existing key named %s could be found", name)), logical.ErrInvalidRequest
 	}
 
-	return nil, nil
+	if allowPrehashed, ok := data.GetOk("allow_prehashed"); ok {
+		entry.AllowPrehashed = allowPrehashed.(bool)
+	}
+	if allowPrehashedCertify, ok := data.GetOk("allow_prehashed_certify"); ok {
+		entry.AllowPrehashedCertify = allowPrehashedCertify.(bool)
+	}
+	if entry.AllowPrehashedCertify && !entry.AllowPrehashed {
+		return logical.ErrorResponse("allow_prehashed_certify requires allow_prehashed"), logical.ErrInvalidRequest
+	}
+
+	return nil, b.storeKeyEntry(ctx, req.Storage, name, entry)
 }
 
 const pathConfigHelpSyn = "Configure a named GPG key"
-const pathConfigHelpDesc = "This path is used to configure the named key."
+const pathConfigHelpDesc = `
+This path is used to configure the named key.
+
+Prehashed inputs are refused unless allow_prehashed is set. A key signing
+prehashed inputs signs any digest given to it without seeing the data: a
+client able to sign with it can get a signature of any data, including data
+whose signature has another meaning than a document, such as a certification
+of a user ID or a binding of a subkey made by the primary key. The primary key
+only signs prehashed inputs when allow_prehashed_certify is also set, prefer
+a dedicated signing subkey.
+`
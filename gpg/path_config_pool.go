@@ -0,0 +1,263 @@
+package gpg
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// keyPoolRefillBatch is the maximum number of key materials generated for each
+// key size every time the pool is refilled so a periodic run stays short.
+const keyPoolRefillBatch = 4
+
+// keyPoolMaxSize is the maximum number of key materials that can be kept for
+// a key size.
+const keyPoolMaxSize = 1000
+
+func pathConfigPool(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/pool",
+		Fields: map[string]*framework.FieldSchema{
+			"sizes": {
+				Type:        framework.TypeKVPairs,
+				Description: "Number of pre-generated RSA key materials to keep for each key size, for example 2048=10,4096=5. Setting a size to 0 empties its pool.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathConfigPoolRead,
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathConfigPoolWrite,
+			},
+		},
+		HelpSynopsis:    pathConfigPoolHelpSyn,
+		HelpDescription: pathConfigPoolHelpDesc,
+	}
+}
+
+func (b *backend) keyPoolConfig(ctx context.Context, s logical.Storage) (*keyPoolConfig, error) {
+	entry, err := s.Get(ctx, "config/pool")
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result keyPoolConfig
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (b *backend) pathConfigPoolRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	config, err := b.keyPoolConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, nil
+	}
+
+	sizes := make(map[string]int, len(config.Sizes))
+	available := make(map[string]int, len(config.Sizes))
+	for bits, size := range config.Sizes {
+		entries, err := req.Storage.List(ctx, keyPoolPrefix(bits))
+		if err != nil {
+			return nil, err
+		}
+		sizes[strconv.Itoa(bits)] = size
+		available[strconv.Itoa(bits)] = len(entries)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"sizes":     sizes,
+			"available": available,
+		},
+	}, nil
+}
+
+func (b *backend) pathConfigPoolWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config := &keyPoolConfig{
+		Sizes: make(map[int]int),
+	}
+	for rawBits, rawSize := range data.Get("sizes").(map[string]string) {
+		bits, err := strconv.Atoi(rawBits)
+		if err != nil || bits < 2048 {
+			return logical.ErrorResponse(fmt.Sprintf("invalid key size %s, keys < 2048 bits are unsafe and not supported", rawBits)), nil
+		}
+		size, err := strconv.Atoi(rawSize)
+		if err != nil || size < 0 || size > keyPoolMaxSize {
+			return logical.ErrorResponse(fmt.Sprintf("invalid pool size %s for %d bits keys, must be between 0 and %d", rawSize, bits, keyPoolMaxSize)), nil
+		}
+		if size > 0 {
+			config.Sizes[bits] = size
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON("config/pool", config)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	// Drop the key materials that are not wanted anymore
+	b.keyPoolLock.Lock()
+	defer b.keyPoolLock.Unlock()
+	pooledSizes, err := req.Storage.List(ctx, "pool/")
+	if err != nil {
+		return nil, err
+	}
+	for _, pooledSize := range pooledSizes {
+		bits, err := strconv.Atoi(pooledSize[:len(pooledSize)-1])
+		if err != nil {
+			continue
+		}
+		entries, err := req.Storage.List(ctx, keyPoolPrefix(bits))
+		if err != nil {
+			return nil, err
+		}
+		for i := config.Sizes[bits]; i < len(entries); i++ {
+			if err := req.Storage.Delete(ctx, keyPoolPrefix(bits)+entries[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func keyPoolPrefix(bits int) string {
+	return "pool/" + strconv.Itoa(bits) + "/"
+}
+
+// generateKeyMaterial generates the primes of the two RSA keys, the primary
+// key and the encryption subkey, that are needed to build a GPG key.
+func generateKeyMaterial(bits int) (*keyMaterial, error) {
+	material := &keyMaterial{}
+	for i := 0; i < 2; i++ {
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, err
+		}
+		for _, prime := range key.Primes {
+			material.Primes = append(material.Primes, prime.Bytes())
+		}
+	}
+	return material, nil
+}
+
+// refillKeyPool tops up the pools configured for each key size. It is called
+// periodically by Vault.
+func (b *backend) refillKeyPool(ctx context.Context, storage logical.Storage) error {
+	config, err := b.keyPoolConfig(ctx, storage)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+
+	sizes := make([]int, 0, len(config.Sizes))
+	for bits := range config.Sizes {
+		sizes = append(sizes, bits)
+	}
+	sort.Ints(sizes)
+
+	for _, bits := range sizes {
+		entries, err := storage.List(ctx, keyPoolPrefix(bits))
+		if err != nil {
+			return err
+		}
+		missing := min(config.Sizes[bits]-len(entries), keyPoolRefillBatch)
+		for i := 0; i < missing; i++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			material, err := generateKeyMaterial(bits)
+			if err != nil {
+				return err
+			}
+			id, err := uuid.GenerateUUID()
+			if err != nil {
+				return err
+			}
+			entry, err := logical.StorageEntryJSON(keyPoolPrefix(bits)+id, material)
+			if err != nil {
+				return err
+			}
+			if err := storage.Put(ctx, entry); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// takeKeyMaterial removes a key material from the pool of the given key size
+// and returns its primes. It returns nil when the pool is empty.
+func (b *backend) takeKeyMaterial(ctx context.Context, storage logical.Storage, bits int) ([]*big.Int, error) {
+	b.keyPoolLock.Lock()
+	defer b.keyPoolLock.Unlock()
+
+	entries, err := storage.List(ctx, keyPoolPrefix(bits))
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range entries {
+		entry, err := storage.Get(ctx, keyPoolPrefix(bits)+id)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			continue
+		}
+		// A key material must never be used twice
+		if err := storage.Delete(ctx, keyPoolPrefix(bits)+id); err != nil {
+			return nil, err
+		}
+
+		var material keyMaterial
+		if err := entry.DecodeJSON(&material); err != nil {
+			return nil, err
+		}
+		primes := make([]*big.Int, 0, len(material.Primes))
+		for _, prime := range material.Primes {
+			primes = append(primes, new(big.Int).SetBytes(prime))
+		}
+		return primes, nil
+	}
+
+	return nil, nil
+}
+
+type keyPoolConfig struct {
+	Sizes map[int]int
+}
+
+type keyMaterial struct {
+	Primes [][]byte
+}
+
+const pathConfigPoolHelpSyn = "Configure the pool of pre-generated RSA key materials"
+const pathConfigPoolHelpDesc = `
+This path is used to configure the number of RSA key materials generated in
+the background for each key size. When a key of a configured size is created,
+its material is taken from the pool if one is available instead of being
+generated during the request.
+`
@@ -0,0 +1,114 @@
+package gpg
+
+import (
+	"context"
+	"crypto/rsa"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestGPG_KeyPool(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	writeConfig := func(sizes map[string]interface{}, expectFail bool) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "config/pool",
+			Data: map[string]interface{}{
+				"sizes": sizes,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expectFail != resp.IsError() {
+			t.Fatalf("unexpected response: %#v", resp)
+		}
+	}
+	available := func() map[string]int {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "config/pool",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Data["available"].(map[string]int)
+	}
+
+	writeConfig(map[string]interface{}{"1024": 1}, true)
+	writeConfig(map[string]interface{}{"2048": -1}, true)
+	writeConfig(map[string]interface{}{"2048": "many"}, true)
+	writeConfig(map[string]interface{}{"2048": 1}, false)
+
+	if err := b.PeriodicFunc(context.Background(), &logical.Request{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	if available()["2048"] != 1 {
+		t.Fatalf("expected one key material in the pool: %#v", available())
+	}
+
+	entries, err := storage.List(context.Background(), keyPoolPrefix(2048))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := storage.Get(context.Background(), keyPoolPrefix(2048)+entries[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var material keyMaterial
+	if err := entry.DecodeJSON(&material); err != nil {
+		t.Fatal(err)
+	}
+	modulus := new(big.Int).Mul(new(big.Int).SetBytes(material.Primes[0]), new(big.Int).SetBytes(material.Primes[1]))
+
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"real_name": "Vault GPG test",
+		"email":     "vault@example.com",
+	}, false)
+	if available()["2048"] != 0 {
+		t.Fatalf("expected the key material to be taken from the pool: %#v", available())
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Storage:   storage,
+		Operation: logical.ReadOperation,
+		Path:      "keys/test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	el, err := openpgp.ReadArmoredKeyRing(strings.NewReader(resp.Data["public_key"].(string)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if el[0].PrimaryKey.PublicKey.(*rsa.PublicKey).N.Cmp(modulus) != 0 {
+		t.Fatal("expected the primary key to be built from the pooled key material")
+	}
+	if _, ok := el[0].Identities["Vault GPG test <vault@example.com>"]; !ok {
+		t.Fatalf("expected the requested identity to be bound to the key: %#v", el[0].Identities)
+	}
+
+	// The pool is drained when its size is set to 0
+	if err := b.PeriodicFunc(context.Background(), &logical.Request{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	writeConfig(map[string]interface{}{"2048": 0}, false)
+	if available()["2048"] != 0 {
+		t.Fatalf("expected the pool to be empty: %#v", available())
+	}
+	entries, err = storage.List(context.Background(), keyPoolPrefix(2048))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected the pool to be empty, got %d entries", len(entries))
+	}
+}
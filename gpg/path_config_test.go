@@ -46,3 +46,50 @@ func TestGPG_AttemptToSetConfigOfAnUnknownKey(t *testing.T) {
 		t.Fatal("expected an response error because the key does not exist")
 	}
 }
+
+func TestGPG_KeyConfigAllowPrehashed(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"generate": false,
+		"key":      gpgKey,
+	}, false)
+
+	request := func(operation logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: operation,
+			Path:      path,
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	allowed := func() (interface{}, interface{}) {
+		resp := request(logical.ReadOperation, "keys/test", nil)
+		return resp.Data["allow_prehashed"], resp.Data["allow_prehashed_certify"]
+	}
+
+	if prehashed, certify := allowed(); prehashed != false || certify != false {
+		t.Fatalf("expected prehashed inputs to be refused by default: %v %v", prehashed, certify)
+	}
+	if resp := request(logical.UpdateOperation, "keys/test/config", map[string]interface{}{"allow_prehashed_certify": true}); !resp.IsError() {
+		t.Fatalf("expected allow_prehashed_certify to require allow_prehashed: %#v", resp)
+	}
+	request(logical.UpdateOperation, "keys/test/config", map[string]interface{}{"allow_prehashed": true})
+	request(logical.UpdateOperation, "keys/test/config", map[string]interface{}{"allow_prehashed_certify": true})
+	if prehashed, certify := allowed(); prehashed != true || certify != true {
+		t.Fatalf("expected prehashed inputs to be allowed: %v %v", prehashed, certify)
+	}
+	// The parameters not given are kept
+	request(logical.UpdateOperation, "keys/test/config", map[string]interface{}{})
+	if prehashed, certify := allowed(); prehashed != true || certify != true {
+		t.Fatalf("expected the configuration to be kept: %v %v", prehashed, certify)
+	}
+	if resp := request(logical.UpdateOperation, "keys/test/config", map[string]interface{}{"allow_prehashed": false}); !resp.IsError() {
+		t.Fatalf("expected allow_prehashed_certify to require allow_prehashed: %#v", resp)
+	}
+}
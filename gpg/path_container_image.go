@@ -0,0 +1,373 @@
+package gpg
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// containerSignatureType is the type of the container signatures verified by
+// the signedBy policy requirement of podman, skopeo and CRI-O.
+const containerSignatureType = "atomic container signature"
+
+// Reasons returned when a container signature is valid but does not match the
+// expected image.
+const (
+	reasonInvalidPayload   = "invalid_payload"
+	reasonIdentityMismatch = "identity_mismatch"
+	reasonDigestMismatch   = "digest_mismatch"
+)
+
+// imageDigestSizes are the sizes of the supported image manifest digests, by
+// algorithm.
+var imageDigestSizes = map[string]int{
+	"sha256": 32,
+	"sha512": 64,
+}
+
+func pathSignContainerImage(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "sign/" + framework.GenericNameRegex("name") + "/container-image",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "The key to use",
+			},
+			"docker_reference": {
+				Type:        framework.TypeString,
+				Description: "Reference of the image, e.g. registry.example.com/app:1.0",
+			},
+			"manifest_digest": {
+				Type:        framework.TypeString,
+				Description: "Digest of the image manifest, e.g. sha256:<hex>",
+			},
+			"creator": {
+				Type:        framework.TypeString,
+				Description: "Name of the tool that created the signature, stored in the optional part of the payload.",
+			},
+			"algorithm": {
+				Type:        framework.TypeString,
+				Default:     "sha2-256",
+				Description: `Hash algorithm of the signature. Defaults to "sha2-256".`,
+			},
+			"format": {
+				Type:        framework.TypeString,
+				Default:     "base64",
+				Description: `Encoding format to use. Can be "base64" or "ascii-armor". Defaults to "base64".`,
+			},
+			"creation_time": {
+				Type:        framework.TypeTime,
+				Description: "Creation time of the signature. Defaults to the current time.",
+			},
+			"signing_key_id": {
+				Type:        framework.TypeString,
+				Description: "Key ID of the subkey to sign with. Defaults to the newest valid signing subkey.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathSignContainerImageWrite,
+			},
+		},
+		HelpSynopsis:    pathSignContainerImageHelpSyn,
+		HelpDescription: pathSignContainerImageHelpDesc,
+	}
+}
+
+func pathVerifyContainerImage(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "verify/" + framework.GenericNameRegex("name") + "/container-image",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "The key to use",
+			},
+			"signature": {
+				Type:        framework.TypeString,
+				Description: "The container signature",
+			},
+			"format": {
+				Type:        framework.TypeString,
+				Default:     "base64",
+				Description: `Encoding format the signature use. Can be "base64" or "ascii-armor". Defaults to "base64".`,
+			},
+			"docker_reference": {
+				Type:        framework.TypeString,
+				Description: "Expected reference of the image",
+			},
+			"manifest_digest": {
+				Type:        framework.TypeString,
+				Description: "Expected digest of the image manifest",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathVerifyContainerImageWrite,
+			},
+		},
+		HelpSynopsis:    pathVerifyContainerImageHelpSyn,
+		HelpDescription: pathVerifyContainerImageHelpDesc,
+	}
+}
+
+func (b *backend) pathSignContainerImageWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	dockerReference := data.Get("docker_reference").(string)
+	if err := validateDockerReference(dockerReference); err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	manifestDigest := data.Get("manifest_digest").(string)
+	if err := validateManifestDigest(manifestDigest); err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	algorithm := data.Get("algorithm").(string)
+	hashFunc, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return logical.ErrorResponse(fmt.Sprintf("unsupported algorithm %s", algorithm)), nil
+	}
+	format := data.Get("format").(string)
+	switch format {
+	case "base64":
+	case "ascii-armor":
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("unsupported encoding format %s; must be \"base64\" or \"ascii-armor\"", format)), nil
+	}
+
+	creationTime := time.Now()
+	if t, ok := data.GetOk("creation_time"); ok {
+		creationTime = t.(time.Time)
+	}
+	config := packet.Config{
+		DefaultHash: hashFunc,
+		Time: func() time.Time {
+			return creationTime
+		},
+	}
+	var err error
+	config.SigningKeyId, err = parseSigningKeyID(data.Get("signing_key_id").(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	name := data.Get("name").(string)
+	entry, err := b.key(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse("key not found"), logical.ErrInvalidRequest
+	}
+	entity, err := b.entity(name, entry)
+	if err != nil {
+		return nil, err
+	}
+	signingKey, err := entitySigningKey(entity, config.Now(), config.SigningKeyId)
+	if errors.Is(err, errInvalidSigningKey) {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkHashForKey(signingKey, hashFunc); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	payload, err := containerSignaturePayload(dockerReference, manifestDigest, data.Get("creator").(string), creationTime)
+	if err != nil {
+		return nil, err
+	}
+	message, err := signInline(entity, payload, packet.CompressionNone, &config)
+	if errors.Is(err, errUnsupportedHash) {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	output, err := encodeOutput(message, "PGP MESSAGE", format)
+	if err != nil {
+		return nil, err
+	}
+	b.usage.record(name, usageSign)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"signature":      output,
+			"payload":        string(payload),
+			"hash_algorithm": hashAlgorithmName(hashFunc),
+		},
+	}, nil
+}
+
+func (b *backend) pathVerifyContainerImageWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	dockerReference := data.Get("docker_reference").(string)
+	if err := validateDockerReference(dockerReference); err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	manifestDigest := data.Get("manifest_digest").(string)
+	if err := validateManifestDigest(manifestDigest); err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	format := data.Get("format").(string)
+	switch format {
+	case "base64":
+	case "ascii-armor":
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("unsupported encoding format %s; must be \"base64\" or \"ascii-armor\"", format)), nil
+	}
+
+	name := data.Get("name").(string)
+	entry, err := b.key(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse("key not found"), logical.ErrInvalidRequest
+	}
+	entity, err := b.entity(name, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	result := malformedSignature()
+	if message, err := decodeSignature(data.Get("signature").(string), format, "PGP MESSAGE"); err == nil {
+		result = verifyInline(openpgp.EntityList{entity}, message, &packet.Config{})
+	}
+	b.usage.record(name, usageVerify)
+
+	// The payload is only parsed once the signature is known to be valid
+	var payload *containerSignature
+	if result.valid() {
+		payload, err = parseContainerSignaturePayload(result.plaintext)
+		switch {
+		case err != nil:
+			result.reason = reasonInvalidPayload
+		case payload.Critical.Identity.DockerReference != dockerReference:
+			result.reason = reasonIdentityMismatch
+		case payload.Critical.Image.DockerManifestDigest != manifestDigest:
+			result.reason = reasonDigestMismatch
+		}
+	}
+	result.plaintext = nil
+
+	resp := &logical.Response{
+		Data: result.toResponseData(),
+	}
+	if payload != nil {
+		resp.Data["docker_reference"] = payload.Critical.Identity.DockerReference
+		resp.Data["manifest_digest"] = payload.Critical.Image.DockerManifestDigest
+	}
+	return resp, nil
+}
+
+// containerSignature is the payload of a container signature.
+type containerSignature struct {
+	Critical struct {
+		Type  string `json:"type"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+	} `json:"critical"`
+	Optional map[string]interface{} `json:"optional"`
+}
+
+// containerSignaturePayload returns the payload of a container signature. The
+// keys are sorted, as done by the containers/image library.
+func containerSignaturePayload(dockerReference, manifestDigest, creator string, creationTime time.Time) ([]byte, error) {
+	optional := map[string]interface{}{
+		"timestamp": creationTime.Unix(),
+	}
+	if creator != "" {
+		optional["creator"] = creator
+	}
+	return json.Marshal(map[string]interface{}{
+		"critical": map[string]interface{}{
+			"type": containerSignatureType,
+			"image": map[string]string{
+				"docker-manifest-digest": manifestDigest,
+			},
+			"identity": map[string]string{
+				"docker-reference": dockerReference,
+			},
+		},
+		"optional": optional,
+	})
+}
+
+// parseContainerSignaturePayload parses the payload of a container signature.
+// Unknown critical fields are rejected since they may restrict the use of the
+// signature.
+func parseContainerSignaturePayload(payload []byte) (*containerSignature, error) {
+	var critical struct {
+		Critical json.RawMessage `json:"critical"`
+	}
+	if err := json.Unmarshal(payload, &critical); err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(critical.Critical))
+	decoder.DisallowUnknownFields()
+
+	var signature containerSignature
+	if err := decoder.Decode(&signature.Critical); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(payload, &signature); err != nil {
+		return nil, err
+	}
+	if signature.Critical.Type != containerSignatureType {
+		return nil, fmt.Errorf("unexpected signature type %s", signature.Critical.Type)
+	}
+	return &signature, nil
+}
+
+// validateDockerReference checks that the image reference is not empty and
+// has no whitespace.
+func validateDockerReference(dockerReference string) error {
+	if dockerReference == "" {
+		return errors.New("the docker reference is required")
+	}
+	if strings.ContainsAny(dockerReference, " \t\r\n") {
+		return fmt.Errorf("invalid docker reference %q", dockerReference)
+	}
+	return nil
+}
+
+// validateManifestDigest checks that the digest of the image manifest is in
+// the algorithm:hex form.
+func validateManifestDigest(manifestDigest string) error {
+	algorithm, encoded, _ := strings.Cut(manifestDigest, ":")
+	size, ok := imageDigestSizes[algorithm]
+	if !ok {
+		return fmt.Errorf("invalid manifest digest %q; must be sha256:<hex> or sha512:<hex>", manifestDigest)
+	}
+	digest, err := hex.DecodeString(encoded)
+	if err != nil || len(digest) != size || encoded != strings.ToLower(encoded) {
+		return fmt.Errorf("invalid manifest digest %q; must be sha256:<hex> or sha512:<hex>", manifestDigest)
+	}
+	return nil
+}
+
+const pathSignContainerImageHelpSyn = "Generate a container image signature using the named GPG key"
+const pathSignContainerImageHelpDesc = `
+This path signs the reference and the manifest digest of a container image in
+the atomic container signature format, verified by the signedBy requirement of
+the policies of podman, skopeo and CRI-O.
+`
+const pathVerifyContainerImageHelpSyn = "Verify a container image signature created using the named GPG key"
+const pathVerifyContainerImageHelpDesc = `
+This path verifies a container image signature in the atomic container
+signature format and checks that it was made for the given image reference and
+manifest digest.
+`
@@ -0,0 +1,283 @@
+package gpg
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestGPG_ContainerImage(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"generate": false,
+		"key":      gpgKey,
+	}, false)
+
+	request := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	creationTime := time.Now().Unix()
+	reference := "registry.example.com/app:1.0"
+	digest := "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	resp := request("sign/test/container-image", map[string]interface{}{
+		"docker_reference": reference,
+		"manifest_digest":  digest,
+		"creator":          "vault-gpg-plugin",
+		"creation_time":    creationTime,
+	})
+	if resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+	expectedPayload := `{"critical":{"identity":{"docker-reference":"registry.example.com/app:1.0"},` +
+		`"image":{"docker-manifest-digest":"sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"},` +
+		`"type":"atomic container signature"},"optional":{"creator":"vault-gpg-plugin","timestamp":` + strconv.FormatInt(creationTime, 10) + `}}`
+	if resp.Data["payload"] != expectedPayload {
+		t.Fatalf("unexpected payload: %s", resp.Data["payload"])
+	}
+	signature := resp.Data["signature"].(string)
+
+	// The signature is a signed message embedding the payload
+	message, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(gpgKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err := openpgp.ReadMessage(bytes.NewReader(message), keyring, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload map[string]interface{}
+	if err = json.NewDecoder(md.UnverifiedBody).Decode(&payload); err != nil {
+		t.Fatal(err)
+	}
+	if md.SignatureError != nil || md.SignedBy == nil {
+		t.Fatalf("expected a valid signed message: %v", md.SignatureError)
+	}
+
+	verify := func(signature, reference, digest string) *logical.Response {
+		return request("verify/test/container-image", map[string]interface{}{
+			"signature":        signature,
+			"docker_reference": reference,
+			"manifest_digest":  digest,
+		})
+	}
+	resp = verify(signature, reference, digest)
+	if resp.Data["valid"] != true || resp.Data["docker_reference"] != reference || resp.Data["manifest_digest"] != digest {
+		t.Fatalf("expected a valid container signature: %#v", resp.Data)
+	}
+	if _, ok := resp.Data["plaintext"]; ok {
+		t.Fatalf("the payload must not be returned as plaintext: %#v", resp.Data)
+	}
+
+	resp = verify(signature, "registry.example.com/app:2.0", digest)
+	if resp.Data["valid"] != false || resp.Data["reason"] != reasonIdentityMismatch || resp.Data["docker_reference"] != reference {
+		t.Fatalf("expected an identity mismatch: %#v", resp.Data)
+	}
+	otherDigest := "sha256:60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752"
+	resp = verify(signature, reference, otherDigest)
+	if resp.Data["valid"] != false || resp.Data["reason"] != reasonDigestMismatch {
+		t.Fatalf("expected a digest mismatch: %#v", resp.Data)
+	}
+
+	// Signed messages that are not container signatures
+	for _, payload := range []string{
+		"not json",
+		`{"critical":{"identity":{"docker-reference":"registry.example.com/app:1.0"},"image":{"docker-manifest-digest":"` + digest + `"},"type":"other"}}`,
+		`{"critical":{"identity":{"docker-reference":"registry.example.com/app:1.0"},"image":{"docker-manifest-digest":"` + digest + `"},"type":"atomic container signature","unknown":true}}`,
+	} {
+		resp = request("sign/test", map[string]interface{}{
+			"input":          base64.StdEncoding.EncodeToString([]byte(payload)),
+			"signature_type": "inline",
+		})
+		resp = verify(resp.Data["signature"].(string), reference, digest)
+		if resp.Data["valid"] != false || resp.Data["reason"] != reasonInvalidPayload {
+			t.Fatalf("expected an invalid payload for %s: %#v", payload, resp.Data)
+		}
+	}
+	// The signature uses the requested hash algorithm or is refused
+	for algorithm, expected := range map[string]crypto.Hash{"sha2-512": crypto.SHA512, "sha2-224": 0} {
+		resp = request("sign/test/container-image", map[string]interface{}{
+			"docker_reference": reference,
+			"manifest_digest":  digest,
+			"algorithm":        algorithm,
+		})
+		if expected == 0 {
+			if !resp.IsError() {
+				t.Fatalf("expected %s to be refused: %#v", algorithm, resp)
+			}
+			continue
+		}
+		if resp.IsError() || resp.Data["hash_algorithm"] != algorithm {
+			t.Fatalf("unexpected response for %s: %#v", algorithm, resp)
+		}
+		message, err := base64.StdEncoding.DecodeString(resp.Data["signature"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hashFunc, err := messageHash(message); err != nil || hashFunc != expected {
+			t.Fatalf("expected a %s signature, got %s: %v", algorithm, hashFunc, err)
+		}
+	}
+
+	resp = verify("not a signature", reference, digest)
+	if resp.Data["valid"] != false || resp.Data["reason"] != reasonMalformed {
+		t.Fatalf("expected a malformed signature: %#v", resp.Data)
+	}
+
+	for _, data := range []map[string]interface{}{
+		{"manifest_digest": digest},
+		{"docker_reference": "app with spaces", "manifest_digest": digest},
+		{"docker_reference": reference},
+		{"docker_reference": reference, "manifest_digest": "md5:9f86d081884c7d659a2feaa0c55ad015"},
+		{"docker_reference": reference, "manifest_digest": "sha256:9F86D081884C7D659A2FEAA0C55AD015A3BF4F1B2B0B822CD15D6C15B0F00A08"},
+		{"docker_reference": reference, "manifest_digest": "sha256:1234"},
+	} {
+		for _, path := range []string{"sign/test/container-image", "verify/test/container-image"} {
+			resp = request(path, data)
+			if !resp.IsError() {
+				t.Fatalf("expected an error for %s with %#v: %#v", path, data, resp)
+			}
+		}
+	}
+}
+
+func TestGPG_ContainerImageSigningKey(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	past := time.Now().Add(-time.Hour)
+	pastConfig := func(lifetime uint32) *packet.Config {
+		return &packet.Config{
+			Algorithm:       packet.PubKeyAlgoEd25519,
+			KeyLifetimeSecs: lifetime,
+			Time: func() time.Time {
+				return past
+			},
+		}
+	}
+	entity, err := openpgp.NewEntity("Vault GPG test", "", "vault@example.com", pastConfig(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The pinned subkey is older than the newest subkey, the expired subkey
+	// was valid a minute after its creation
+	for _, config := range []*packet.Config{pastConfig(0), pastConfig(60), {Algorithm: packet.PubKeyAlgoEd25519}} {
+		if err = entity.AddSigningSubkey(config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pinned, expired := entity.Subkeys[1].PublicKey, entity.Subkeys[2].PublicKey
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"generate": false,
+		"key":      testArmoredPrivateKey(t, entity),
+	}, false)
+	expiredEntity, err := openpgp.NewEntity("Vault GPG test", "", "vault@example.com", pastConfig(60))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAccStepCreateKey(t, b, storage, "expired", map[string]interface{}{
+		"generate": false,
+		"key":      testArmoredPrivateKey(t, expiredEntity),
+	}, false)
+
+	request := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	reference := "registry.example.com/app:1.0"
+	digest := "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	// signingKey returns the key ID of the container signature, checked at
+	// its creation time
+	signingKey := func(data map[string]interface{}) uint64 {
+		data["docker_reference"] = reference
+		data["manifest_digest"] = digest
+		resp := request("sign/test/container-image", data)
+		if resp.IsError() {
+			t.Fatal(resp.Error())
+		}
+		message, err := base64.StdEncoding.DecodeString(resp.Data["signature"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := &packet.Config{}
+		if creationTime, ok := data["creation_time"].(int64); ok {
+			config.Time = func() time.Time { return time.Unix(creationTime, 0) }
+		}
+		md, err := openpgp.ReadMessage(bytes.NewReader(message), openpgp.EntityList{entity}, nil, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(md.UnverifiedBody); err != nil {
+			t.Fatal(err)
+		}
+		if md.SignatureError != nil {
+			t.Fatalf("expected a valid signature with %#v: %s", data, md.SignatureError)
+		}
+		return md.SignedByKeyId
+	}
+
+	if keyID := signingKey(map[string]interface{}{"signing_key_id": fmt.Sprintf("%016X", pinned.KeyId)}); keyID != pinned.KeyId {
+		t.Fatalf("expected the pinned subkey to sign, got %016X", keyID)
+	}
+	// The key is checked at the creation time of the signature
+	creationTime := past.Add(30 * time.Second).Unix()
+	if keyID := signingKey(map[string]interface{}{
+		"signing_key_id": fmt.Sprintf("%016X", expired.KeyId),
+		"creation_time":  creationTime,
+	}); keyID != expired.KeyId {
+		t.Fatalf("expected the expired subkey to sign before its expiration, got %016X", keyID)
+	}
+
+	for path, data := range map[string]map[string]interface{}{
+		"sign/test/container-image":    {"signing_key_id": fmt.Sprintf("%016X", expired.KeyId)},
+		"sign/expired/container-image": {},
+	} {
+		data["docker_reference"] = reference
+		data["manifest_digest"] = digest
+		if resp := request(path, data); !resp.IsError() || !strings.Contains(resp.Error().Error(), "invalid signing key") {
+			t.Fatalf("expected the signing key to be refused for %s: %#v", path, resp)
+		}
+	}
+	if resp := request("sign/test/container-image", map[string]interface{}{
+		"docker_reference": reference,
+		"manifest_digest":  digest,
+		"signing_key_id":   "not a key ID",
+	}); !resp.IsError() {
+		t.Fatalf("expected an invalid signing key ID to be refused: %#v", resp)
+	}
+}
@@ -35,6 +35,7 @@ func pathDecrypt(b *backend) *framework.Path {
 				Type:        framework.TypeString,
 				Description: "The ASCII-armored GPG key of the signer of the ciphertext. If present, the signature must be valid.",
 			},
+			"batch_input": batchInputField,
 		},
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.UpdateOperation: &framework.PathOperation{
@@ -47,28 +48,36 @@ func pathDecrypt(b *backend) *framework.Path {
 }
 
 func (b *backend) pathDecryptWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	format := data.Get("format").(string)
-	switch format {
-	case "base64":
-	case "ascii-armor":
-	default:
-		return logical.ErrorResponse(fmt.Sprintf("unsupported encoding format %s; must be \"base64\" or \"ascii-armor\"", format)), nil
-	}
-
-	keyEntry, err := b.key(ctx, req.Storage, data.Get("name").(string))
+	name := data.Get("name").(string)
+	keyEntry, err := b.key(ctx, req.Storage, name)
 	if err != nil {
 		return nil, err
 	}
 	if keyEntry == nil {
 		return logical.ErrorResponse("key not found"), logical.ErrInvalidRequest
 	}
-
-	r := bytes.NewReader(keyEntry.SerializedKey)
-	keyring, err := openpgp.ReadKeyRing(r)
+	entity, err := b.entity(name, keyEntry)
 	if err != nil {
 		return nil, err
 	}
 
+	return b.handleBatch(ctx, req, data, func(data *framework.FieldData) (*logical.Response, error) {
+		return b.decrypt(name, entity, data)
+	})
+}
+
+// decrypt decrypts the ciphertext of the request with the named key.
+func (b *backend) decrypt(name string, entity *openpgp.Entity, data *framework.FieldData) (*logical.Response, error) {
+	format := data.Get("format").(string)
+	switch format {
+	case "base64":
+	case "ascii-armor":
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("unsupported encoding format %s; must be \"base64\" or \"ascii-armor\"", format)), nil
+	}
+
+	keyring := openpgp.EntityList{entity}
+
 	signerKey := data.Get("signer_key").(string)
 	if signerKey != "" {
 		el, err := openpgp.ReadArmoredKeyRing(strings.NewReader(signerKey))
@@ -108,6 +117,7 @@ func (b *backend) pathDecryptWrite(ctx context.Context, req *logical.Request, da
 	if signerKey != "" && (!md.IsSigned || md.SignedBy == nil || md.SignatureError != nil) {
 		return logical.ErrorResponse("Signature is invalid or not present: %s", md.SignatureError), nil
 	}
+	b.usage.record(name, usageDecrypt)
 
 	return &logical.Response{
 		Data: map[string]interface{}{
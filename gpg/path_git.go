@@ -0,0 +1,329 @@
+package gpg
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// gitSignatureHeaders are the headers of the commit signatures, by object
+// format of the repository.
+var gitSignatureHeaders = map[string]string{
+	"sha1":   "gpgsig",
+	"sha256": "gpgsig-sha256",
+}
+
+// gitSignatureStart starts the signatures appended to the tags.
+const gitSignatureStart = "-----BEGIN PGP SIGNATURE-----"
+
+func pathSignGit(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "sign/" + framework.GenericNameRegex("name") + "/git",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "The key to use",
+			},
+			"input": {
+				Type:        framework.TypeString,
+				Description: "The base64-encoded raw commit or tag object to sign",
+			},
+			"object_format": {
+				Type:        framework.TypeString,
+				Default:     "sha1",
+				Description: `Object format of the repository. Can be "sha1" or "sha256". Defaults to "sha1".`,
+			},
+			"algorithm": {
+				Type:        framework.TypeString,
+				Default:     "sha2-256",
+				Description: `Hash algorithm of the signature. Defaults to "sha2-256".`,
+			},
+			"creation_time": {
+				Type:        framework.TypeTime,
+				Description: "Creation time of the signature. Defaults to the current time.",
+			},
+			"signing_key_id": {
+				Type:        framework.TypeString,
+				Description: "Key ID of the subkey to sign with. Defaults to the newest valid signing subkey.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathSignGitWrite,
+			},
+		},
+		HelpSynopsis:    pathSignGitHelpSyn,
+		HelpDescription: pathSignGitHelpDesc,
+	}
+}
+
+func pathVerifyGit(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "verify/" + framework.GenericNameRegex("name") + "/git",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "The key to use",
+			},
+			"input": {
+				Type:        framework.TypeString,
+				Description: "The base64-encoded raw signed commit or tag object",
+			},
+			"reference_time": {
+				Type:        framework.TypeTime,
+				Description: "Time at which the signature is verified. Defaults to the current time.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathVerifyGitWrite,
+			},
+		},
+		HelpSynopsis:    pathVerifyGitHelpSyn,
+		HelpDescription: pathVerifyGitHelpDesc,
+	}
+}
+
+func (b *backend) pathSignGitWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	object, err := base64.StdEncoding.DecodeString(data.Get("input").(string))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("unable to decode input as base64: %s", err)), logical.ErrInvalidRequest
+	}
+	objectType, err := gitObjectType(object)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	if _, _, err := extractGitSignature(object); err == nil {
+		return logical.ErrorResponse("the object is already signed"), logical.ErrInvalidRequest
+	}
+	header, ok := gitSignatureHeaders[data.Get("object_format").(string)]
+	if !ok {
+		return logical.ErrorResponse(fmt.Sprintf("unsupported object format %s; must be \"sha1\" or \"sha256\"", data.Get("object_format").(string))), nil
+	}
+
+	algorithm := data.Get("algorithm").(string)
+	hashFunc, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return logical.ErrorResponse(fmt.Sprintf("unsupported algorithm %s", algorithm)), nil
+	}
+	config := packet.Config{DefaultHash: hashFunc}
+	config.SigningKeyId, err = parseSigningKeyID(data.Get("signing_key_id").(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if t, ok := data.GetOk("creation_time"); ok {
+		creationTime := t.(time.Time)
+		config.Time = func() time.Time {
+			return creationTime
+		}
+	}
+
+	name := data.Get("name").(string)
+	entry, err := b.key(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse("key not found"), logical.ErrInvalidRequest
+	}
+	entity, err := b.entity(name, entry)
+	if err != nil {
+		return nil, err
+	}
+	signingKey, err := entitySigningKey(entity, config.Now(), config.SigningKeyId)
+	if errors.Is(err, errInvalidSigningKey) {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkHashForKey(signingKey, hashFunc); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// The signature has an issuer fingerprint subpacket, required by git to
+	// find the key of the signer
+	signature, err := signDetached(entity, object, packet.SigTypeBinary, &signatureOptions{}, &config)
+	if err != nil {
+		return nil, err
+	}
+	armored, err := encodeOutput(signature, openpgp.SignatureType, "ascii-armor")
+	if err != nil {
+		return nil, err
+	}
+	// git expects the signature to end with a line break
+	armored += "\n"
+	b.usage.record(name, usageSign)
+
+	var signed []byte
+	if objectType == "tag" {
+		signed = append(append([]byte{}, object...), armored...)
+	} else {
+		signed = embedGitSignature(object, header, armored)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"signature":     armored,
+			"signed_object": base64.StdEncoding.EncodeToString(signed),
+		},
+	}, nil
+}
+
+func (b *backend) pathVerifyGitWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	object, err := base64.StdEncoding.DecodeString(data.Get("input").(string))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("unable to decode input as base64: %s", err)), logical.ErrInvalidRequest
+	}
+	objectType, err := gitObjectType(object)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	var referenceTime time.Time
+	if t, ok := data.GetOk("reference_time"); ok {
+		referenceTime = t.(time.Time)
+	}
+
+	name := data.Get("name").(string)
+	entry, err := b.key(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse("key not found"), logical.ErrInvalidRequest
+	}
+	entity, err := b.entity(name, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	result := malformedSignature()
+	if payload, signature, err := extractGitSignature(object); err == nil {
+		if packets, err := decodeSignature(signature, "ascii-armor", openpgp.SignatureType); err == nil {
+			result = verifyDetached(openpgp.EntityList{entity}, bytes.NewReader(payload), packets, verificationConfig(nil, referenceTime))
+		}
+	}
+	b.usage.record(name, usageVerify)
+
+	resp := &logical.Response{
+		Data: result.toResponseData(),
+	}
+	resp.Data["object_type"] = objectType
+	return resp, nil
+}
+
+// gitObjectType returns the type of a raw commit or tag object.
+func gitObjectType(object []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(object, []byte("tree ")):
+		return "commit", nil
+	case bytes.HasPrefix(object, []byte("object ")):
+		return "tag", nil
+	default:
+		return "", errors.New("the input is not a raw commit or tag object")
+	}
+}
+
+// embedGitSignature returns the commit with the signature in a header added at
+// the end of the headers. The lines of the signature after the first one are
+// indented by a space, as done by git.
+func embedGitSignature(commit []byte, header, signature string) []byte {
+	end := bytes.Index(commit, []byte("\n\n"))
+	if end == -1 {
+		end = len(commit)
+	} else {
+		end++
+	}
+
+	var signed bytes.Buffer
+	signed.Write(commit[:end])
+	signed.WriteString(header + " " + strings.ReplaceAll(strings.TrimSuffix(signature, "\n"), "\n", "\n "))
+	signed.WriteString("\n")
+	signed.Write(commit[end:])
+	return signed.Bytes()
+}
+
+// extractGitSignature returns the signed payload and the armored signature of
+// a signed commit or tag. The signature of a commit is in a gpgsig or
+// gpgsig-sha256 header, the headers of both object formats are removed from
+// the payload. The signature of a tag is appended to the tag.
+func extractGitSignature(object []byte) ([]byte, string, error) {
+	objectType, err := gitObjectType(object)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// git uses the last signature appended to a tag
+	if objectType == "tag" {
+		start := -1
+		for i := 0; i < len(object); {
+			if bytes.HasPrefix(object[i:], []byte(gitSignatureStart)) {
+				start = i
+			}
+			next := bytes.IndexByte(object[i:], '\n')
+			if next == -1 {
+				break
+			}
+			i += next + 1
+		}
+		if start == -1 {
+			return nil, "", errors.New("the tag is not signed")
+		}
+		return object[:start], string(object[start:]), nil
+	}
+
+	signatures := make(map[string]string)
+	var current string
+	var out bytes.Buffer
+	lines := bytes.SplitAfter(object, []byte("\n"))
+	for i, line := range lines {
+		// The headers end with the first empty line
+		if len(bytes.TrimRight(line, "\n")) == 0 {
+			for _, rest := range lines[i:] {
+				out.Write(rest)
+			}
+			break
+		}
+		if current != "" && bytes.HasPrefix(line, []byte(" ")) {
+			signatures[current] += string(line[1:])
+			continue
+		}
+		current = ""
+		for _, h := range gitSignatureHeaders {
+			if bytes.HasPrefix(line, []byte(h+" ")) {
+				current = h
+				signatures[h] = string(line[len(h)+1:])
+			}
+		}
+		if current == "" {
+			out.Write(line)
+		}
+	}
+
+	for _, h := range []string{gitSignatureHeaders["sha1"], gitSignatureHeaders["sha256"]} {
+		if signature, ok := signatures[h]; ok {
+			return out.Bytes(), signature, nil
+		}
+	}
+	return nil, "", errors.New("the commit is not signed")
+}
+
+const pathSignGitHelpSyn = "Sign a git commit or tag using the named GPG key"
+const pathSignGitHelpDesc = `
+This path returns the armored detached signature of a raw git commit or tag
+object, and the signed object with the signature embedded as done by git.
+`
+const pathVerifyGitHelpSyn = "Verify a signed git commit or tag using the named GPG key"
+const pathVerifyGitHelpDesc = `
+This path extracts the signature embedded in a raw git commit or tag object
+and verifies it with the named GPG key.
+`
@@ -0,0 +1,125 @@
+package gpg
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestGPG_Git(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"generate": false,
+		"key":      gpgKey,
+	}, false)
+	testAccStepCreateKey(t, b, storage, "other", map[string]interface{}{
+		"real_name": "Vault GPG test",
+	}, false)
+
+	request := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	sign := func(object string, data map[string]interface{}) (string, string) {
+		data["input"] = base64.StdEncoding.EncodeToString([]byte(object))
+		resp := request("sign/test/git", data)
+		if resp.IsError() {
+			t.Fatal(resp.Error())
+		}
+		signed, err := base64.StdEncoding.DecodeString(resp.Data["signed_object"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Data["signature"].(string), string(signed)
+	}
+	verify := func(name, object string) *logical.Response {
+		return request("verify/"+name+"/git", map[string]interface{}{
+			"input": base64.StdEncoding.EncodeToString([]byte(object)),
+		})
+	}
+
+	commit := "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+		"parent 8d62ce4a0c1f5b0fdbf3c4a1e2d4c9b1c0a7e5f3\n" +
+		"author Vault GPG <vault@example.com> 1700000000 +0100\n" +
+		"committer Vault GPG <vault@example.com> 1700000000 +0100\n" +
+		"\n" +
+		"Release 1.0\n\n- first line\n"
+	signature, signed := sign(commit, map[string]interface{}{})
+	if !strings.HasPrefix(signature, "-----BEGIN PGP SIGNATURE-----\n") || !strings.HasSuffix(signature, "-----END PGP SIGNATURE-----\n") {
+		t.Fatalf("expected an armored signature: %s", signature)
+	}
+	// The signature is the last header, its lines are indented by a space
+	expected := strings.Replace(commit, "\n\n", "\ngpgsig "+strings.ReplaceAll(strings.TrimSuffix(signature, "\n"), "\n", "\n ")+"\n\n", 1)
+	if signed != expected {
+		t.Fatalf("unexpected signed commit:\n%s\nexpected:\n%s", signed, expected)
+	}
+
+	resp := verify("test", signed)
+	if resp.Data["valid"] != true || resp.Data["object_type"] != "commit" {
+		t.Fatalf("expected a valid commit signature: %#v", resp.Data)
+	}
+	resp = verify("test", strings.Replace(signed, "Release 1.0", "Release 2.0", 1))
+	if resp.Data["valid"] != false || resp.Data["reason"] != reasonBadSignature {
+		t.Fatalf("expected a bad signature: %#v", resp.Data)
+	}
+	resp = verify("other", signed)
+	if resp.Data["valid"] != false || resp.Data["reason"] != reasonUnknownIssuer {
+		t.Fatalf("expected an unknown issuer: %#v", resp.Data)
+	}
+
+	// Repositories using the SHA-256 object format
+	_, signed = sign(commit, map[string]interface{}{"object_format": "sha256"})
+	if !strings.Contains(signed, "\ngpgsig-sha256 -----BEGIN PGP SIGNATURE-----\n") {
+		t.Fatalf("expected a gpgsig-sha256 header:\n%s", signed)
+	}
+	if resp = verify("test", signed); resp.Data["valid"] != true {
+		t.Fatalf("expected a valid commit signature: %#v", resp.Data)
+	}
+
+	tag := "object 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+		"type commit\n" +
+		"tag v1.0\n" +
+		"tagger Vault GPG <vault@example.com> 1700000000 +0100\n" +
+		"\n" +
+		"Release 1.0\n"
+	signature, signed = sign(tag, map[string]interface{}{})
+	if signed != tag+signature {
+		t.Fatalf("expected the signature to be appended to the tag:\n%s", signed)
+	}
+	resp = verify("test", signed)
+	if resp.Data["valid"] != true || resp.Data["object_type"] != "tag" {
+		t.Fatalf("expected a valid tag signature: %#v", resp.Data)
+	}
+
+	// Unsigned objects
+	for _, object := range []string{commit, tag} {
+		resp = verify("test", object)
+		if resp.Data["valid"] != false || resp.Data["reason"] != reasonMalformed {
+			t.Fatalf("expected a malformed signature: %#v", resp.Data)
+		}
+	}
+
+	for _, data := range []map[string]interface{}{
+		{"input": base64.StdEncoding.EncodeToString([]byte(signed))},
+		{"input": base64.StdEncoding.EncodeToString([]byte("not an object"))},
+		{"input": base64.StdEncoding.EncodeToString([]byte(commit)), "object_format": "md5"},
+	} {
+		resp = request("sign/test/git", data)
+		if !resp.IsError() {
+			t.Fatalf("expected an error with %#v: %#v", data, resp)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package gpg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	jobStatusPending   = "pending"
+	jobStatusCompleted = "completed"
+	jobStatusFailed    = "failed"
+	jobStatusCancelled = "cancelled"
+)
+
+// jobRecordTTL is how long the records of the finished jobs are kept.
+const jobRecordTTL = 24 * time.Hour
+
+func pathListJobs(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "jobs/?$",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.pathJobList,
+			},
+		},
+		HelpSynopsis:    pathJobsHelpSyn,
+		HelpDescription: pathJobsHelpDesc,
+	}
+}
+
+func pathJobs(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "jobs/" + framework.GenericNameRegex("id"),
+		Fields: map[string]*framework.FieldSchema{
+			"id": {
+				Type:        framework.TypeString,
+				Description: "Identifier of the job.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathJobRead,
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.pathJobDelete,
+			},
+		},
+		HelpSynopsis:    pathJobsHelpSyn,
+		HelpDescription: pathJobsHelpDesc,
+	}
+}
+
+func (b *backend) job(ctx context.Context, s logical.Storage, id string) (*keyGenerationJob, error) {
+	entry, err := s.Get(ctx, "job/"+id)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result keyGenerationJob
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (b *backend) storeJob(ctx context.Context, storage logical.Storage, job *keyGenerationJob) error {
+	entry, err := logical.StorageEntryJSON("job/"+job.ID, job)
+	if err != nil {
+		return err
+	}
+	return storage.Put(ctx, entry)
+}
+
+func (b *backend) startKeyGenerationJob(ctx context.Context, req *logical.Request, name, realName, comment, email string, keyBits int, exportable bool) (*logical.Response, error) {
+	if packet.NewUserId(realName, comment, email) == nil {
+		return logical.ErrorResponse("the identity must not contain any of \"()<>\\x00\""), nil
+	}
+	// The job outlives the request, it uses the storage of the backend
+	storage := b.storage
+	if storage == nil {
+		return nil, errors.New("the backend has not been set up")
+	}
+
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+	job := &keyGenerationJob{
+		ID:           id,
+		KeyName:      name,
+		Status:       jobStatusPending,
+		CreationTime: time.Now(),
+	}
+	if err := b.storeJob(ctx, storage, job); err != nil {
+		return nil, err
+	}
+
+	jobCtx, cancel := context.WithCancel(b.ctx)
+	b.jobsLock.Lock()
+	b.jobs[id] = cancel
+	b.jobsLock.Unlock()
+
+	go func() {
+		defer func() {
+			b.jobsLock.Lock()
+			delete(b.jobs, id)
+			b.jobsLock.Unlock()
+			cancel()
+		}()
+
+		entity, err := b.generateEntity(jobCtx, storage, realName, comment, email, keyBits)
+		if err == nil {
+			err = b.storeGeneratedKey(jobCtx, storage, name, entity, exportable)
+		}
+
+		// The job is completed as soon as the key is stored, even when it
+		// is cancelled afterwards
+		switch {
+		case errors.Is(err, context.Canceled):
+			job.Status = jobStatusCancelled
+		case err != nil:
+			job.Status = jobStatusFailed
+			job.Error = err.Error()
+		default:
+			job.Status = jobStatusCompleted
+		}
+		job.CompletionTime = time.Now()
+
+		// The job context might be done at this point, the outcome must
+		// nevertheless be recorded.
+		if err := b.storeJob(context.Background(), storage, job); err != nil {
+			b.Logger().Error("failed to record the outcome of the key generation job", "id", id, "error", err)
+		}
+	}()
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"job_id": id,
+		},
+	}, nil
+}
+
+func (b *backend) pathJobList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, "job/")
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(entries), nil
+}
+
+func (b *backend) pathJobRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	job, err := b.job(ctx, req.Storage, data.Get("id").(string))
+	if err != nil {
+		return nil, err
+	}
+	if job == nil {
+		return nil, nil
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"id":            job.ID,
+			"key_name":      job.KeyName,
+			"status":        job.Status,
+			"creation_time": job.CreationTime,
+		},
+	}
+	if job.Error != "" {
+		resp.Data["error"] = job.Error
+	}
+	if !job.CompletionTime.IsZero() {
+		resp.Data["completion_time"] = job.CompletionTime
+	}
+
+	return resp, nil
+}
+
+func (b *backend) pathJobDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	id := data.Get("id").(string)
+
+	b.jobsLock.Lock()
+	cancel, running := b.jobs[id]
+	b.jobsLock.Unlock()
+	if running {
+		cancel()
+		return nil, nil
+	}
+
+	return nil, req.Storage.Delete(ctx, "job/"+id)
+}
+
+// pruneJobs removes the records of the jobs finished for longer than
+// jobRecordTTL.
+func (b *backend) pruneJobs(ctx context.Context, storage logical.Storage) error {
+	ids, err := storage.List(ctx, "job/")
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		job, err := b.job(ctx, storage, id)
+		if err != nil {
+			return err
+		}
+		if job == nil || job.Status == jobStatusPending || time.Since(job.CompletionTime) < jobRecordTTL {
+			continue
+		}
+		if err := storage.Delete(ctx, "job/"+id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// failInterruptedJobs marks as failed the jobs still pending when the backend
+// is initialized, they were interrupted by the restart of the plugin.
+func (b *backend) failInterruptedJobs(ctx context.Context, storage logical.Storage) error {
+	ids, err := storage.List(ctx, "job/")
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		job, err := b.job(ctx, storage, id)
+		if err != nil {
+			return err
+		}
+		if job == nil || job.Status != jobStatusPending {
+			continue
+		}
+		job.Status = jobStatusFailed
+		job.Error = "the job was interrupted by a restart of the plugin"
+		job.CompletionTime = time.Now()
+		if err := b.storeJob(ctx, storage, job); err != nil {
+			return fmt.Errorf("failed to record the interruption of the job %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+type keyGenerationJob struct {
+	ID             string
+	KeyName        string
+	Status         string
+	Error          string
+	CreationTime   time.Time
+	CompletionTime time.Time
+}
+
+const pathJobsHelpSyn = "Follow the asynchronous generation of GPG keys"
+const pathJobsHelpDesc = `
+This path is used to follow the status of the keys generated asynchronously.
+Deleting a pending job cancels it, deleting a finished job removes its record.
+The records of the finished jobs are removed after 24 hours. The jobs
+interrupted by a restart of the plugin are reported as failed.
+`
@@ -0,0 +1,253 @@
+package gpg
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestGPG_AsyncKeyGeneration(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	createAsync := func(name string, data map[string]interface{}) *logical.Response {
+		data["async"] = true
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "keys/" + name,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	waitJob := func(id string) *logical.Response {
+		deadline := time.Now().Add(time.Minute)
+		for time.Now().Before(deadline) {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Storage:   storage,
+				Operation: logical.ReadOperation,
+				Path:      "jobs/" + id,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp == nil {
+				t.Fatalf("job %s not found", id)
+			}
+			if resp.Data["status"] != jobStatusPending {
+				return resp
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("job %s did not finish in time", id)
+		return nil
+	}
+
+	resp := createAsync("test", map[string]interface{}{
+		"real_name": "Vault GPG test",
+		"email":     "vault@example.com",
+	})
+	if resp == nil || resp.IsError() {
+		t.Fatalf("not expected response: %#v", resp)
+	}
+	id := resp.Data["job_id"].(string)
+
+	job := waitJob(id)
+	if job.Data["status"] != jobStatusCompleted {
+		t.Fatalf("expected the job to be completed: %#v", job.Data)
+	}
+	if job.Data["key_name"] != "test" {
+		t.Fatalf("unexpected key name: %#v", job.Data)
+	}
+	testAccStepReadKey(t, b, storage, "test", map[string]interface{}{"key_bits": 2048})
+
+	listResp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Storage:   storage,
+		Operation: logical.ListOperation,
+		Path:      "jobs/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := listResp.Data["keys"].([]string); len(keys) != 1 || keys[0] != id {
+		t.Fatalf("unexpected list of jobs: %#v", keys)
+	}
+
+	// Deleting a finished job removes it
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Storage:   storage,
+		Operation: logical.DeleteOperation,
+		Path:      "jobs/" + id,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Storage:   storage,
+		Operation: logical.ReadOperation,
+		Path:      "jobs/" + id,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != nil {
+		t.Fatalf("expected the job to be deleted: %#v", resp)
+	}
+
+	// Existing key
+	resp = createAsync("test", map[string]interface{}{})
+	if !resp.IsError() {
+		t.Fatal("expected an error because the key already exists")
+	}
+
+	// Invalid identity
+	resp = createAsync("test2", map[string]interface{}{
+		"real_name": "Vault<>",
+	})
+	if !resp.IsError() {
+		t.Fatal("expected an error because the identity is invalid")
+	}
+
+	// Too small key
+	resp = createAsync("test2", map[string]interface{}{
+		"key_bits": 1024,
+	})
+	if !resp.IsError() {
+		t.Fatal("expected an error because the key is too small")
+	}
+}
+
+func TestGPG_GenerateEntityCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := Backend().generateEntity(ctx, &logical.InmemStorage{}, "Vault GPG test", "", "vault@example.com", 4096)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the generation to be cancelled, got %v", err)
+	}
+}
+
+func TestGPG_GenerateEntityInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	// The generation of such a key takes far longer than the timeout when
+	// it is not interrupted
+	start := time.Now()
+	_, err := Backend().generateEntity(ctx, &logical.InmemStorage{}, "Vault GPG test", "", "vault@example.com", 16384)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the generation to be cancelled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("the generation was not interrupted, it took %s", elapsed)
+	}
+}
+
+func TestGPG_AsyncKeyGenerationWithoutSetup(t *testing.T) {
+	_, err := Backend().HandleRequest(context.Background(), &logical.Request{
+		Storage:   &logical.InmemStorage{},
+		Operation: logical.UpdateOperation,
+		Path:      "keys/test",
+		Data: map[string]interface{}{
+			"async": true,
+		},
+	})
+	if err == nil {
+		t.Fatal("expected an error because the backend has no storage of its own")
+	}
+}
+
+func TestGPG_JobRecords(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+	ctx := context.Background()
+
+	jobs := []*keyGenerationJob{
+		{ID: "pending", Status: jobStatusPending, CreationTime: time.Now()},
+		{ID: "recent", Status: jobStatusCompleted, CompletionTime: time.Now()},
+		{ID: "old", Status: jobStatusFailed, CompletionTime: time.Now().Add(-jobRecordTTL - time.Minute)},
+	}
+	for _, job := range jobs {
+		if err := b.storeJob(ctx, storage, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The old records are removed
+	if err := b.PeriodicFunc(ctx, &logical.Request{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := storage.List(ctx, "job/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != "pending" || ids[1] != "recent" {
+		t.Fatalf("unexpected remaining jobs: %v", ids)
+	}
+
+	// The jobs pending on initialization were interrupted
+	if err := b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	job, err := b.job(ctx, storage, "pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != jobStatusFailed || job.Error == "" || job.CompletionTime.IsZero() {
+		t.Fatalf("expected the pending job to be failed: %#v", job)
+	}
+	if job, _ = b.job(ctx, storage, "recent"); job.Status != jobStatusCompleted {
+		t.Fatalf("expected the finished job to be kept as is: %#v", job)
+	}
+}
+
+func TestGPG_AsyncKeyGenerationCancelled(t *testing.T) {
+	logicalBackend, storage := getTestBackend(t)
+	b := logicalBackend.(*backend)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "keys/test",
+		Data: map[string]interface{}{
+			"key_bits": 4096,
+			"async":    true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := resp.Data["job_id"].(string)
+
+	// Unloading the backend cancels the jobs in progress
+	b.Cleanup(context.Background())
+
+	deadline := time.Now().Add(time.Minute)
+	for {
+		job, err := b.job(context.Background(), storage, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status == jobStatusCancelled {
+			break
+		}
+		if job.Status != jobStatusPending || time.Now().After(deadline) {
+			t.Fatalf("expected the job to be cancelled: %#v", job)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	entry, err := b.key(context.Background(), storage, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry != nil {
+		t.Fatal("the key of a cancelled job must not be stored")
+	}
+}
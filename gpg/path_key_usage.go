@@ -0,0 +1,261 @@
+package gpg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	usageSign           = "sign"
+	usageVerify         = "verify"
+	usageDecrypt        = "decrypt"
+	usageShowSessionKey = "show_session_key"
+)
+
+func pathKeyUsage(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "keys/" + framework.GenericNameRegex("name") + "/usage",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the key",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.pathKeyUsageReset,
+			},
+		},
+		HelpSynopsis:    pathKeyUsageHelpSyn,
+		HelpDescription: pathKeyUsageHelpDesc,
+	}
+}
+
+// usageTracker accumulates in memory the usage of the keys until it is
+// flushed to the storage, so the operations do not write to the storage.
+type usageTracker struct {
+	lock    sync.Mutex
+	pending map[string]*keyUsage
+}
+
+func newUsageTracker() *usageTracker {
+	return &usageTracker{
+		pending: make(map[string]*keyUsage),
+	}
+}
+
+func (t *usageTracker) record(name, operation string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	usage, ok := t.pending[name]
+	if !ok {
+		usage = &keyUsage{}
+		t.pending[name] = usage
+	}
+	usage.add(operation, 1, time.Now())
+}
+
+// get returns a copy of the usage of the key that has not been flushed yet.
+func (t *usageTracker) get(name string) *keyUsage {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	result := &keyUsage{}
+	result.merge(t.pending[name])
+	return result
+}
+
+func (t *usageTracker) forget(name string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	delete(t.pending, name)
+}
+
+// names returns the names of the keys with usage not flushed yet.
+func (t *usageTracker) names() []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	names := make([]string, 0, len(t.pending))
+	for name := range t.pending {
+		names = append(names, name)
+	}
+	return names
+}
+
+// take removes and returns the usage of the key not flushed yet.
+func (t *usageTracker) take(name string) *keyUsage {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	usage := t.pending[name]
+	delete(t.pending, name)
+	return usage
+}
+
+// restore adds back usage that could not be flushed.
+func (t *usageTracker) restore(name string, usage *keyUsage) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if pending, ok := t.pending[name]; ok {
+		usage.merge(pending)
+	}
+	t.pending[name] = usage
+}
+
+func (b *backend) storedKeyUsage(ctx context.Context, s logical.Storage, name string) (*keyUsage, error) {
+	entry, err := s.Get(ctx, "usage/"+name)
+	if err != nil {
+		return nil, err
+	}
+
+	var result keyUsage
+	if entry == nil {
+		return &result, nil
+	}
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// keyUsage returns the usage of the key, including the usage not flushed to
+// the storage yet.
+func (b *backend) keyUsage(ctx context.Context, s logical.Storage, name string) (*keyUsage, error) {
+	usage, err := b.storedKeyUsage(ctx, s, name)
+	if err != nil {
+		return nil, err
+	}
+	usage.merge(b.usage.get(name))
+	return usage, nil
+}
+
+// flushKeyUsage writes to the storage the usage accumulated in memory. It is
+// called periodically by Vault and when the backend is unloaded. The failure
+// to flush the usage of a key does not prevent the usage of the other keys
+// from being flushed.
+func (b *backend) flushKeyUsage(ctx context.Context, storage logical.Storage) error {
+	var errs []error
+	for _, name := range b.usage.names() {
+		if err := b.flushUsageOfKey(ctx, storage, name); err != nil {
+			errs = append(errs, fmt.Errorf("unable to flush the usage of the key %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// flushUsageOfKey writes to the storage the usage of the key accumulated in
+// memory. The usage is taken while holding the lock of the key, so a reset
+// cannot happen between the moment it is taken and the moment it is written.
+func (b *backend) flushUsageOfKey(ctx context.Context, storage logical.Storage, name string) error {
+	lock := locksutil.LockForKey(b.keyLocks, name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	pending := b.usage.take(name)
+	if pending == nil {
+		return nil
+	}
+	if err := b.writeUsageOfKey(ctx, storage, name, pending); err != nil {
+		// Keep the usage for the next flush
+		b.usage.restore(name, pending)
+		return err
+	}
+	return nil
+}
+
+func (b *backend) writeUsageOfKey(ctx context.Context, storage logical.Storage, name string, pending *keyUsage) error {
+	// The usage of a deleted key must not be recreated
+	keyEntry, err := storage.Get(ctx, "key/"+name)
+	if err != nil {
+		return err
+	}
+	if keyEntry == nil {
+		return nil
+	}
+
+	usage, err := b.storedKeyUsage(ctx, storage, name)
+	if err != nil {
+		return err
+	}
+	usage.merge(pending)
+
+	entry, err := logical.StorageEntryJSON("usage/"+name, usage)
+	if err != nil {
+		return err
+	}
+	return storage.Put(ctx, entry)
+}
+
+func (b *backend) deleteKeyUsage(ctx context.Context, storage logical.Storage, name string) error {
+	b.usage.forget(name)
+	return storage.Delete(ctx, "usage/"+name)
+}
+
+func (b *backend) pathKeyUsageReset(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+
+	lock := locksutil.LockForKey(b.keyLocks, name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	return nil, b.deleteKeyUsage(ctx, req.Storage, name)
+}
+
+type keyUsage struct {
+	Counts   map[string]uint64
+	LastUsed map[string]time.Time
+}
+
+func (u *keyUsage) add(operation string, count uint64, lastUsed time.Time) {
+	if u.Counts == nil {
+		u.Counts = make(map[string]uint64)
+		u.LastUsed = make(map[string]time.Time)
+	}
+	u.Counts[operation] += count
+	if lastUsed.After(u.LastUsed[operation]) {
+		u.LastUsed[operation] = lastUsed
+	}
+}
+
+func (u *keyUsage) merge(other *keyUsage) {
+	if other == nil {
+		return
+	}
+	for operation, count := range other.Counts {
+		u.add(operation, count, other.LastUsed[operation])
+	}
+}
+
+// toResponseData returns the usage of every operation, operations that have
+// never been used have a count of 0 and no last used time.
+func (u *keyUsage) toResponseData() map[string]interface{} {
+	result := make(map[string]interface{})
+	for _, operation := range []string{usageSign, usageVerify, usageDecrypt, usageShowSessionKey} {
+		data := map[string]interface{}{
+			"count": u.Counts[operation],
+		}
+		if lastUsed, ok := u.LastUsed[operation]; ok {
+			data["last_used"] = lastUsed
+		}
+		result[operation] = data
+	}
+	return result
+}
+
+const pathKeyUsageHelpSyn = "Reset the usage statistics of a named GPG key"
+const pathKeyUsageHelpDesc = `
+This path is used to reset the usage statistics of the named key. The usage
+statistics are returned when the key is read.
+`
@@ -0,0 +1,254 @@
+package gpg
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestGPG_KeyUsage(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"generate": false,
+		"key":      gpgKey,
+	}, false)
+
+	sign := func() string {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "sign/test",
+			Data: map[string]interface{}{
+				"input": "dGhlIHF1aWNrIGJyb3duIGZveA==",
+			},
+		})
+		if err != nil || resp.IsError() {
+			t.Fatalf("unexpected error: %v %#v", err, resp)
+		}
+		return resp.Data["signature"].(string)
+	}
+	usage := func(operation string) map[string]interface{} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "keys/test",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Data["usage"].(map[string]interface{})[operation].(map[string]interface{})
+	}
+	flush := func() {
+		if err := b.PeriodicFunc(context.Background(), &logical.Request{Storage: storage}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if usage(usageSign)["count"] != uint64(0) || usage(usageSign)["last_used"] != nil {
+		t.Fatalf("expected the key to never have been used: %#v", usage(usageSign))
+	}
+
+	signature := sign()
+	sign()
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "verify/test",
+		Data: map[string]interface{}{
+			"input":     "dGhlIHF1aWNrIGJyb3duIGZveA==",
+			"signature": signature,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage(usageSign)["count"] != uint64(2) || usage(usageSign)["last_used"] == nil {
+		t.Fatalf("unexpected sign usage: %#v", usage(usageSign))
+	}
+	if usage(usageVerify)["count"] != uint64(1) {
+		t.Fatalf("unexpected verify usage: %#v", usage(usageVerify))
+	}
+
+	// Usage is written to the storage in batches
+	entry, err := storage.Get(context.Background(), "usage/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry != nil {
+		t.Fatal("the usage must not be written on each operation")
+	}
+	flush()
+	stored, err := b.storedKeyUsage(context.Background(), storage, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Counts[usageSign] != 2 || stored.Counts[usageVerify] != 1 {
+		t.Fatalf("unexpected stored usage: %#v", stored)
+	}
+	sign()
+	if usage(usageSign)["count"] != uint64(3) {
+		t.Fatalf("expected stored and pending usage to be merged: %#v", usage(usageSign))
+	}
+
+	// Reset
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Storage:   storage,
+		Operation: logical.DeleteOperation,
+		Path:      "keys/test/usage",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage(usageSign)["count"] != uint64(0) || usage(usageVerify)["count"] != uint64(0) {
+		t.Fatalf("expected the usage to be reset: %#v", usage(usageSign))
+	}
+
+	// The usage of a deleted key is removed
+	sign()
+	flush()
+	sign()
+	testAccStepDeleteKey(t, b, storage, "test")
+	flush()
+	entry, err = storage.Get(context.Background(), "usage/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry != nil {
+		t.Fatal("expected the usage of the deleted key to be removed")
+	}
+}
+
+func TestGPG_KeyUsageResetDuringFlush(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+	ctx := context.Background()
+
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"generate": false,
+		"key":      gpgKey,
+	}, false)
+	b.usage.record("test", usageSign)
+
+	// The flush waits for the reset holding the lock of the key, the usage
+	// recorded before the reset must not be written back afterwards
+	lock := locksutil.LockForKey(b.keyLocks, "test")
+	lock.Lock()
+	done := make(chan error)
+	go func() {
+		done <- b.flushKeyUsage(ctx, storage)
+	}()
+	if err := b.deleteKeyUsage(ctx, storage, "test"); err != nil {
+		t.Fatal(err)
+	}
+	lock.Unlock()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := b.keyUsage(ctx, storage, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Counts[usageSign] != 0 {
+		t.Fatalf("expected the usage to stay reset: %#v", usage)
+	}
+}
+
+// failingStorage fails to read the entries with the prefix.
+type failingStorage struct {
+	logical.Storage
+	prefix string
+}
+
+func (s *failingStorage) Get(ctx context.Context, key string) (*logical.StorageEntry, error) {
+	if strings.HasPrefix(key, s.prefix) {
+		return nil, errors.New("storage failure")
+	}
+	return s.Storage.Get(ctx, key)
+}
+
+func TestGPG_PeriodicTasksAreIndependent(t *testing.T) {
+	storage := &failingStorage{Storage: &logical.InmemStorage{}, prefix: "usage/"}
+	b := Backend()
+	ctx := context.Background()
+
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"generate": false,
+		"key":      gpgKey,
+	}, false)
+	b.usage.record("test", usageSign)
+	job := &keyGenerationJob{ID: "old", Status: jobStatusCompleted, CompletionTime: time.Now().Add(-jobRecordTTL - time.Minute)}
+	if err := b.storeJob(ctx, storage, job); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.PeriodicFunc(ctx, &logical.Request{Storage: storage}); err == nil {
+		t.Fatal("expected the flush of the usage to fail")
+	}
+	if ids, err := storage.List(ctx, "job/"); err != nil || len(ids) != 0 {
+		t.Fatalf("expected the old job to be pruned despite the failure: %v %v", ids, err)
+	}
+	if usage := b.usage.get("test"); usage.Counts[usageSign] != 1 {
+		t.Fatalf("expected the usage to be kept for the next flush: %#v", usage)
+	}
+}
+
+func TestGPG_KeyUsageFlushOfEachKey(t *testing.T) {
+	storage := &failingStorage{Storage: &logical.InmemStorage{}, prefix: "usage/first"}
+	b := Backend()
+	ctx := context.Background()
+
+	for _, name := range []string{"first", "second"} {
+		testAccStepCreateKey(t, b, storage, name, map[string]interface{}{
+			"generate": false,
+			"key":      gpgKey,
+		}, false)
+		b.usage.record(name, usageSign)
+	}
+
+	if err := b.flushKeyUsage(ctx, storage); err == nil || !strings.Contains(err.Error(), "first") {
+		t.Fatalf("expected the flush of the usage of the first key to fail: %v", err)
+	}
+	if usage := b.usage.get("first"); usage.Counts[usageSign] != 1 {
+		t.Fatalf("expected the usage of the first key to be kept for the next flush: %#v", usage)
+	}
+	if usage := b.usage.get("second"); len(usage.Counts) != 0 {
+		t.Fatalf("expected the usage of the second key to be flushed: %#v", usage)
+	}
+	usage, err := b.storedKeyUsage(ctx, storage, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Counts[usageSign] != 1 {
+		t.Fatalf("expected the usage of the second key to be stored: %#v", usage)
+	}
+}
+
+func TestGPG_KeyUsageFlushedOnCleanup(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"generate": false,
+		"key":      gpgKey,
+	}, false)
+	gpgBackend := b.(*backend)
+	gpgBackend.usage.record("test", usageSign)
+
+	b.Cleanup(ctx)
+
+	usage, err := gpgBackend.storedKeyUsage(ctx, storage, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Counts[usageSign] != 1 {
+		t.Fatalf("expected the usage to be flushed when the backend is unloaded: %#v", usage)
+	}
+}
@@ -3,7 +3,9 @@ package gpg
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
@@ -67,6 +69,10 @@ func pathKeys(b *backend) *framework.Path {
 				Default:     true,
 				Description: "Determines if a key should be generated by Vault or if a key is being passed from another service.",
 			},
+			"async": {
+				Type:        framework.TypeBool,
+				Description: "Generates the key in the background and returns the identifier of a job to follow its progress. Only used if generate is true.",
+			},
 		},
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.ReadOperation: &framework.PathOperation{
@@ -97,17 +103,26 @@ func (b *backend) key(ctx context.Context, s logical.Storage, name string) (*key
 	if err := entry.DecodeJSON(&result); err != nil {
 		return nil, err
 	}
+	// Entries not migrated yet are upgraded on the fly
+	if _, err := upgradeKeyEntry(&result); err != nil {
+		return nil, err
+	}
 
 	return &result, nil
 }
 
-func (b *backend) entity(entry *keyEntry) (*openpgp.Entity, error) {
+func (b *backend) entity(name string, entry *keyEntry) (*openpgp.Entity, error) {
+	if entity := b.entityCache.get(name, entry.SerializedKey); entity != nil {
+		return entity, nil
+	}
+
 	r := bytes.NewReader(entry.SerializedKey)
 	el, err := openpgp.ReadKeyRing(r)
 	if err != nil {
 		return nil, err
 	}
 
+	b.entityCache.put(name, entry.SerializedKey, el[0])
 	return el[0], nil
 }
 
@@ -121,6 +136,10 @@ func serializePrivateWithoutSigning(w io.Writer, e *openpgp.Entity) (err error)
 			return
 		}
 	}
+	err = serializePrimaryKeySignatures(w, e)
+	if err != nil {
+		return
+	}
 	for _, ident := range e.Identities {
 		err = ident.UserId.Serialize(w)
 		if err != nil {
@@ -139,6 +158,12 @@ func serializePrivateWithoutSigning(w io.Writer, e *openpgp.Entity) (err error)
 				return
 			}
 		}
+		for _, revocation := range subkey.Revocations {
+			err = revocation.Serialize(w)
+			if err != nil {
+				return
+			}
+		}
 		err = subkey.Sig.Serialize(w)
 		if err != nil {
 			return
@@ -152,15 +177,35 @@ func serializePrivateWithoutSigning(w io.Writer, e *openpgp.Entity) (err error)
 	return nil
 }
 
+// serializePrimaryKeySignatures writes the signatures made directly on the
+// primary key, which follow it in a transferable key: its revocations and the
+// direct-key signatures holding the preferences and the flags of v6 keys.
+// Without them, a revoked key would be stored as valid and a v6 key could not
+// be read back.
+func serializePrimaryKeySignatures(w io.Writer, e *openpgp.Entity) error {
+	for _, revocation := range e.Revocations {
+		if err := revocation.Serialize(w); err != nil {
+			return err
+		}
+	}
+	for _, directSignature := range e.Signatures {
+		if err := directSignature.Serialize(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (b *backend) pathKeyRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	entry, err := b.key(ctx, req.Storage, data.Get("name").(string))
+	name := data.Get("name").(string)
+	entry, err := b.key(ctx, req.Storage, name)
 	if err != nil {
 		return nil, err
 	}
 	if entry == nil {
 		return nil, nil
 	}
-	entity, err := b.entity(entry)
+	entity, err := b.entity(name, entry)
 	if err != nil {
 		return nil, err
 	}
@@ -169,12 +214,19 @@ func (b *backend) pathKeyRead(ctx context.Context, req *logical.Request, data *f
 	if err != nil {
 		return nil, err
 	}
+	usage, err := b.keyUsage(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
 
 	return &logical.Response{
 		Data: map[string]interface{}{
-			"fingerprint": hex.EncodeToString(entity.PrimaryKey.Fingerprint[:]),
-			"public_key":  string(buf),
-			"exportable":  entry.Exportable,
+			"fingerprint":             hex.EncodeToString(entity.PrimaryKey.Fingerprint[:]),
+			"public_key":              string(buf),
+			"exportable":              entry.Exportable,
+			"usage":                   usage.toResponseData(),
+			"allow_prehashed":         entry.AllowPrehashed,
+			"allow_prehashed_certify": entry.AllowPrehashedCertify,
 		},
 	}, nil
 }
@@ -187,6 +239,7 @@ func (b *backend) pathKeyCreate(ctx context.Context, req *logical.Request, data
 	keyBits := data.Get("key_bits").(int)
 	exportable := data.Get("exportable").(bool)
 	generate := data.Get("generate").(bool)
+	async := data.Get("async").(bool)
 	key := data.Get("key").(string)
 
 	lock := locksutil.LockForKey(b.keyLocks, name)
@@ -198,7 +251,7 @@ func (b *backend) pathKeyCreate(ctx context.Context, req *logical.Request, data
 		return logical.ErrorResponse(err.Error()), nil
 	}
 	if resp != nil {
-		return logical.ErrorResponse("key already exists"), nil
+		return logical.ErrorResponse(errKeyAlreadyExists.Error()), nil
 	}
 
 	var buf bytes.Buffer
@@ -207,10 +260,10 @@ func (b *backend) pathKeyCreate(ctx context.Context, req *logical.Request, data
 		if keyBits < 2048 {
 			return logical.ErrorResponse("Keys < 2048 bits are unsafe and not supported"), nil
 		}
-		config := packet.Config{
-			RSABits: keyBits,
+		if async {
+			return b.startKeyGenerationJob(ctx, req, name, realName, comment, email, keyBits, exportable)
 		}
-		entity, err := openpgp.NewEntity(realName, comment, email, &config)
+		entity, err := b.generateEntity(ctx, req.Storage, realName, comment, email, keyBits)
 		if err != nil {
 			return nil, err
 		}
@@ -238,12 +291,81 @@ func (b *backend) pathKeyCreate(ctx context.Context, req *logical.Request, data
 	})
 }
 
+// generateEntity generates a new RSA key, giving up as soon as ctx is done.
+// The primes are taken from the key pool when one is available for the size.
+// Otherwise, their generation stops on the next read of random data once ctx
+// is done.
+func (b *backend) generateEntity(ctx context.Context, storage logical.Storage, realName, comment, email string, keyBits int) (*openpgp.Entity, error) {
+	primes, err := b.takeKeyMaterial(ctx, storage, keyBits)
+	if err != nil {
+		return nil, err
+	}
+
+	config := packet.Config{
+		Rand:      &contextReader{ctx: ctx, r: rand.Reader},
+		RSABits:   keyBits,
+		RSAPrimes: primes,
+	}
+	entity, err := openpgp.NewEntity(realName, comment, email, &config)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	return entity, err
+}
+
+// contextReader reads random data from r until ctx is done, then returns the
+// error of ctx.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+func (b *backend) storeGeneratedKey(ctx context.Context, storage logical.Storage, name string, entity *openpgp.Entity, exportable bool) error {
+	lock := locksutil.LockForKey(b.keyLocks, name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	entry, err := b.key(ctx, storage, name)
+	if err != nil {
+		return err
+	}
+	if entry != nil {
+		return errKeyAlreadyExists
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	err = entity.SerializePrivate(&buf, nil)
+	if err != nil {
+		return err
+	}
+
+	// Once the key is about to be stored, a cancellation must not leave the
+	// storage of the key half done
+	return b.storeKeyEntry(context.WithoutCancel(ctx), storage, name, &keyEntry{
+		SerializedKey: buf.Bytes(),
+		Exportable:    exportable,
+	})
+}
+
 func (b *backend) storeKeyEntry(ctx context.Context, storage logical.Storage, name string, keyEntry *keyEntry) error {
+	keyEntry.Version = latestStorageVersion
 	entry, err := logical.StorageEntryJSON("key/"+name, keyEntry)
 	if err != nil {
 		return err
 	}
-	return storage.Put(ctx, entry)
+	err = storage.Put(ctx, entry)
+	b.entityCache.invalidate(name)
+	return err
 }
 
 func (b *backend) pathKeyDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -254,10 +376,11 @@ func (b *backend) pathKeyDelete(ctx context.Context, req *logical.Request, data
 	defer lock.Unlock()
 
 	err := req.Storage.Delete(ctx, "key/"+name)
+	b.entityCache.invalidate(name)
 	if err != nil {
 		return nil, err
 	}
-	return nil, nil
+	return nil, b.deleteKeyUsage(ctx, req.Storage, name)
 }
 
 func (b *backend) pathKeyList(
@@ -269,9 +392,19 @@ func (b *backend) pathKeyList(
 	return logical.ListResponse(entries), nil
 }
 
+var errKeyAlreadyExists = errors.New("key already exists")
+
 type keyEntry struct {
+	// Version of the storage format of the entry, see keyEntryUpgrades
+	Version       int
 	SerializedKey []byte
 	Exportable    bool
+	// AllowPrehashed allows the key to sign digests computed by the clients,
+	// see pathConfig
+	AllowPrehashed bool
+	// AllowPrehashedCertify also allows the primary key, which certifies the
+	// subkeys and the user IDs, to sign digests computed by the clients
+	AllowPrehashedCertify bool
 }
 
 const pathPolicyHelpSyn = "Managed named GPG keys"
@@ -3,7 +3,10 @@ package gpg
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
@@ -161,3 +164,47 @@ qKqjtePx5gnMoyVXnDqgwsbxPh6GdKGx+Rgt47o1bXm/o8PSpA5Qbb3xVKmAi34b
 ZfOYAeX554UB1xwK6a/T3rHf3eZM4Oc64dsmbhRftQ==
 =G71q
 -----END PGP PUBLIC KEY BLOCK-----`
+
+func TestGPG_StoredKeyKeepsPrimaryKeySignatures(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	revoked, err := openpgp.NewEntity("Revoked", "", "revoked@example.com", &packet.Config{Algorithm: packet.PubKeyAlgoEd25519})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := revoked.RevokeKey(packet.KeyCompromised, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	v6, err := openpgp.NewEntity("V6", "", "v6@example.com", &packet.Config{V6Keys: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, entity := range map[string]*openpgp.Entity{"revoked": revoked, "v6": v6} {
+		testAccStepCreateKey(t, b, storage, name, map[string]interface{}{
+			"generate": false,
+			"key":      testArmoredPrivateKey(t, entity),
+		}, false)
+	}
+	read := func(name string) *openpgp.Entity {
+		entry, err := b.key(context.Background(), storage, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The entity is read from the storage, not from the cache
+		b.entityCache.invalidate(name)
+		entity, err := b.entity(name, entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return entity
+	}
+
+	if entity := read("revoked"); len(entity.Revocations) != 1 || !entity.Revoked(time.Now()) {
+		t.Fatalf("expected the revocation of the primary key to be stored: %#v", entity.Revocations)
+	}
+	if entity := read("v6"); len(entity.Signatures) != len(v6.Signatures) {
+		t.Fatalf("expected the direct-key signatures to be stored: %d", len(entity.Signatures))
+	}
+}
@@ -1,7 +1,6 @@
 package gpg
 
 import (
-	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/hex"
@@ -57,19 +56,19 @@ func (b *backend) pathShowSessionKeyWrite(ctx context.Context, req *logical.Requ
 		return logical.ErrorResponse(fmt.Sprintf("unsupported encoding format %s; must be \"base64\" or \"ascii-armor\"", format)), nil
 	}
 
-	keyEntry, err := b.key(ctx, req.Storage, data.Get("name").(string))
+	name := data.Get("name").(string)
+	keyEntry, err := b.key(ctx, req.Storage, name)
 	if err != nil {
 		return nil, err
 	}
 	if keyEntry == nil {
 		return logical.ErrorResponse("key not found"), logical.ErrInvalidRequest
 	}
-
-	r := bytes.NewReader(keyEntry.SerializedKey)
-	keyring, err := openpgp.ReadKeyRing(r)
+	entity, err := b.entity(name, keyEntry)
 	if err != nil {
 		return nil, err
 	}
+	keyring := openpgp.EntityList{entity}
 
 	signerKey := data.Get("signer_key").(string)
 	if signerKey != "" {
@@ -112,6 +111,7 @@ func (b *backend) pathShowSessionKeyWrite(ctx context.Context, req *logical.Requ
 
 				if len(encryptedKey.Key) > 0 {
 					sessionKey = fmt.Sprintf("%d:%s", encryptedKey.CipherFunc, strings.ToUpper(hex.EncodeToString(encryptedKey.Key)))
+					b.usage.record(name, usageShowSessionKey)
 					return &logical.Response{
 						Data: map[string]interface{}{
 							"session_key": sessionKey,
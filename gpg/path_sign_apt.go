@@ -0,0 +1,163 @@
+package gpg
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// aptMinRSABits is the minimum size of the RSA keys accepted by apt.
+const aptMinRSABits = 2048
+
+func pathSignAptRelease(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "sign/" + framework.GenericNameRegex("name") + "/apt-release",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "The key to use",
+			},
+			"input": {
+				Type:        framework.TypeString,
+				Description: "The base64-encoded content of the Release file",
+			},
+			"algorithm": {
+				Type:        framework.TypeString,
+				Default:     "sha2-256",
+				Description: `Hash algorithm of the signatures. Defaults to "sha2-256".`,
+			},
+			"creation_time": {
+				Type:        framework.TypeTime,
+				Description: "Creation time of the signatures. Defaults to the current time.",
+			},
+			"signing_key_id": {
+				Type:        framework.TypeString,
+				Description: "Key ID of the subkey to sign with. Defaults to the newest valid signing subkey.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathSignAptReleaseWrite,
+			},
+		},
+		HelpSynopsis:    pathSignAptReleaseHelpSyn,
+		HelpDescription: pathSignAptReleaseHelpDesc,
+	}
+}
+
+func (b *backend) pathSignAptReleaseWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	release, err := base64.StdEncoding.DecodeString(data.Get("input").(string))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("unable to decode input as base64: %s", err)), logical.ErrInvalidRequest
+	}
+	if len(release) == 0 {
+		return logical.ErrorResponse("the content of the Release file is required"), logical.ErrInvalidRequest
+	}
+
+	algorithm := data.Get("algorithm").(string)
+	hashFunc, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return logical.ErrorResponse(fmt.Sprintf("unsupported algorithm %s", algorithm)), nil
+	}
+
+	config := packet.Config{DefaultHash: hashFunc}
+	config.SigningKeyId, err = parseSigningKeyID(data.Get("signing_key_id").(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	// Release.gpg and InRelease must have the same creation time
+	creationTime := time.Now()
+	if t, ok := data.GetOk("creation_time"); ok {
+		creationTime = t.(time.Time)
+	}
+	config.Time = func() time.Time {
+		return creationTime
+	}
+
+	name := data.Get("name").(string)
+	entry, err := b.key(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse("key not found"), logical.ErrInvalidRequest
+	}
+	entity, err := b.entity(name, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	signingKey, err := entitySigningKey(entity, config.Now(), config.SigningKeyId)
+	if errors.Is(err, errInvalidSigningKey) {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkHashForKey(signingKey, hashFunc); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := checkAptSigningKey(&signingKey.PublicKey); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	inRelease, err := signCleartext(entity, release, &config)
+	if errors.Is(err, errUnsupportedHash) {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	signature, err := signDetached(entity, release, packet.SigTypeBinary, &signatureOptions{}, &config)
+	if err != nil {
+		return nil, err
+	}
+	releaseGPG, err := encodeOutput(signature, openpgp.SignatureType, "ascii-armor")
+	if err != nil {
+		return nil, err
+	}
+	b.usage.record(name, usageSign)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"release_gpg":    releaseGPG,
+			"in_release":     string(inRelease),
+			"hash_algorithm": hashAlgorithmName(hashFunc),
+			"creation_time":  creationTime.Truncate(time.Second).UTC(),
+		},
+	}, nil
+}
+
+// checkAptSigningKey returns an error when apt does not accept the signatures
+// of the signing key. DSA keys and RSA keys smaller than 2048 bits are
+// rejected.
+func checkAptSigningKey(signingKey *packet.PublicKey) error {
+	switch signingKey.PubKeyAlgo {
+	case packet.PubKeyAlgoDSA:
+		return errors.New("DSA keys are not accepted by apt")
+	case packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSASignOnly:
+		bits, err := signingKey.BitLength()
+		if err != nil {
+			return err
+		}
+		if bits < aptMinRSABits {
+			return fmt.Errorf("RSA keys of %d bits are not accepted by apt, at least %d bits are required", bits, aptMinRSABits)
+		}
+	}
+	return nil
+}
+
+const pathSignAptReleaseHelpSyn = "Sign the Release file of an APT repository using the named GPG key"
+const pathSignAptReleaseHelpDesc = `
+This path returns the Release.gpg detached signature and the InRelease
+cleartext signed document of the Release file of an APT repository. Both
+signatures have the same creation time. The signing key must be accepted by
+apt: DSA keys and RSA keys smaller than 2048 bits are rejected.
+`
@@ -0,0 +1,109 @@
+package gpg
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestGPG_SignAptRelease(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"generate": false,
+		"key":      gpgKey,
+	}, false)
+	weak, err := openpgp.NewEntity("Vault GPG test", "", "vault@example.com", &packet.Config{
+		Algorithm: packet.PubKeyAlgoRSA,
+		RSABits:   1024,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAccStepCreateKey(t, b, storage, "weak", map[string]interface{}{
+		"generate": false,
+		"key":      testArmoredPrivateKey(t, weak),
+	}, false)
+
+	request := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	release := "Origin: Vault\nLabel: Vault\nSuite: stable\nCodename: bookworm\nArchitectures: amd64 arm64\nComponents: main\n" +
+		"SHA256:\n 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08 1234 main/binary-amd64/Packages\n"
+	input := base64.StdEncoding.EncodeToString([]byte(release))
+	resp := request("sign/test/apt-release", map[string]interface{}{
+		"input":     input,
+		"algorithm": "sha2-512",
+	})
+	if resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+	releaseGPG := resp.Data["release_gpg"].(string)
+	inRelease := resp.Data["in_release"].(string)
+	if !strings.HasPrefix(releaseGPG, "-----BEGIN PGP SIGNATURE-----") || !strings.HasPrefix(inRelease, "-----BEGIN PGP SIGNED MESSAGE-----") {
+		t.Fatalf("expected armored signatures: %#v", resp.Data)
+	}
+	if resp.Data["hash_algorithm"] != "sha2-512" {
+		t.Fatalf("unexpected hash algorithm: %#v", resp.Data)
+	}
+
+	detached := request("verify/test", map[string]interface{}{
+		"input":     input,
+		"signature": releaseGPG,
+		"format":    "ascii-armor",
+	})
+	cleartext := request("verify/test", map[string]interface{}{
+		"signature":      inRelease,
+		"signature_type": "cleartext",
+		"format":         "ascii-armor",
+	})
+	if detached.Data["valid"] != true || cleartext.Data["valid"] != true {
+		t.Fatalf("expected valid signatures: %#v %#v", detached.Data, cleartext.Data)
+	}
+	if cleartext.Data["plaintext"] != input {
+		t.Fatalf("unexpected content of InRelease: %#v", cleartext.Data)
+	}
+	if detached.Data["creation_time"] != cleartext.Data["creation_time"] || detached.Data["creation_time"] != resp.Data["creation_time"] {
+		t.Fatalf("expected the signatures to share the creation time: %#v %#v", detached.Data, cleartext.Data)
+	}
+
+	resp = request("sign/weak/apt-release", map[string]interface{}{
+		"input": input,
+	})
+	if !resp.IsError() || !strings.Contains(resp.Error().Error(), "1024 bits") {
+		t.Fatalf("expected the RSA key to be rejected: %#v", resp)
+	}
+
+	for path, data := range map[string]map[string]interface{}{
+		"sign/test/apt-release":    {"input": ""},
+		"sign/missing/apt-release": {"input": input},
+	} {
+		resp = request(path, data)
+		if !resp.IsError() {
+			t.Fatalf("expected an error for %s with %#v: %#v", path, data, resp)
+		}
+	}
+	resp = request("sign/test/apt-release", map[string]interface{}{
+		"input":     input,
+		"algorithm": "sha1",
+	})
+	if !resp.IsError() {
+		t.Fatalf("expected SHA-1 to be rejected: %#v", resp)
+	}
+}
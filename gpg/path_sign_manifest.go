@@ -0,0 +1,233 @@
+package gpg
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// manifestDigestSizes are the sizes of the digests of the checksum files, by
+// name of the digest algorithm.
+var manifestDigestSizes = map[string]int{
+	"sha256": 32,
+	"sha512": 64,
+}
+
+func pathSignManifest(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "sign/" + framework.GenericNameRegex("name") + "/manifest",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "The key to use",
+			},
+			"files": {
+				Type:        framework.TypeKVPairs,
+				Description: "Hex-encoded digests of the files to list in the checksum file, by file name.",
+			},
+			"digest_algorithm": {
+				Type:        framework.TypeString,
+				Default:     "sha256",
+				Description: `Algorithm of the digests of the files. Can be "sha256" or "sha512". Defaults to "sha256".`,
+			},
+			"signature_types": {
+				Type:    framework.TypeCommaStringSlice,
+				Default: []string{"cleartext"},
+				Description: `Types of signature of the checksum file to return. Valid values are:
+
+* cleartext: the checksum file is returned as a cleartext signed document
+* detached: a detached signature of the checksum file is returned
+
+Defaults to "cleartext".`,
+			},
+			"algorithm": {
+				Type:        framework.TypeString,
+				Default:     "sha2-256",
+				Description: `Hash algorithm of the signatures. Defaults to "sha2-256".`,
+			},
+			"format": {
+				Type:        framework.TypeString,
+				Default:     "base64",
+				Description: `Encoding format of the signatures. Can be "base64" or "ascii-armor". Defaults to "base64".`,
+			},
+			"creation_time": {
+				Type:        framework.TypeTime,
+				Description: "Creation time of the signatures. Defaults to the current time.",
+			},
+			"signing_key_id": {
+				Type:        framework.TypeString,
+				Description: "Key ID of the subkey to sign with. Defaults to the newest valid signing subkey.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathSignManifestWrite,
+			},
+		},
+		HelpSynopsis:    pathSignManifestHelpSyn,
+		HelpDescription: pathSignManifestHelpDesc,
+	}
+}
+
+func (b *backend) pathSignManifestWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	manifest, err := renderManifest(data.Get("files").(map[string]string), data.Get("digest_algorithm").(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	var cleartext, detached bool
+	for _, signatureType := range data.Get("signature_types").([]string) {
+		switch signatureType {
+		case "cleartext":
+			cleartext = true
+		case "detached":
+			detached = true
+		default:
+			return logical.ErrorResponse(fmt.Sprintf("unsupported signature type %s; must be \"cleartext\" or \"detached\"", signatureType)), nil
+		}
+	}
+	if !cleartext && !detached {
+		return logical.ErrorResponse("at least one signature type is required"), nil
+	}
+
+	algorithm := data.Get("algorithm").(string)
+	hashFunc, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return logical.ErrorResponse(fmt.Sprintf("unsupported algorithm %s", algorithm)), nil
+	}
+
+	format := data.Get("format").(string)
+	switch format {
+	case "base64":
+	case "ascii-armor":
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("unsupported encoding format %s; must be \"base64\" or \"ascii-armor\"", format)), nil
+	}
+
+	config := packet.Config{DefaultHash: hashFunc}
+	config.SigningKeyId, err = parseSigningKeyID(data.Get("signing_key_id").(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	// Both signatures share the same creation time
+	creationTime := time.Now()
+	if t, ok := data.GetOk("creation_time"); ok {
+		creationTime = t.(time.Time)
+	}
+	config.Time = func() time.Time {
+		return creationTime
+	}
+
+	name := data.Get("name").(string)
+	entry, err := b.key(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse("key not found"), logical.ErrInvalidRequest
+	}
+	entity, err := b.entity(name, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	signingKey, err := entitySigningKey(entity, config.Now(), config.SigningKeyId)
+	if errors.Is(err, errInvalidSigningKey) {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkHashForKey(signingKey, hashFunc); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"manifest": string(manifest),
+		},
+	}
+	if cleartext {
+		document, err := signCleartext(entity, manifest, &config)
+		if errors.Is(err, errUnsupportedHash) {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// The cleartext signed document is already armored
+		output := string(document)
+		if format == "base64" {
+			output = base64.StdEncoding.EncodeToString(document)
+		}
+		resp.Data["cleartext"] = output
+	}
+	if detached {
+		signature, err := signDetached(entity, manifest, packet.SigTypeBinary, &signatureOptions{}, &config)
+		if err != nil {
+			return nil, err
+		}
+		resp.Data["signature"], err = encodeOutput(signature, openpgp.SignatureType, format)
+		if err != nil {
+			return nil, err
+		}
+	}
+	b.usage.record(name, usageSign)
+
+	return resp, nil
+}
+
+// renderManifest returns the checksum file listing the digests of the files,
+// in the format of the coreutils sha256sum and sha512sum commands. The files
+// are sorted by name so the same files always give the same checksum file.
+func renderManifest(files map[string]string, digestAlgorithm string) ([]byte, error) {
+	size, ok := manifestDigestSizes[digestAlgorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported digest algorithm %s; must be \"sha256\" or \"sha512\"", digestAlgorithm)
+	}
+	if len(files) == 0 {
+		return nil, errors.New("at least one file is required")
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var manifest strings.Builder
+	for _, name := range names {
+		if name == "" {
+			return nil, errors.New("the file names must not be empty")
+		}
+		digest, err := hex.DecodeString(files[name])
+		if err != nil || len(digest) != size {
+			return nil, fmt.Errorf("invalid digest of %s; must be a hex-encoded %s digest", name, digestAlgorithm)
+		}
+		// coreutils escapes the file names with a backslash, a newline or a
+		// carriage return and marks the escaped lines with a leading backslash
+		if strings.ContainsAny(name, "\\\n\r") {
+			name = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r").Replace(name)
+			manifest.WriteString("\\")
+		}
+		fmt.Fprintf(&manifest, "%s  %s\n", hex.EncodeToString(digest), name)
+	}
+	return []byte(manifest.String()), nil
+}
+
+const pathSignManifestHelpSyn = "Generate a signed checksum file using the named GPG key"
+const pathSignManifestHelpDesc = `
+This path renders a checksum file, in the format of the sha256sum and
+sha512sum commands, listing the given digests of the files sorted by name. The
+checksum file is returned cleartext signed and/or with a detached signature.
+`
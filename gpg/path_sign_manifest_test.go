@@ -0,0 +1,106 @@
+package gpg
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestGPG_SignManifest(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"generate": false,
+		"key":      gpgKey,
+	}, false)
+
+	request := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	sha256Hex := func(data string) string {
+		digest := sha256.Sum256([]byte(data))
+		return hex.EncodeToString(digest[:])
+	}
+	files := map[string]interface{}{
+		"vault-gpg-plugin_linux_amd64.zip":  sha256Hex("amd64"),
+		"vault-gpg-plugin_darwin_arm64.zip": strings.ToUpper(sha256Hex("arm64")),
+		"weird\\name\n.zip":                 sha256Hex("weird"),
+	}
+	resp := request("sign/test/manifest", map[string]interface{}{
+		"files":           files,
+		"signature_types": "cleartext,detached",
+		"format":          "ascii-armor",
+	})
+	if resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+	manifest := resp.Data["manifest"].(string)
+	expected := sha256Hex("arm64") + "  vault-gpg-plugin_darwin_arm64.zip\n" +
+		sha256Hex("amd64") + "  vault-gpg-plugin_linux_amd64.zip\n" +
+		"\\" + sha256Hex("weird") + "  weird\\\\name\\n.zip\n"
+	if manifest != expected {
+		t.Fatalf("unexpected manifest:\n%s\nexpected:\n%s", manifest, expected)
+	}
+
+	verify := request("verify/test", map[string]interface{}{
+		"signature":      resp.Data["cleartext"],
+		"signature_type": "cleartext",
+		"format":         "ascii-armor",
+	})
+	if verify.Data["valid"] != true || verify.Data["plaintext"] != base64.StdEncoding.EncodeToString([]byte(manifest)) {
+		t.Fatalf("expected a valid cleartext signed manifest: %#v", verify.Data)
+	}
+	verify = request("verify/test", map[string]interface{}{
+		"input":     base64.StdEncoding.EncodeToString([]byte(manifest)),
+		"signature": resp.Data["signature"],
+		"format":    "ascii-armor",
+	})
+	if verify.Data["valid"] != true {
+		t.Fatalf("expected a valid detached signature of the manifest: %#v", verify.Data)
+	}
+
+	// Only the cleartext signed document is returned by default
+	sha512Digest := sha512.Sum512([]byte("amd64"))
+	resp = request("sign/test/manifest", map[string]interface{}{
+		"files":            map[string]interface{}{"plugin.zip": hex.EncodeToString(sha512Digest[:])},
+		"digest_algorithm": "sha512",
+	})
+	if resp.IsError() || resp.Data["cleartext"] == nil || resp.Data["signature"] != nil {
+		t.Fatalf("expected a cleartext signed manifest: %#v", resp)
+	}
+	if resp.Data["manifest"] != hex.EncodeToString(sha512Digest[:])+"  plugin.zip\n" {
+		t.Fatalf("unexpected manifest: %#v", resp.Data)
+	}
+
+	for _, data := range []map[string]interface{}{
+		{},
+		{"files": map[string]interface{}{"plugin.zip": sha256Hex("amd64")}, "digest_algorithm": "sha512"},
+		{"files": map[string]interface{}{"plugin.zip": "not hex"}},
+		{"files": map[string]interface{}{"": sha256Hex("amd64")}},
+		{"files": map[string]interface{}{"plugin.zip": sha256Hex("amd64")}, "digest_algorithm": "md5"},
+		{"files": map[string]interface{}{"plugin.zip": sha256Hex("amd64")}, "signature_types": "inline"},
+		{"files": map[string]interface{}{"plugin.zip": sha256Hex("amd64")}, "algorithm": "sha1"},
+	} {
+		resp = request("sign/test/manifest", data)
+		if !resp.IsError() {
+			t.Fatalf("expected an error with %#v: %#v", data, resp)
+		}
+	}
+}
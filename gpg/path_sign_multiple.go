@@ -0,0 +1,225 @@
+package gpg
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// keyNameRegex matches the name of a key, as framework.GenericNameRegex
+// without the capture group.
+const keyNameRegex = `\w(([\w-.]+)?\w)?`
+
+func pathSignMultiple(b *backend) *framework.Path {
+	path := &framework.Path{
+		// The names of the keys are part of the path so the policies granting
+		// access to the path grant access to the set of keys
+		Pattern: "sign/(?P<keys>" + keyNameRegex + "(," + keyNameRegex + ")+)",
+		Fields: map[string]*framework.FieldSchema{
+			"keys": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma-separated names of the keys to sign with. The signature contains one signature packet per key, in the same order.",
+			},
+			"input": {
+				Type:        framework.TypeString,
+				Description: "The base64-encoded input data",
+			},
+			"algorithms": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Hash algorithm of the signature of each key, in the same order as the keys. A single algorithm is used for all the keys. Defaults to "sha2-256".`,
+			},
+			"signing_key_ids": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Key ID of the subkey signing with each key, in the same order as the keys. Defaults to the newest valid signing subkey of each key.",
+			},
+			"format": {
+				Type:        framework.TypeString,
+				Default:     "base64",
+				Description: `Encoding format to use. Can be "base64" or "ascii-armor". Defaults to "base64".`,
+			},
+			"mode": {
+				Type:        framework.TypeString,
+				Default:     "binary",
+				Description: `Signature mode. Can be "binary" or "text". Defaults to "binary".`,
+			},
+			"creation_time": {
+				Type:        framework.TypeTime,
+				Description: "Creation time of the signatures. Defaults to the current time.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathSignMultipleWrite,
+			},
+		},
+		HelpSynopsis:    pathSignMultipleHelpSyn,
+		HelpDescription: pathSignMultipleHelpDesc,
+	}
+	for name, schema := range signatureOptionsFields {
+		path.Fields[name] = schema
+	}
+	return path
+}
+
+func (b *backend) pathSignMultipleWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	names := data.Get("keys").([]string)
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			return logical.ErrorResponse(fmt.Sprintf("the key %s is listed more than once", name)), logical.ErrInvalidRequest
+		}
+		seen[name] = true
+	}
+
+	input, err := base64.StdEncoding.DecodeString(data.Get("input").(string))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("unable to decode input as base64: %s", err)), logical.ErrInvalidRequest
+	}
+
+	algorithms := data.Get("algorithms").([]string)
+	switch len(algorithms) {
+	case 0:
+		algorithms = []string{"sha2-256"}
+		fallthrough
+	case 1:
+		for len(algorithms) < len(names) {
+			algorithms = append(algorithms, algorithms[0])
+		}
+	case len(names):
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("%d algorithms given for %d keys; provide one algorithm or one per key", len(algorithms), len(names))), logical.ErrInvalidRequest
+	}
+	hashFuncs := make([]crypto.Hash, len(names))
+	for i, algorithm := range algorithms {
+		hashFunc, ok := hashAlgorithms[algorithm]
+		if !ok {
+			return logical.ErrorResponse(fmt.Sprintf("unsupported algorithm %s", algorithm)), nil
+		}
+		hashFuncs[i] = hashFunc
+	}
+
+	signingKeyIDs := make([]uint64, len(names))
+	switch rawKeyIDs := data.Get("signing_key_ids").([]string); len(rawKeyIDs) {
+	case 0:
+	case len(names):
+		for i, rawKeyID := range rawKeyIDs {
+			signingKeyIDs[i], err = parseSigningKeyID(rawKeyID)
+			if err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("%d signing key IDs given for %d keys; provide one per key", len(rawKeyIDs), len(names))), logical.ErrInvalidRequest
+	}
+
+	format := data.Get("format").(string)
+	switch format {
+	case "base64":
+	case "ascii-armor":
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("unsupported encoding format %s; must be \"base64\" or \"ascii-armor\"", format)), nil
+	}
+
+	var sigType packet.SignatureType
+	switch data.Get("mode").(string) {
+	case "binary":
+		sigType = packet.SigTypeBinary
+	case "text":
+		sigType = packet.SigTypeText
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("unsupported mode %s; must be \"binary\" or \"text\"", data.Get("mode").(string))), nil
+	}
+
+	options, err := parseSignatureOptions(data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	// All the signatures share the same creation time
+	creationTime := time.Now()
+	if t, ok := data.GetOk("creation_time"); ok {
+		creationTime = t.(time.Time)
+	}
+
+	// Every key is checked before signing so that no signature is made when
+	// one of the keys cannot be used
+	entities := make([]*openpgp.Entity, len(names))
+	configs := make([]*packet.Config, len(names))
+	for i, name := range names {
+		entry, err := b.key(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			return logical.ErrorResponse(fmt.Sprintf("key %s not found", name)), logical.ErrInvalidRequest
+		}
+		entity, err := b.entity(name, entry)
+		if err != nil {
+			return nil, err
+		}
+		if err := options.validateFor(entity, "detached"); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("key %s: %s", name, err)), nil
+		}
+		config := &packet.Config{DefaultHash: hashFuncs[i], SigningKeyId: signingKeyIDs[i]}
+		options.applyTo(config, creationTime)
+		signingKey, err := entitySigningKey(entity, config.Now(), config.SigningKeyId)
+		if errors.Is(err, errInvalidSigningKey) {
+			return logical.ErrorResponse(fmt.Sprintf("key %s: %s", name, err)), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := checkHashForKey(signingKey, hashFuncs[i]); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("key %s: %s", name, err)), nil
+		}
+		entities[i] = entity
+		configs[i] = config
+	}
+
+	var signatures []byte
+	hashNames := make([]string, len(names))
+	for i, entity := range entities {
+		signature, err := signDetached(entity, input, sigType, options, configs[i])
+		if err != nil {
+			return nil, err
+		}
+		signatures = append(signatures, signature...)
+		hashNames[i] = hashAlgorithmName(hashFuncs[i])
+	}
+	output, err := encodeOutput(signatures, openpgp.SignatureType, format)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		b.usage.record(name, usageSign)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"signature":       output,
+			"hash_algorithms": hashNames,
+		},
+	}, nil
+}
+
+const pathSignMultipleHelpSyn = "Generate a detached signature for input data using several GPG keys"
+const pathSignMultipleHelpDesc = `
+This path generates a detached signature of the input data containing one
+signature packet per named key, e.g. for a release signed jointly by the
+project key and the release key:
+
+    sign/project,release-1.2  input=... algorithms=sha2-512,sha2-384
+
+The signature is only generated when every key can sign the data.
+
+The names of the keys are part of the path, the policies granting access to
+the path grant access to this set of keys. A policy granting access to
+sign/project does not allow to sign with project,release-1.2.
+`
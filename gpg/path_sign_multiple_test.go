@@ -0,0 +1,202 @@
+package gpg
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestGPG_SignMultiple(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	p384, err := openpgp.NewEntity("Vault GPG test", "", "vault@example.com", &packet.Config{
+		Algorithm: packet.PubKeyAlgoECDSA,
+		Curve:     packet.CurveNistP384,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	project, err := openpgp.ReadArmoredKeyRing(strings.NewReader(gpgKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAccStepCreateKey(t, b, storage, "project", map[string]interface{}{
+		"generate": false,
+		"key":      gpgKey,
+	}, false)
+	testAccStepCreateKey(t, b, storage, "release", map[string]interface{}{
+		"generate": false,
+		"key":      testArmoredPrivateKey(t, p384),
+	}, false)
+
+	request := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	input := base64.StdEncoding.EncodeToString([]byte("the quick brown fox"))
+	resp := request("sign/project,release", map[string]interface{}{
+		"algorithms": "sha2-512,sha2-384",
+		"input":      input,
+		"mode":       "text",
+		"format":     "ascii-armor",
+	})
+	if resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+	hashNames := resp.Data["hash_algorithms"].([]string)
+	if len(hashNames) != 2 || hashNames[0] != "sha2-512" || hashNames[1] != "sha2-384" {
+		t.Fatalf("unexpected hash algorithms: %#v", resp.Data)
+	}
+
+	resp = request("verify", map[string]interface{}{
+		"input":          input,
+		"signature":      resp.Data["signature"],
+		"format":         "ascii-armor",
+		"all_signatures": true,
+	})
+	signatures := resp.Data["signatures"].([]map[string]interface{})
+	if resp.Data["valid"] != true || resp.Data["valid_signers"] != 2 || len(signatures) != 2 {
+		t.Fatalf("expected two valid signatures: %#v", resp.Data)
+	}
+	for i, name := range []string{"project", "release"} {
+		if signatures[i]["key_name"] != name || signatures[i]["hash_algorithm"] != hashNames[i] || signatures[i]["mode"] != "text" {
+			t.Fatalf("unexpected signature of %s: %#v", name, signatures[i])
+		}
+	}
+	if signatures[0]["creation_time"] != signatures[1]["creation_time"] {
+		t.Fatalf("expected the signatures to share the creation time: %#v", signatures)
+	}
+	for _, name := range []string{"project", "release"} {
+		if b.usage.get(name).Counts[usageSign] != 1 {
+			t.Fatalf("expected the signature of %s to be recorded: %#v", name, b.usage.get(name))
+		}
+	}
+
+	// The signing key of each key can be pinned
+	resp = request("sign/release,project", map[string]interface{}{
+		"input":           input,
+		"algorithms":      "sha2-384",
+		"signing_key_ids": fmt.Sprintf("%016X,%016X", p384.PrimaryKey.KeyId, project[0].PrimaryKey.KeyId),
+	})
+	if resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+	resp = request("verify", map[string]interface{}{
+		"input":          input,
+		"signature":      resp.Data["signature"],
+		"all_signatures": true,
+	})
+	if resp.Data["valid"] != true || resp.Data["valid_signers"] != 2 {
+		t.Fatalf("expected two valid signatures: %#v", resp.Data)
+	}
+
+	for path, data := range map[string]map[string]interface{}{
+		"sign/project,project":          {"input": input},
+		"sign/project,missing":          {"input": input},
+		"sign/project,release":          {"input": "not base64"},
+		"sign/project,release,":         {"input": input},
+		"sign/release,project":          {"input": input, "algorithms": "sha2-512,sha2-384,sha2-256"},
+		"sign/release,project/sha2-256": {"input": input},
+		"sign/project,release/":         {"input": input},
+		"sign/release,project,x":        {"input": input},
+		"sign/project,release,release":  {"input": input},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		})
+		if err == nil && !resp.IsError() {
+			t.Fatalf("expected an error for %s with %#v: %#v", path, data, resp)
+		}
+	}
+	for _, data := range []map[string]interface{}{
+		{"input": input, "algorithms": "sha2-256"},
+		{"input": input, "algorithms": "md5"},
+		{"input": input, "signing_key_ids": "0000000000000001,0000000000000002"},
+		{"input": input, "signing_key_ids": fmt.Sprintf("%016X", p384.PrimaryKey.KeyId)},
+	} {
+		resp = request("sign/project,release", data)
+		if !resp.IsError() {
+			t.Fatalf("expected an error with %#v: %#v", data, resp)
+		}
+	}
+	if b.usage.get("project").Counts[usageSign] != 2 {
+		t.Fatalf("no signature must be made when a key cannot be used: %#v", b.usage.get("project"))
+	}
+}
+
+func TestGPG_SignMultiplePolicyOfTheKeys(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	testAccStepCreateKey(t, b, storage, "project", map[string]interface{}{
+		"generate": false,
+		"key":      gpgKey,
+	}, false)
+	release, err := openpgp.NewEntity("Vault GPG test", "", "vault@example.com", &packet.Config{
+		Algorithm: packet.PubKeyAlgoEd25519,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAccStepCreateKey(t, b, storage, "release", map[string]interface{}{
+		"generate": false,
+		"key":      testArmoredPrivateKey(t, release),
+	}, false)
+
+	// Vault evaluates the policies on the path of the request: a token only
+	// allowed to sign with the project key can update the paths under
+	// gpg/sign/project and nothing else
+	request := func(allowed func(string) bool, path string) (*logical.Response, error) {
+		if !allowed(path) {
+			return nil, logical.ErrPermissionDenied
+		}
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data: map[string]interface{}{
+				"input": base64.StdEncoding.EncodeToString([]byte("the quick brown fox")),
+			},
+		})
+	}
+	projectOnly := func(path string) bool {
+		return path == "sign/project" || strings.HasPrefix(path, "sign/project/")
+	}
+	if resp, err := request(projectOnly, "sign/project"); err != nil || resp.IsError() {
+		t.Fatalf("expected the token to sign with the project key: %#v %v", resp, err)
+	}
+	if _, err := request(projectOnly, "sign/project,release"); !errors.Is(err, logical.ErrPermissionDenied) {
+		t.Fatalf("expected the token not to sign with the release key: %v", err)
+	}
+	if b.usage.get("release").Counts[usageSign] != 0 {
+		t.Fatalf("expected no signature of the release key: %#v", b.usage.get("release"))
+	}
+
+	// The set of keys must be granted
+	projectAndRelease := func(path string) bool {
+		return path == "sign/project,release"
+	}
+	if resp, err := request(projectAndRelease, "sign/project,release"); err != nil || resp.IsError() {
+		t.Fatalf("expected the token to sign with both keys: %#v %v", resp, err)
+	}
+}
@@ -0,0 +1,288 @@
+package gpg
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// errPrehashedKeyVersion is returned for keys whose signatures cannot be
+// computed from a digest provided by the client. v5 and v6 signatures hash
+// additional data, such as a salt, before the data itself.
+var errPrehashedKeyVersion = errors.New("prehashed inputs are only supported for v4 signing keys")
+
+// errPrehashedNotAllowed is returned when the key is not configured to sign
+// prehashed inputs, see pathConfig.
+var errPrehashedNotAllowed = errors.New("prehashed inputs are not allowed for this key")
+
+func pathSignTrailer(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "sign/" + framework.GenericNameRegex("name") + "/trailer",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "The key to use",
+			},
+			"algorithm": {
+				Type:        framework.TypeString,
+				Default:     "sha2-256",
+				Description: `Hash algorithm that will be used to sign the data. Defaults to "sha2-256".`,
+			},
+			"creation_time": {
+				Type:        framework.TypeTime,
+				Description: "Creation time of the signature. Defaults to the current time.",
+			},
+			"signing_key_id": {
+				Type:        framework.TypeString,
+				Description: "Key ID of the subkey that will be used to sign the data. Defaults to the newest valid signing subkey.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathSignTrailerWrite,
+			},
+		},
+		HelpSynopsis:    pathSignTrailerHelpSyn,
+		HelpDescription: pathSignTrailerHelpDesc,
+	}
+}
+
+func (b *backend) pathSignTrailerWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	algorithm := data.Get("algorithm").(string)
+	hashFunc, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return logical.ErrorResponse(fmt.Sprintf("unsupported algorithm %s", algorithm)), nil
+	}
+
+	creationTime := time.Now()
+	if t, ok := data.GetOk("creation_time"); ok {
+		creationTime = t.(time.Time)
+	}
+	// Signatures only store the creation time with a precision of one second
+	creationTime = creationTime.Truncate(time.Second)
+
+	keyID, err := parseSigningKeyID(data.Get("signing_key_id").(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	name := data.Get("name").(string)
+	entry, err := b.key(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse("key not found"), logical.ErrInvalidRequest
+	}
+	if !entry.AllowPrehashed {
+		return logical.ErrorResponse(errPrehashedNotAllowed.Error()), nil
+	}
+	entity, err := b.entity(name, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	// The trailer is only known once the signature is built, a throwaway
+	// signature of an empty digest is generated to get it.
+	sig, err := prehashedSignature(entity, keyID, entry.AllowPrehashedCertify, hashFunc, creationTime, make([]byte, hashFunc.Size()))
+	if errors.Is(err, errPrehashedKeyVersion) || errors.Is(err, errPrehashedNotAllowed) || errors.Is(err, errUnsupportedHash) || errors.Is(err, errInvalidSigningKey) {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"hash_trailer":  base64.StdEncoding.EncodeToString(sig.HashSuffix),
+			"creation_time": creationTime.UTC(),
+			"algorithm":     algorithm,
+		},
+	}, nil
+}
+
+// signPrehashed returns a detached signature of the data whose digest, data
+// followed by the hash trailer, is provided.
+func signPrehashed(entity *openpgp.Entity, keyID uint64, allowCertify bool, hashFunc crypto.Hash, creationTime time.Time, digest []byte) ([]byte, error) {
+	sig, err := prehashedSignature(entity, keyID, allowCertify, hashFunc, creationTime, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	var signature bytes.Buffer
+	if err := sig.Serialize(&signature); err != nil {
+		return nil, err
+	}
+	return signature.Bytes(), nil
+}
+
+// prehashedSignature builds a binary signature with deterministic subpackets
+// so the hash trailer only depends on the key, the hash algorithm and the
+// creation time. The primary key is only used when allowCertify is set: the
+// digest could be the one of a certification it would then sign.
+func prehashedSignature(entity *openpgp.Entity, keyID uint64, allowCertify bool, hashFunc crypto.Hash, creationTime time.Time, digest []byte) (*packet.Signature, error) {
+	signingKey, err := entitySigningKey(entity, creationTime, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if signingKey.KeyId == entity.PrimaryKey.KeyId && !allowCertify {
+		return nil, fmt.Errorf("%w: the primary key %016X certifies the key, use a signing subkey", errPrehashedNotAllowed, signingKey.KeyId)
+	}
+	if signingKey.Version != 4 {
+		return nil, errPrehashedKeyVersion
+	}
+	if err := checkHashForKey(signingKey, hashFunc); err != nil {
+		return nil, err
+	}
+
+	sig := newDetachedSignature(signingKey, hashFunc, creationTime)
+	if err := sig.Sign(&prehashedDigest{digest: digest, hashFunc: hashFunc}, signingKey, deterministicSignatureConfig()); err != nil {
+		return nil, err
+	}
+
+	return sig, nil
+}
+
+// entitySigningKey returns the key of the entity used to sign at the given
+// time. The newest valid signing subkey is used unless the key ID of the
+// signing key is set.
+func entitySigningKey(entity *openpgp.Entity, now time.Time, keyID uint64) (*packet.PrivateKey, error) {
+	if keyID != 0 {
+		if err := checkSigningKeyID(entity, now, keyID); err != nil {
+			return nil, err
+		}
+	}
+	signingKey, ok := entity.SigningKeyById(now, keyID)
+	if !ok {
+		return nil, fmt.Errorf("%w: no key can sign at %s", errInvalidSigningKey, now.UTC().Format(time.RFC3339))
+	}
+	return signingKey.PrivateKey, nil
+}
+
+// errInvalidSigningKey is returned when the requested signing key cannot be
+// used to sign.
+var errInvalidSigningKey = errors.New("invalid signing key")
+
+// checkSigningKeyID returns an error explaining why the key of the entity with
+// the key ID cannot be used to sign at the given time.
+func checkSigningKeyID(entity *openpgp.Entity, now time.Time, keyID uint64) error {
+	selfSignature, _ := entity.PrimarySelfSignature()
+	switch {
+	case selfSignature == nil:
+		return fmt.Errorf("%w: the key has no valid self-signature", errInvalidSigningKey)
+	case entity.Revoked(now):
+		return fmt.Errorf("%w: the primary key is revoked", errInvalidSigningKey)
+	case entity.PrimaryKey.KeyExpired(selfSignature, now) || selfSignature.SigExpired(now):
+		return fmt.Errorf("%w: the primary key is expired", errInvalidSigningKey)
+	}
+
+	if entity.PrimaryKey.KeyId == keyID {
+		if !selfSignature.FlagsValid || !selfSignature.FlagSign || !entity.PrimaryKey.PubKeyAlgo.CanSign() {
+			return fmt.Errorf("%w: the primary key %016X is not a signing key", errInvalidSigningKey, keyID)
+		}
+		// The primary key is only used when no signing subkey has the key ID
+		return nil
+	}
+
+	for _, subkey := range entity.Subkeys {
+		if subkey.PublicKey.KeyId != keyID {
+			continue
+		}
+		switch {
+		case subkey.Revoked(now):
+			return fmt.Errorf("%w: the subkey %016X is revoked", errInvalidSigningKey, keyID)
+		case subkey.PublicKey.KeyExpired(subkey.Sig, now) || subkey.Sig.SigExpired(now):
+			return fmt.Errorf("%w: the subkey %016X is expired", errInvalidSigningKey, keyID)
+		case !subkey.Sig.FlagsValid || !subkey.Sig.FlagSign || !subkey.PublicKey.PubKeyAlgo.CanSign():
+			return fmt.Errorf("%w: the subkey %016X is not a signing key", errInvalidSigningKey, keyID)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%w: no key with the key ID %016X", errInvalidSigningKey, keyID)
+}
+
+// parseSigningKeyID parses the key ID of a signing key, 16 hexadecimal
+// characters optionally prefixed by 0x. An empty key ID is parsed as 0.
+func parseSigningKeyID(keyID string) (uint64, error) {
+	if keyID == "" {
+		return 0, nil
+	}
+	hexKeyID := strings.TrimPrefix(strings.TrimPrefix(keyID, "0x"), "0X")
+	id, err := strconv.ParseUint(hexKeyID, 16, 64)
+	if len(hexKeyID) != 16 || err != nil {
+		return 0, fmt.Errorf("invalid signing key ID %s; must be 16 hexadecimal characters", keyID)
+	}
+	return id, nil
+}
+
+// newDetachedSignature returns a binary signature that can be signed once the
+// data has been hashed.
+func newDetachedSignature(signingKey *packet.PrivateKey, hashFunc crypto.Hash, creationTime time.Time) *packet.Signature {
+	return &packet.Signature{
+		Version:           signingKey.Version,
+		SigType:           packet.SigTypeBinary,
+		PubKeyAlgo:        signingKey.PubKeyAlgo,
+		Hash:              hashFunc,
+		CreationTime:      creationTime,
+		IssuerKeyId:       &signingKey.KeyId,
+		IssuerFingerprint: signingKey.Fingerprint,
+	}
+}
+
+func deterministicSignatureConfig() *packet.Config {
+	return &packet.Config{
+		// The salt notation would make the trailer different on each call
+		NonDeterministicSignaturesViaNotation: packet.BoolPointer(false),
+	}
+}
+
+// prehashedDigest is a hash.Hash returning a digest computed by the client.
+// The hash trailer written by the signature is ignored, the client has already
+// hashed it.
+type prehashedDigest struct {
+	digest   []byte
+	hashFunc crypto.Hash
+}
+
+func (d *prehashedDigest) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (d *prehashedDigest) Sum(b []byte) []byte {
+	return append(b, d.digest...)
+}
+
+func (d *prehashedDigest) Reset() {}
+
+func (d *prehashedDigest) Size() int {
+	return d.hashFunc.Size()
+}
+
+func (d *prehashedDigest) BlockSize() int {
+	return d.hashFunc.New().BlockSize()
+}
+
+const pathSignTrailerHelpSyn = "Get the hash trailer needed to sign a prehashed input"
+const pathSignTrailerHelpDesc = `
+This path returns the hash trailer of the signature that will be generated by
+the named GPG key. The digest of the data followed by the hash trailer can
+then be signed with the prehashed parameter of the sign endpoint using the
+same algorithm and creation time.
+
+Prehashed inputs must be allowed with the allow_prehashed parameter of the
+keys/:name/config endpoint. The key signs any digest without seeing the data,
+the primary key is only used when allow_prehashed_certify is also set since
+the digest could be the one of a certification.
+`
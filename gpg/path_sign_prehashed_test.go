@@ -0,0 +1,279 @@
+package gpg
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestGPG_SignPrehashed(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"generate": false,
+		"key":      gpgKey,
+	}, false)
+
+	request := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	data := []byte("a multi-gigabyte release tarball")
+
+	// Prehashed inputs must be allowed, and the key only has a primary key
+	// which certifies it
+	for _, config := range []map[string]interface{}{
+		{},
+		{"allow_prehashed": true},
+	} {
+		if resp := request("keys/test/config", config); resp.IsError() {
+			t.Fatal(resp.Error())
+		}
+		for _, path := range []string{"sign/test/trailer", "sign/test"} {
+			resp := request(path, map[string]interface{}{
+				"input":         base64.StdEncoding.EncodeToString(make([]byte, 64)),
+				"algorithm":     "sha2-512",
+				"prehashed":     true,
+				"creation_time": time.Now().Unix(),
+			})
+			if !resp.IsError() || !strings.Contains(resp.Error().Error(), "not allowed") {
+				t.Fatalf("expected prehashed inputs to be refused for %s with %#v: %#v", path, config, resp)
+			}
+		}
+	}
+	if resp := request("keys/test/config", map[string]interface{}{"allow_prehashed_certify": true}); resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+
+	resp := request("sign/test/trailer", map[string]interface{}{
+		"algorithm": "sha2-512",
+	})
+	if resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+	trailer, err := base64.StdEncoding.DecodeString(resp.Data["hash_trailer"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	creationTime := resp.Data["creation_time"].(time.Time)
+
+	// The trailer only depends on the key, the algorithm and the creation time
+	resp = request("sign/test/trailer", map[string]interface{}{
+		"algorithm":     "sha2-512",
+		"creation_time": creationTime.Format(time.RFC3339),
+	})
+	if resp.Data["hash_trailer"] != base64.StdEncoding.EncodeToString(trailer) {
+		t.Fatal("expected the hash trailer to be deterministic")
+	}
+
+	h := sha512.New()
+	h.Write(data)
+	h.Write(trailer)
+	resp = request("sign/test", map[string]interface{}{
+		"input":         base64.StdEncoding.EncodeToString(h.Sum(nil)),
+		"algorithm":     "sha2-512",
+		"prehashed":     true,
+		"creation_time": creationTime.Unix(),
+	})
+	if resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+	signature := resp.Data["signature"].(string)
+
+	resp = request("verify/test", map[string]interface{}{
+		"input":     base64.StdEncoding.EncodeToString(data),
+		"signature": signature,
+	})
+	if resp.Data["valid"] != true {
+		t.Fatalf("expected a valid signature: %#v", resp)
+	}
+	resp = request("verify/test", map[string]interface{}{
+		"input":     base64.StdEncoding.EncodeToString([]byte("another tarball")),
+		"signature": signature,
+	})
+	if resp.Data["valid"] != false {
+		t.Fatalf("expected an invalid signature: %#v", resp)
+	}
+
+	// A creation time different from the one of the trailer
+	resp = request("sign/test", map[string]interface{}{
+		"input":         base64.StdEncoding.EncodeToString(h.Sum(nil)),
+		"algorithm":     "sha2-512",
+		"prehashed":     true,
+		"creation_time": creationTime.Unix() + 1,
+	})
+	resp = request("verify/test", map[string]interface{}{
+		"input":     base64.StdEncoding.EncodeToString(data),
+		"signature": resp.Data["signature"],
+	})
+	if resp.Data["valid"] != false {
+		t.Fatalf("expected an invalid signature: %#v", resp)
+	}
+
+	// Missing creation time
+	resp = request("sign/test", map[string]interface{}{
+		"input":     base64.StdEncoding.EncodeToString(h.Sum(nil)),
+		"algorithm": "sha2-512",
+		"prehashed": true,
+	})
+	if !resp.IsError() {
+		t.Fatalf("expected an error: %#v", resp)
+	}
+
+	// Digest not matching the algorithm
+	resp = request("sign/test", map[string]interface{}{
+		"input":         base64.StdEncoding.EncodeToString(h.Sum(nil)),
+		"prehashed":     true,
+		"creation_time": creationTime.Unix(),
+	})
+	if !resp.IsError() {
+		t.Fatalf("expected an error: %#v", resp)
+	}
+
+	// Only detached signatures
+	resp = request("sign/test", map[string]interface{}{
+		"input":          base64.StdEncoding.EncodeToString(h.Sum(nil)),
+		"algorithm":      "sha2-512",
+		"prehashed":      true,
+		"creation_time":  creationTime.Unix(),
+		"signature_type": "inline",
+	})
+	if !resp.IsError() {
+		t.Fatalf("expected an error: %#v", resp)
+	}
+
+	// Unknown key
+	resp = request("sign/notfound/trailer", map[string]interface{}{})
+	if !resp.IsError() {
+		t.Fatalf("expected an error: %#v", resp)
+	}
+}
+
+func TestGPG_SignPrehashedKeyAtCreationTime(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	now := time.Now().Truncate(time.Second)
+	configAt := func(creationTime time.Time, lifetime uint32) *packet.Config {
+		return &packet.Config{
+			Algorithm:       packet.PubKeyAlgoEd25519,
+			KeyLifetimeSecs: lifetime,
+			Time: func() time.Time {
+				return creationTime
+			},
+		}
+	}
+	entity, err := openpgp.NewEntity("Vault GPG test", "", "vault@example.com", configAt(now.Add(-4*time.Hour), 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The newest signing subkey expired an hour after its creation, the
+	// oldest one is used to sign now
+	for _, config := range []*packet.Config{configAt(now.Add(-4*time.Hour), 0), configAt(now.Add(-3*time.Hour), 3600)} {
+		if err = entity.AddSigningSubkey(config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	current, expired := entity.Subkeys[1].PublicKey, entity.Subkeys[2].PublicKey
+
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"generate": false,
+		"key":      testArmoredPrivateKey(t, entity),
+	}, false)
+
+	request := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	if resp := request("keys/test/config", map[string]interface{}{"allow_prehashed": true}); resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+
+	data := []byte("a multi-gigabyte release tarball")
+	for _, test := range []struct {
+		creationTime time.Time
+		signingKey   *packet.PublicKey
+	}{
+		{now, current},
+		{now.Add(-150 * time.Minute), expired},
+	} {
+		resp := request("sign/test/trailer", map[string]interface{}{
+			"algorithm":     "sha2-512",
+			"creation_time": test.creationTime.Unix(),
+		})
+		if resp.IsError() {
+			t.Fatal(resp.Error())
+		}
+		trailer, err := base64.StdEncoding.DecodeString(resp.Data["hash_trailer"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := sha512.New()
+		h.Write(data)
+		h.Write(trailer)
+		resp = request("sign/test", map[string]interface{}{
+			"input":         base64.StdEncoding.EncodeToString(h.Sum(nil)),
+			"algorithm":     "sha2-512",
+			"prehashed":     true,
+			"creation_time": test.creationTime.Unix(),
+		})
+		if resp.IsError() {
+			t.Fatal(resp.Error())
+		}
+		// The subkey is expired now, the signature is checked at its creation
+		signature, err := base64.StdEncoding.DecodeString(resp.Data["signature"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := &packet.Config{Time: func() time.Time { return test.creationTime }}
+		signer, err := openpgp.CheckDetachedSignature(openpgp.EntityList{entity}, bytes.NewReader(data), bytes.NewReader(signature), config)
+		if err != nil {
+			t.Fatalf("expected a valid signature at %s: %s", test.creationTime, err)
+		}
+		if signer.PrimaryKey.KeyId != entity.PrimaryKey.KeyId {
+			t.Fatal("unexpected signer")
+		}
+		sig, err := packet.Read(bytes.NewReader(signature))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keyID := *sig.(*packet.Signature).IssuerKeyId; keyID != test.signingKey.KeyId {
+			t.Fatalf("expected the key %016X to sign at %s, got %016X", test.signingKey.KeyId, test.creationTime, keyID)
+		}
+	}
+
+	// No key can sign before the creation of the key
+	resp := request("sign/test/trailer", map[string]interface{}{
+		"algorithm":     "sha2-512",
+		"creation_time": now.Add(-5 * time.Hour).Unix(),
+	})
+	if !resp.IsError() {
+		t.Fatalf("expected an error: %#v", resp)
+	}
+}
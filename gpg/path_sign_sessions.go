@@ -0,0 +1,461 @@
+package gpg
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	signingSessionDefaultTTL = time.Hour
+	signingSessionMaxTTL     = 24 * time.Hour
+)
+
+func pathSignSessions(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "sign/" + framework.GenericNameRegex("name") + "/sessions",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "The key to use",
+			},
+			"algorithm": {
+				Type:        framework.TypeString,
+				Default:     "sha2-256",
+				Description: `Hash algorithm to use. Defaults to "sha2-256".`,
+			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Default:     int(signingSessionDefaultTTL.Seconds()),
+				Description: "Duration after which the session expires if it is not finalized. Defaults to 1 hour, can not exceed 24 hours.",
+			},
+			"signing_key_id": {
+				Type:        framework.TypeString,
+				Description: "Key ID of the subkey that will be used to sign the data. Defaults to the newest valid signing subkey.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathSignSessionCreate,
+			},
+		},
+		HelpSynopsis:    pathSignSessionsHelpSyn,
+		HelpDescription: pathSignSessionsHelpDesc,
+	}
+}
+
+func pathSignSession(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "sign/" + framework.GenericNameRegex("name") + "/sessions/" + framework.GenericNameRegex("session_id"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "The key to use",
+			},
+			"session_id": {
+				Type:        framework.TypeString,
+				Description: "Identifier of the signing session",
+			},
+			"input": {
+				Type:        framework.TypeString,
+				Description: "The base64-encoded chunk of data to append",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathSignSessionUpdate,
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.pathSignSessionDelete,
+			},
+		},
+		HelpSynopsis:    pathSignSessionsHelpSyn,
+		HelpDescription: pathSignSessionsHelpDesc,
+	}
+}
+
+func pathSignSessionFinalize(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "sign/" + framework.GenericNameRegex("name") + "/sessions/" + framework.GenericNameRegex("session_id") + "/finalize",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "The key to use",
+			},
+			"session_id": {
+				Type:        framework.TypeString,
+				Description: "Identifier of the signing session",
+			},
+			"format": {
+				Type:        framework.TypeString,
+				Default:     "base64",
+				Description: `Encoding format to use. Can be "base64" or "ascii-armor". Defaults to "base64".`,
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathSignSessionFinalize,
+			},
+		},
+		HelpSynopsis:    pathSignSessionsHelpSyn,
+		HelpDescription: pathSignSessionsHelpDesc,
+	}
+}
+
+func (b *backend) signingSession(ctx context.Context, s logical.Storage, id string) (*signingSession, error) {
+	entry, err := s.Get(ctx, "session/"+id)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result signingSession
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (b *backend) storeSigningSession(ctx context.Context, s logical.Storage, session *signingSession) error {
+	entry, err := logical.StorageEntryJSON("session/"+session.ID, session)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// activeSigningSession returns the session if it exists, belongs to the named
+// key and has not expired.
+func (b *backend) activeSigningSession(ctx context.Context, s logical.Storage, name, id string) (*signingSession, error) {
+	session, err := b.signingSession(ctx, s, id)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || session.KeyName != name || time.Now().After(session.ExpirationTime) {
+		return nil, nil
+	}
+	return session, nil
+}
+
+func (b *backend) pathSignSessionCreate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	algorithm := data.Get("algorithm").(string)
+	hashFunc, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return logical.ErrorResponse(fmt.Sprintf("unsupported algorithm %s", algorithm)), nil
+	}
+
+	ttl := time.Duration(data.Get("ttl").(int)) * time.Second
+	if ttl <= 0 || ttl > signingSessionMaxTTL {
+		return logical.ErrorResponse(fmt.Sprintf("the TTL must be between 1 second and %s", signingSessionMaxTTL)), nil
+	}
+
+	keyID, err := parseSigningKeyID(data.Get("signing_key_id").(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	name := data.Get("name").(string)
+	entry, err := b.key(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse("key not found"), logical.ErrInvalidRequest
+	}
+	entity, err := b.entity(name, entry)
+	if err != nil {
+		return nil, err
+	}
+	// The key signing the data is the one valid at the creation time of the
+	// signature
+	now := time.Now().Truncate(time.Second)
+	signingKey, err := entitySigningKey(entity, now, keyID)
+	if errors.Is(err, errInvalidSigningKey) {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if signingKey.Version != 4 && signingKey.Version != 6 {
+		return logical.ErrorResponse("signing sessions are only supported for v4 and v6 signing keys"), nil
+	}
+	if err := checkHashForKey(signingKey, hashFunc); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// v6 signatures hash a salt before the data
+	sig := newDetachedSignature(signingKey, hashFunc, now)
+	h, err := sig.PrepareSign(deterministicSignatureConfig())
+	if err != nil {
+		return nil, err
+	}
+	state, err := marshalHashState(h, hashFunc)
+	if errors.Is(err, errUnsupportedHash) {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+	session := &signingSession{
+		ID:             id,
+		KeyName:        name,
+		SigningKeyID:   keyID,
+		Fingerprint:    signingKey.Fingerprint,
+		Algorithm:      algorithm,
+		CreationTime:   now,
+		ExpirationTime: now.Add(ttl),
+		Salt:           sig.Salt(),
+		HashState:      state,
+	}
+	if err := b.storeSigningSession(ctx, req.Storage, session); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"session_id":      id,
+			"expiration_time": session.ExpirationTime,
+		},
+	}, nil
+}
+
+func (b *backend) pathSignSessionUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	input, err := base64.StdEncoding.DecodeString(data.Get("input").(string))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("unable to decode input as base64: %s", err)), logical.ErrInvalidRequest
+	}
+
+	name := data.Get("name").(string)
+	id := data.Get("session_id").(string)
+
+	lock := locksutil.LockForKey(b.keyLocks, "session/"+id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	session, err := b.activeSigningSession(ctx, req.Storage, name, id)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return logical.ErrorResponse("signing session not found"), logical.ErrInvalidRequest
+	}
+
+	hashFunc := hashAlgorithms[session.Algorithm]
+	h, err := unmarshalHashState(hashFunc, session.HashState)
+	if errors.Is(err, errUnsupportedHash) {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	h.Write(input)
+	session.HashState, err = marshalHashState(h, hashFunc)
+	if errors.Is(err, errUnsupportedHash) {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	session.Length += uint64(len(input))
+	if err := b.storeSigningSession(ctx, req.Storage, session); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"length": session.Length,
+		},
+	}, nil
+}
+
+func (b *backend) pathSignSessionFinalize(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	format := data.Get("format").(string)
+	switch format {
+	case "base64":
+	case "ascii-armor":
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("unsupported encoding format %s; must be \"base64\" or \"ascii-armor\"", format)), nil
+	}
+
+	name := data.Get("name").(string)
+	id := data.Get("session_id").(string)
+
+	lock := locksutil.LockForKey(b.keyLocks, "session/"+id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	session, err := b.activeSigningSession(ctx, req.Storage, name, id)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return logical.ErrorResponse("signing session not found"), logical.ErrInvalidRequest
+	}
+
+	entry, err := b.key(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse("key not found"), logical.ErrInvalidRequest
+	}
+	entity, err := b.entity(name, entry)
+	if err != nil {
+		return nil, err
+	}
+	signingKey, err := entitySigningKey(entity, session.CreationTime, session.SigningKeyID)
+	if errors.Is(err, errInvalidSigningKey) {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(signingKey.Fingerprint, session.Fingerprint) {
+		return logical.ErrorResponse("the signing key has changed since the session has been started"), nil
+	}
+
+	hashFunc := hashAlgorithms[session.Algorithm]
+	h, err := unmarshalHashState(hashFunc, session.HashState)
+	if errors.Is(err, errUnsupportedHash) {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sig := newDetachedSignature(signingKey, hashFunc, session.CreationTime)
+	if err := sig.SetSalt(session.Salt); err != nil {
+		return nil, err
+	}
+	if err := sig.Sign(h, signingKey, deterministicSignatureConfig()); err != nil {
+		return nil, err
+	}
+	var signature bytes.Buffer
+	if err := sig.Serialize(&signature); err != nil {
+		return nil, err
+	}
+
+	// A session can only be finalized once
+	if err := req.Storage.Delete(ctx, "session/"+id); err != nil {
+		return nil, err
+	}
+	b.usage.record(name, usageSign)
+
+	output, err := encodeOutput(signature.Bytes(), "PGP SIGNATURE", format)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"signature": output,
+		},
+	}, nil
+}
+
+func (b *backend) pathSignSessionDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	id := data.Get("session_id").(string)
+
+	lock := locksutil.LockForKey(b.keyLocks, "session/"+id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	session, err := b.signingSession(ctx, req.Storage, id)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || session.KeyName != name {
+		return nil, nil
+	}
+
+	return nil, req.Storage.Delete(ctx, "session/"+id)
+}
+
+// pruneSigningSessions removes the expired signing sessions. It is called
+// periodically by Vault.
+func (b *backend) pruneSigningSessions(ctx context.Context, storage logical.Storage) error {
+	ids, err := storage.List(ctx, "session/")
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, id := range ids {
+		session, err := b.signingSession(ctx, storage, id)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if session == nil || time.Now().Before(session.ExpirationTime) {
+			continue
+		}
+		if err := storage.Delete(ctx, "session/"+id); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// marshalHashState returns the state of the hash, saved between the requests
+// of a session.
+func marshalHashState(h hash.Hash, hashFunc crypto.Hash) ([]byte, error) {
+	marshaler, ok := h.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("%w: the state of %s cannot be saved between the requests of a session", errUnsupportedHash, hashFunc)
+	}
+	return marshaler.MarshalBinary()
+}
+
+// unmarshalHashState returns a hash resumed from the state saved by
+// marshalHashState.
+func unmarshalHashState(hashFunc crypto.Hash, state []byte) (hash.Hash, error) {
+	h := hashFunc.New()
+	unmarshaler, ok := h.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return nil, fmt.Errorf("%w: the state of %s cannot be restored between the requests of a session", errUnsupportedHash, hashFunc)
+	}
+	if err := unmarshaler.UnmarshalBinary(state); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+type signingSession struct {
+	ID             string
+	KeyName        string
+	SigningKeyID   uint64
+	Fingerprint    []byte
+	Algorithm      string
+	CreationTime   time.Time
+	ExpirationTime time.Time
+	Salt           []byte
+	HashState      []byte
+	Length         uint64
+}
+
+const pathSignSessionsHelpSyn = "Sign large inputs sent in several chunks"
+const pathSignSessionsHelpDesc = `
+This path is used to sign inputs too large to be sent in a single request.
+A session is started for the named key, the input is appended chunk by chunk
+and the detached signature is returned when the session is finalized.
+Sessions that are not finalized expire after their TTL.
+`
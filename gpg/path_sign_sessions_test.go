@@ -0,0 +1,302 @@
+package gpg
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestGPG_SignSessions(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"generate": false,
+		"key":      gpgKey,
+	}, false)
+	testAccStepCreateKey(t, b, storage, "other", map[string]interface{}{
+		"generate": false,
+		"key":      gpgKey,
+	}, false)
+
+	request := func(operation logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: operation,
+			Path:      path,
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	chunks := []string{"the quick ", "brown fox ", "jumps over the lazy dog"}
+
+	for _, algorithm := range []string{"sha2-256", "sha2-512", "sha3-256", "sha3-512"} {
+		resp := request(logical.UpdateOperation, "sign/test/sessions", map[string]interface{}{
+			"algorithm": algorithm,
+		})
+		if resp.IsError() {
+			t.Fatal(resp.Error())
+		}
+		id := resp.Data["session_id"].(string)
+
+		for _, chunk := range chunks {
+			resp = request(logical.UpdateOperation, "sign/test/sessions/"+id, map[string]interface{}{
+				"input": base64.StdEncoding.EncodeToString([]byte(chunk)),
+			})
+			if resp.IsError() {
+				t.Fatal(resp.Error())
+			}
+		}
+		if resp.Data["length"] != uint64(len(strings.Join(chunks, ""))) {
+			t.Fatalf("unexpected length: %#v", resp.Data)
+		}
+
+		resp = request(logical.UpdateOperation, "sign/test/sessions/"+id+"/finalize", map[string]interface{}{
+			"format": "ascii-armor",
+		})
+		if resp.IsError() {
+			t.Fatal(resp.Error())
+		}
+		signature := resp.Data["signature"].(string)
+
+		resp = request(logical.UpdateOperation, "verify/test", map[string]interface{}{
+			"input":     base64.StdEncoding.EncodeToString([]byte(strings.Join(chunks, ""))),
+			"signature": signature,
+			"format":    "ascii-armor",
+		})
+		if resp.Data["valid"] != true {
+			t.Fatalf("expected a valid signature with %s: %#v", algorithm, resp)
+		}
+
+		// A session can only be finalized once
+		resp = request(logical.UpdateOperation, "sign/test/sessions/"+id+"/finalize", map[string]interface{}{})
+		if !resp.IsError() {
+			t.Fatalf("expected an error: %#v", resp)
+		}
+	}
+
+	// Sessions are bound to their key
+	resp := request(logical.UpdateOperation, "sign/test/sessions", map[string]interface{}{})
+	id := resp.Data["session_id"].(string)
+	resp = request(logical.UpdateOperation, "sign/other/sessions/"+id, map[string]interface{}{
+		"input": base64.StdEncoding.EncodeToString([]byte(chunks[0])),
+	})
+	if !resp.IsError() {
+		t.Fatalf("expected an error: %#v", resp)
+	}
+
+	// Aborted session
+	request(logical.DeleteOperation, "sign/test/sessions/"+id, nil)
+	resp = request(logical.UpdateOperation, "sign/test/sessions/"+id+"/finalize", map[string]interface{}{})
+	if !resp.IsError() {
+		t.Fatalf("expected an error: %#v", resp)
+	}
+
+	// Expired session
+	resp = request(logical.UpdateOperation, "sign/test/sessions", map[string]interface{}{
+		"ttl": "1s",
+	})
+	id = resp.Data["session_id"].(string)
+	session, err := b.signingSession(context.Background(), storage, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.ExpirationTime = time.Now().Add(-time.Second)
+	if err := b.storeSigningSession(context.Background(), storage, session); err != nil {
+		t.Fatal(err)
+	}
+	resp = request(logical.UpdateOperation, "sign/test/sessions/"+id, map[string]interface{}{
+		"input": base64.StdEncoding.EncodeToString([]byte(chunks[0])),
+	})
+	if !resp.IsError() {
+		t.Fatalf("expected an error: %#v", resp)
+	}
+	if err := b.PeriodicFunc(context.Background(), &logical.Request{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	session, err = b.signingSession(context.Background(), storage, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session != nil {
+		t.Fatal("expected the expired session to be removed")
+	}
+
+	// Invalid parameters
+	resp = request(logical.UpdateOperation, "sign/test/sessions", map[string]interface{}{
+		"ttl": "48h",
+	})
+	if !resp.IsError() {
+		t.Fatalf("expected an error: %#v", resp)
+	}
+	resp = request(logical.UpdateOperation, "sign/test/sessions", map[string]interface{}{
+		"algorithm": "md5",
+	})
+	if !resp.IsError() {
+		t.Fatalf("expected an error: %#v", resp)
+	}
+	resp = request(logical.UpdateOperation, "sign/notfound/sessions", map[string]interface{}{})
+	if !resp.IsError() {
+		t.Fatalf("expected an error: %#v", resp)
+	}
+}
+
+func TestGPG_SignSessionsSigningKey(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	now := time.Now().Truncate(time.Second)
+	configAt := func(creationTime time.Time, lifetime uint32) *packet.Config {
+		return &packet.Config{
+			Algorithm:       packet.PubKeyAlgoEd25519,
+			KeyLifetimeSecs: lifetime,
+			Time: func() time.Time {
+				return creationTime
+			},
+		}
+	}
+	entity, err := openpgp.NewEntity("Vault GPG test", "", "vault@example.com", configAt(now.Add(-4*time.Hour), 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The newest signing subkey expired an hour after its creation
+	for _, config := range []*packet.Config{configAt(now.Add(-4*time.Hour), 0), configAt(now.Add(-3*time.Hour), 3600)} {
+		if err = entity.AddSigningSubkey(config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	current, expired := entity.Subkeys[1].PublicKey, entity.Subkeys[2].PublicKey
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"generate": false,
+		"key":      testArmoredPrivateKey(t, entity),
+	}, false)
+
+	expiredEntity, err := openpgp.NewEntity("Vault GPG test", "", "vault@example.com", configAt(now.Add(-time.Hour), 60))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAccStepCreateKey(t, b, storage, "expired", map[string]interface{}{
+		"generate": false,
+		"key":      testArmoredPrivateKey(t, expiredEntity),
+	}, false)
+
+	request := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	input := []byte("the quick brown fox")
+	// sign signs the input in a session and returns the key ID of the
+	// signature, checked at the creation time of the session
+	sign := func(data map[string]interface{}, update func(*signingSession)) uint64 {
+		resp := request("sign/test/sessions", data)
+		if resp.IsError() {
+			t.Fatal(resp.Error())
+		}
+		id := resp.Data["session_id"].(string)
+		session, err := b.signingSession(context.Background(), storage, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if update != nil {
+			update(session)
+			if err := b.storeSigningSession(context.Background(), storage, session); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if resp := request("sign/test/sessions/"+id, map[string]interface{}{"input": base64.StdEncoding.EncodeToString(input)}); resp.IsError() {
+			t.Fatal(resp.Error())
+		}
+		resp = request("sign/test/sessions/"+id+"/finalize", map[string]interface{}{})
+		if resp.IsError() {
+			t.Fatal(resp.Error())
+		}
+		signature, err := base64.StdEncoding.DecodeString(resp.Data["signature"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := &packet.Config{Time: func() time.Time { return session.CreationTime }}
+		if _, err := openpgp.CheckDetachedSignature(openpgp.EntityList{entity}, bytes.NewReader(input), bytes.NewReader(signature), config); err != nil {
+			t.Fatalf("expected a valid signature: %s", err)
+		}
+		sig, err := packet.Read(bytes.NewReader(signature))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *sig.(*packet.Signature).IssuerKeyId
+	}
+
+	if keyID := sign(map[string]interface{}{}, nil); keyID != current.KeyId {
+		t.Fatalf("expected the current subkey to sign, got %016X", keyID)
+	}
+	if keyID := sign(map[string]interface{}{"signing_key_id": fmt.Sprintf("%016X", entity.PrimaryKey.KeyId)}, nil); keyID != entity.PrimaryKey.KeyId {
+		t.Fatalf("expected the primary key to sign, got %016X", keyID)
+	}
+	// The key is picked at the creation time of the session, not when it is
+	// finalized
+	if keyID := sign(map[string]interface{}{}, func(session *signingSession) {
+		session.CreationTime = now.Add(-150 * time.Minute)
+		session.Fingerprint = expired.Fingerprint
+	}); keyID != expired.KeyId {
+		t.Fatalf("expected the subkey valid when the session started to sign, got %016X", keyID)
+	}
+
+	// Keys that cannot sign are refused
+	for path, data := range map[string]map[string]interface{}{
+		"sign/test/sessions":    {"signing_key_id": fmt.Sprintf("%016X", expired.KeyId)},
+		"sign/expired/sessions": {},
+	} {
+		if resp := request(path, data); !resp.IsError() || !strings.Contains(resp.Error().Error(), "invalid signing key") {
+			t.Fatalf("expected the signing key to be refused for %s: %#v", path, resp)
+		}
+	}
+}
+
+// unmarshalableHash hides the methods saving the state of the hash.
+type unmarshalableHash struct {
+	hash.Hash
+}
+
+func TestGPG_SignSessionsHashState(t *testing.T) {
+	h := sha256.New()
+	h.Write([]byte("the quick "))
+	state, err := marshalHashState(h, crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed, err := unmarshalHashState(crypto.SHA256, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed.Write([]byte("brown fox"))
+	if expected := sha256.Sum256([]byte("the quick brown fox")); !bytes.Equal(resumed.Sum(nil), expected[:]) {
+		t.Fatal("expected the hash to be resumed")
+	}
+
+	if _, err := marshalHashState(unmarshalableHash{h}, crypto.SHA256); !errors.Is(err, errUnsupportedHash) {
+		t.Fatalf("expected the hash to be refused: %v", err)
+	}
+}
@@ -5,18 +5,22 @@ import (
 	"context"
 	"crypto"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
+	"time"
 
 	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
 	"github.com/ProtonMail/go-crypto/openpgp/packet"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
 func pathSign(b *backend) *framework.Path {
-	return &framework.Path{
+	path := &framework.Path{
 		Pattern: "sign/" + framework.GenericNameRegex("name") + framework.OptionalParamRegex("urlalgorithm"),
 		Fields: map[string]*framework.FieldSchema{
 			"name": {
@@ -40,14 +44,57 @@ func pathSign(b *backend) *framework.Path {
 * sha2-256
 * sha2-384
 * sha2-512
+* sha3-256
+* sha3-512
 
-Defaults to "sha2-256".`,
+The algorithm must be strong enough for the signing key, for example elliptic
+curve keys over P-384 require a digest of at least 384 bits. Defaults to "sha2-256".`,
 			},
 			"format": {
 				Type:        framework.TypeString,
 				Default:     "base64",
 				Description: `Encoding format to use. Can be "base64" or "ascii-armor". Defaults to "base64".`,
 			},
+			"signature_type": {
+				Type:    framework.TypeString,
+				Default: "detached",
+				Description: `Type of signature to generate. Valid values are:
+
+* detached: the signature is returned without the input data
+* cleartext: the input data is returned as a cleartext signed document
+* inline: the input data is returned embedded in a signed message
+
+Defaults to "detached".`,
+			},
+			"compression": {
+				Type:        framework.TypeString,
+				Default:     "none",
+				Description: `Compression algorithm of inline signed messages. Can be "none", "zip" or "zlib". Defaults to "none".`,
+			},
+			"mode": {
+				Type:    framework.TypeString,
+				Default: "binary",
+				Description: `Signature mode of detached signatures. Valid values are:
+
+* binary: the input data is signed as is
+* text: the line endings of the input data are canonicalized before it is signed
+
+Cleartext signatures are always text signatures. Defaults to "binary".`,
+			},
+			"prehashed": {
+				Type:        framework.TypeBool,
+				Default:     false,
+				Description: "Set to true when the input is the digest of the data followed by the hash trailer returned by the sign/:name/trailer endpoint. Only supported for detached signatures and for the keys allowing it with the allow_prehashed parameter of keys/:name/config.",
+			},
+			"creation_time": {
+				Type:        framework.TypeTime,
+				Description: "Creation time of the signature. Defaults to the current time. Required for prehashed inputs, it must be the creation time used to get the hash trailer.",
+			},
+			"signing_key_id": {
+				Type:        framework.TypeString,
+				Description: "Key ID of the subkey to sign with, as 16 hexadecimal characters. Defaults to the newest valid signing subkey. Required for prehashed inputs when it was used to get the hash trailer.",
+			},
+			"batch_input": batchInputField,
 		},
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.UpdateOperation: &framework.PathOperation{
@@ -57,29 +104,21 @@ Defaults to "sha2-256".`,
 		HelpSynopsis:    pathSignHelpSyn,
 		HelpDescription: pathSignHelpDesc,
 	}
+	for name, schema := range signatureOptionsFields {
+		path.Fields[name] = schema
+	}
+	return path
 }
 
 func pathVerify(b *backend) *framework.Path {
-	return &framework.Path{
+	path := &framework.Path{
 		Pattern: "verify/" + framework.GenericNameRegex("name"),
 		Fields: map[string]*framework.FieldSchema{
 			"name": {
 				Type:        framework.TypeString,
 				Description: "The key to use",
 			},
-			"input": {
-				Type:        framework.TypeString,
-				Description: "The base64-encoded input data to verify",
-			},
-			"signature": {
-				Type:        framework.TypeString,
-				Description: "The signature",
-			},
-			"format": {
-				Type:        framework.TypeString,
-				Default:     "base64",
-				Description: `Encoding format the signature use. Can be "base64" or "ascii-armor". Defaults to "base64".`,
-			},
+			"batch_input": batchInputField,
 		},
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.UpdateOperation: &framework.PathOperation{
@@ -89,9 +128,125 @@ func pathVerify(b *backend) *framework.Path {
 		HelpSynopsis:    pathVerifyHelpSyn,
 		HelpDescription: pathVerifyHelpDesc,
 	}
+	for name, schema := range verifyFields {
+		path.Fields[name] = schema
+	}
+	return path
+}
+
+// verifyFields are the fields describing the signature to verify.
+var verifyFields = map[string]*framework.FieldSchema{
+	"input": {
+		Type:        framework.TypeString,
+		Description: "The base64-encoded input data to verify. Not used with cleartext signatures.",
+	},
+	"signature": {
+		Type:        framework.TypeString,
+		Description: "The signature",
+	},
+	"format": {
+		Type:        framework.TypeString,
+		Default:     "base64",
+		Description: `Encoding format the signature use. Can be "base64" or "ascii-armor". Defaults to "base64".`,
+	},
+	"signature_type": {
+		Type:        framework.TypeString,
+		Description: `Type of the signature to verify. Can be "detached", "cleartext" or "inline". Defaults to "detached" when an input is provided, "inline" otherwise.`,
+	},
+	"known_notations": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Names of the critical notations understood by the caller. Signatures with other critical notations are invalid.",
+	},
+	"reference_time": {
+		Type:        framework.TypeTime,
+		Description: "Time at which the signature is verified, for example to check that the key was not expired when the data was signed. Defaults to the current time.",
+	},
+	"all_signatures": {
+		Type:        framework.TypeBool,
+		Description: "Verify every signature of a detached or cleartext signature instead of the first one made by a known key and return the result of each signature.",
+	},
+	"threshold": {
+		Type:        framework.TypeInt,
+		Description: "Number of distinct keys that must have made a valid signature for the verification to succeed. Implies all_signatures. By default, all the signatures must be valid.",
+	},
+}
+
+var hashAlgorithms = map[string]crypto.Hash{
+	"sha2-224": crypto.SHA224,
+	"sha2-256": crypto.SHA256,
+	"sha2-384": crypto.SHA384,
+	"sha2-512": crypto.SHA512,
+	"sha3-256": crypto.SHA3_256,
+	"sha3-512": crypto.SHA3_512,
+}
+
+// hashAlgorithmName returns the name of the hash algorithm used by the API.
+func hashAlgorithmName(hashFunc crypto.Hash) string {
+	for name, h := range hashAlgorithms {
+		if h == hashFunc {
+			return name
+		}
+	}
+	return hashFunc.String()
+}
+
+// acceptableHashes returns the hash algorithms that can be used by the signing
+// key. The digest of the hash algorithms must be at least as large as the
+// security level of the elliptic curve keys.
+func acceptableHashes(signingKey *packet.PublicKey) []crypto.Hash {
+	curve, err := signingKey.Curve()
+	switch {
+	case err != nil:
+		// RSA and DSA keys
+		return []crypto.Hash{crypto.SHA224, crypto.SHA256, crypto.SHA384, crypto.SHA512, crypto.SHA3_256, crypto.SHA3_512}
+	case curve == packet.Curve448 || curve == packet.CurveNistP521 || curve == packet.CurveBrainpoolP512:
+		return []crypto.Hash{crypto.SHA512, crypto.SHA3_512}
+	case curve == packet.CurveNistP384 || curve == packet.CurveBrainpoolP384:
+		return []crypto.Hash{crypto.SHA384, crypto.SHA512, crypto.SHA3_512}
+	default:
+		return []crypto.Hash{crypto.SHA256, crypto.SHA384, crypto.SHA512, crypto.SHA3_256, crypto.SHA3_512}
+	}
+}
+
+// errUnsupportedHash is returned when the hash algorithm is too weak for the
+// signing key.
+var errUnsupportedHash = errors.New("hash algorithm not supported by the signing key")
+
+// checkHashForKey returns an error when the hash algorithm cannot be used by
+// the signing key.
+func checkHashForKey(signingKey *packet.PrivateKey, hashFunc crypto.Hash) error {
+	acceptable := acceptableHashes(&signingKey.PublicKey)
+	names := make([]string, 0, len(acceptable))
+	for _, h := range acceptable {
+		if h == hashFunc {
+			return nil
+		}
+		names = append(names, hashAlgorithmName(h))
+	}
+	return fmt.Errorf("%w: %s, must be one of %s", errUnsupportedHash, hashAlgorithmName(hashFunc), strings.Join(names, ", "))
 }
 
 func (b *backend) pathSignWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	entry, err := b.key(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse("key not found"), logical.ErrInvalidRequest
+	}
+	entity, err := b.entity(name, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.handleBatch(ctx, req, data, func(data *framework.FieldData) (*logical.Response, error) {
+		return b.sign(name, entry, entity, data)
+	})
+}
+
+// sign signs the input of the request with the named key.
+func (b *backend) sign(name string, entry *keyEntry, entity *openpgp.Entity, data *framework.FieldData) (*logical.Response, error) {
 	inputB64 := data.Get("input").(string)
 	input, err := base64.StdEncoding.DecodeString(inputB64)
 	if err != nil {
@@ -104,18 +259,11 @@ func (b *backend) pathSignWrite(ctx context.Context, req *logical.Request, data
 	if algorithm == "" {
 		algorithm = data.Get("algorithm").(string)
 	}
-	switch algorithm {
-	case "sha2-224":
-		config.DefaultHash = crypto.SHA224
-	case "sha2-256":
-		config.DefaultHash = crypto.SHA256
-	case "sha2-384":
-		config.DefaultHash = crypto.SHA384
-	case "sha2-512":
-		config.DefaultHash = crypto.SHA512
-	default:
+	hashFunc, ok := hashAlgorithms[algorithm]
+	if !ok {
 		return logical.ErrorResponse(fmt.Sprintf("unsupported algorithm %s", algorithm)), nil
 	}
+	config.DefaultHash = hashFunc
 
 	format := data.Get("format").(string)
 	switch format {
@@ -125,105 +273,496 @@ func (b *backend) pathSignWrite(ctx context.Context, req *logical.Request, data
 		return logical.ErrorResponse(fmt.Sprintf("unsupported encoding format %s; must be \"base64\" or \"ascii-armor\"", format)), nil
 	}
 
-	entry, err := b.key(ctx, req.Storage, data.Get("name").(string))
+	signatureType := data.Get("signature_type").(string)
+	switch signatureType {
+	case "detached":
+	case "cleartext":
+	case "inline":
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("unsupported signature type %s; must be \"detached\", \"cleartext\" or \"inline\"", signatureType)), nil
+	}
+
+	var compression packet.CompressionAlgo
+	switch data.Get("compression").(string) {
+	case "none":
+		compression = packet.CompressionNone
+	case "zip":
+		compression = packet.CompressionZIP
+	case "zlib":
+		compression = packet.CompressionZLIB
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("unsupported compression %s; must be \"none\", \"zip\" or \"zlib\"", data.Get("compression").(string))), nil
+	}
+	if compression != packet.CompressionNone && signatureType != "inline" {
+		return logical.ErrorResponse("compression is only supported for inline signed messages"), nil
+	}
+
+	var sigType packet.SignatureType
+	switch data.Get("mode").(string) {
+	case "binary":
+		sigType = packet.SigTypeBinary
+	case "text":
+		sigType = packet.SigTypeText
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("unsupported mode %s; must be \"binary\" or \"text\"", data.Get("mode").(string))), nil
+	}
+	if sigType == packet.SigTypeText && signatureType != "detached" {
+		return logical.ErrorResponse("the text mode is only supported for detached signatures, cleartext signatures are always text signatures"), nil
+	}
+
+	prehashed := data.Get("prehashed").(bool)
+	creationTime, creationTimeSet := data.GetOk("creation_time")
+	options, err := parseSignatureOptions(data)
 	if err != nil {
-		return nil, err
+		return logical.ErrorResponse(err.Error()), nil
 	}
-	if entry == nil {
-		return logical.ErrorResponse("key not found"), logical.ErrInvalidRequest
+	if prehashed {
+		if !entry.AllowPrehashed {
+			return logical.ErrorResponse(errPrehashedNotAllowed.Error()), nil
+		}
+		if signatureType != "detached" {
+			return logical.ErrorResponse("prehashed inputs are only supported for detached signatures"), nil
+		}
+		if options.isSet() {
+			return logical.ErrorResponse("signature options are not supported for prehashed inputs"), nil
+		}
+		if sigType != packet.SigTypeBinary {
+			return logical.ErrorResponse("the text mode is not supported for prehashed inputs"), nil
+		}
+		if !creationTimeSet {
+			return logical.ErrorResponse("the creation time used to get the hash trailer is required for prehashed inputs"), logical.ErrInvalidRequest
+		}
+		if len(input) != hashFunc.Size() {
+			return logical.ErrorResponse(fmt.Sprintf("the prehashed input must be a %s digest of %d bytes", algorithm, hashFunc.Size())), logical.ErrInvalidRequest
+		}
 	}
-	entity, err := b.entity(entry)
+
+	config.SigningKeyId, err = parseSigningKeyID(data.Get("signing_key_id").(string))
 	if err != nil {
-		return nil, err
+		return logical.ErrorResponse(err.Error()), nil
 	}
 
-	message := bytes.NewReader(input)
+	if creationTimeSet {
+		options.applyTo(&config, creationTime.(time.Time))
+	} else {
+		options.applyTo(&config, time.Time{})
+	}
 
-	var armoredSignatureBuffer bytes.Buffer
-	err = openpgp.ArmoredDetachSign(&armoredSignatureBuffer, entity, message, &config)
+	if err := options.validateFor(entity, signatureType); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	signingKey, err := entitySigningKey(entity, config.Now(), config.SigningKeyId)
+	if errors.Is(err, errInvalidSigningKey) {
+		return logical.ErrorResponse(err.Error()), nil
+	}
 	if err != nil {
 		return nil, err
 	}
+	if err := checkHashForKey(signingKey, hashFunc); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
 
-	var outputSignature bytes.Buffer
-	switch format {
-	case "ascii-armor":
-		outputSignature = armoredSignatureBuffer
-	case "base64":
-		block, err := armor.Decode(bytes.NewReader(armoredSignatureBuffer.Bytes()))
+	var output string
+	switch {
+	case prehashed:
+		signature, err := signPrehashed(entity, config.SigningKeyId, entry.AllowPrehashedCertify, hashFunc, creationTime.(time.Time), input)
+		if errors.Is(err, errPrehashedKeyVersion) || errors.Is(err, errPrehashedNotAllowed) {
+			return logical.ErrorResponse(err.Error()), nil
+		}
 		if err != nil {
 			return nil, err
 		}
-
-		encoder := base64.NewEncoder(base64.StdEncoding, &outputSignature)
-		bufBody := &bytes.Buffer{}
-		_, err = bufBody.ReadFrom(block.Body)
+		output, err = encodeOutput(signature, openpgp.SignatureType, format)
+		if err != nil {
+			return nil, err
+		}
+	case signatureType == "cleartext":
+		document, err := signCleartext(entity, input, &config)
+		if errors.Is(err, errUnsupportedHash) {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// The cleartext signed document is already armored
+		output = string(document)
+		if format == "base64" {
+			output = base64.StdEncoding.EncodeToString(document)
+		}
+	case signatureType == "inline":
+		message, err := signInline(entity, input, compression, &config)
+		if errors.Is(err, errUnsupportedHash) {
+			return logical.ErrorResponse(err.Error()), nil
+		}
 		if err != nil {
 			return nil, err
 		}
-		_, err = encoder.Write(bufBody.Bytes())
+		output, err = encodeOutput(message, "PGP MESSAGE", format)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		signature, err := signDetached(entity, input, sigType, options, &config)
 		if err != nil {
 			return nil, err
 		}
-		err = encoder.Close()
+		output, err = encodeOutput(signature, openpgp.SignatureType, format)
 		if err != nil {
 			return nil, err
 		}
 	}
+	b.usage.record(name, usageSign)
 
 	return &logical.Response{
 		Data: map[string]interface{}{
-			"signature": outputSignature.String(),
+			"signature":      output,
+			"hash_algorithm": hashAlgorithmName(hashFunc),
 		},
 	}, nil
 }
 
-func (b *backend) pathVerifyWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	inputB64 := data.Get("input").(string)
-	input, err := base64.StdEncoding.DecodeString(inputB64)
+// encodeOutput encodes OpenPGP packets with the requested format, blockType
+// is the type of the armor block.
+func encodeOutput(packets []byte, blockType, format string) (string, error) {
+	if format == "base64" {
+		return base64.StdEncoding.EncodeToString(packets), nil
+	}
+
+	var output bytes.Buffer
+	w, err := armor.Encode(&output, blockType, nil)
 	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("unable to decode input as base64: %s", err)), logical.ErrInvalidRequest
+		return "", err
+	}
+	if _, err = w.Write(packets); err != nil {
+		return "", err
 	}
+	if err = w.Close(); err != nil {
+		return "", err
+	}
+	return output.String(), nil
+}
 
-	format := data.Get("format").(string)
-	switch format {
-	case "base64":
-	case "ascii-armor":
-	default:
-		return logical.ErrorResponse(fmt.Sprintf("unsupported encoding format %s; must be \"base64\" or \"ascii-armor\"", format)), nil
+// signDetached returns a detached signature of the message. The signature is
+// built by the plugin since the library does not support all the options.
+// The line endings of the message are canonicalized for text signatures.
+func signDetached(entity *openpgp.Entity, message []byte, sigType packet.SignatureType, options *signatureOptions, config *packet.Config) ([]byte, error) {
+	signingKey, err := entitySigningKey(entity, config.Now(), config.SigningKeyId)
+	if err != nil {
+		return nil, err
 	}
 
-	keyEntry, err := b.key(ctx, req.Storage, data.Get("name").(string))
+	sig := newDetachedSignature(signingKey, config.Hash(), config.Now())
+	sig.SigType = sigType
+	options.applyToSignature(sig)
+	h, err := sig.PrepareSign(config)
+	if err != nil {
+		return nil, err
+	}
+	// The hash trailer written when signing must not be canonicalized
+	if sigType == packet.SigTypeText {
+		openpgp.NewCanonicalTextHash(h).Write(message)
+	} else {
+		h.Write(message)
+	}
+	if err := sig.Sign(h, signingKey, config); err != nil {
+		return nil, err
+	}
+
+	var signature bytes.Buffer
+	if err := sig.Serialize(&signature); err != nil {
+		return nil, err
+	}
+	return signature.Bytes(), nil
+}
+
+// signCleartext returns the message as a cleartext signed document. The
+// message is dash-escaped and its line endings are canonicalized.
+func signCleartext(entity *openpgp.Entity, message []byte, config *packet.Config) ([]byte, error) {
+	signingKey, err := entitySigningKey(entity, config.Now(), config.SigningKeyId)
+	if err != nil {
+		return nil, err
+	}
+
+	var document bytes.Buffer
+	w, err := clearsign.Encode(&document, signingKey, config)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(message); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+
+	hashFunc, err := cleartextHash(document.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if err := checkUsedHash(hashFunc, config); err != nil {
+		return nil, err
+	}
+	return document.Bytes(), nil
+}
+
+// signInline returns a signed message embedding the message, the equivalent
+// of gpg --sign.
+func signInline(entity *openpgp.Entity, message []byte, compression packet.CompressionAlgo, config *packet.Config) ([]byte, error) {
+	var signed bytes.Buffer
+	var output io.WriteCloser = nopWriteCloser{&signed}
+	if compression != packet.CompressionNone {
+		var err error
+		output, err = packet.SerializeCompressed(output, compression, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	w, err := openpgp.Sign(output, entity, nil, config)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(message); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	if err = output.Close(); err != nil {
+		return nil, err
+	}
+
+	hashFunc, err := messageHash(signed.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if err := checkUsedHash(hashFunc, config); err != nil {
+		return nil, err
+	}
+	return signed.Bytes(), nil
+}
+
+// checkUsedHash returns an error when the library signed with another hash
+// algorithm than the one of the configuration, e.g. when it is not listed in
+// the preferences of the key. The hash algorithm of a signature must be the one
+// checked with checkHashForKey and returned to the client.
+func checkUsedHash(hashFunc crypto.Hash, config *packet.Config) error {
+	if hashFunc != config.Hash() {
+		return fmt.Errorf("%w: %s cannot be used for this signature type, the preferences of the key select %s", errUnsupportedHash, hashAlgorithmName(config.Hash()), hashAlgorithmName(hashFunc))
+	}
+	return nil
+}
+
+// cleartextHash returns the hash algorithm of the signature of a cleartext
+// signed document.
+func cleartextHash(document []byte) (crypto.Hash, error) {
+	block, _ := clearsign.Decode(document)
+	if block == nil {
+		return 0, errors.New("unable to decode the cleartext signed document")
+	}
+	p, err := packet.Read(block.ArmoredSignature.Body)
+	if err != nil {
+		return 0, err
+	}
+	sig, ok := p.(*packet.Signature)
+	if !ok {
+		return 0, errors.New("the cleartext signed document does not contain a signature")
+	}
+	return sig.Hash, nil
+}
+
+// messageHash returns the hash algorithm of the signature of a signed message.
+func messageHash(message []byte) (crypto.Hash, error) {
+	packets := packet.NewReader(bytes.NewReader(message))
+	for {
+		p, err := packets.Next()
+		if err != nil {
+			return 0, err
+		}
+		switch p := p.(type) {
+		case *packet.Compressed:
+			if err := packets.Push(p.Body); err != nil {
+				return 0, err
+			}
+		case *packet.OnePassSignature:
+			return p.Hash, nil
+		}
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func (b *backend) pathVerifyWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	keyEntry, err := b.key(ctx, req.Storage, name)
 	if err != nil {
 		return nil, err
 	}
 	if keyEntry == nil {
 		return logical.ErrorResponse("key not found"), logical.ErrInvalidRequest
 	}
-
-	r := bytes.NewReader(keyEntry.SerializedKey)
-	keyring, err := openpgp.ReadKeyRing(r)
+	entity, err := b.entity(name, keyEntry)
 	if err != nil {
 		return nil, err
 	}
+	keyring := openpgp.EntityList{entity}
+
+	return b.handleBatch(ctx, req, data, func(data *framework.FieldData) (*logical.Response, error) {
+		return b.verify(name, keyring, data)
+	})
+}
+
+// verify verifies the signature of the request with the named key.
+func (b *backend) verify(name string, keyring openpgp.EntityList, data *framework.FieldData) (*logical.Response, error) {
+	request, err := parseVerifyRequest(data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	if request.allSignatures {
+		results, plaintext := request.verifyAll(keyring)
+		b.usage.record(name, usageVerify)
+		return &logical.Response{
+			Data: signaturesResponseData(results, plaintext, request.threshold, nil),
+		}, nil
+	}
 
-	signature := strings.NewReader(data.Get("signature").(string))
-	message := bytes.NewReader(input)
+	result := request.verify(keyring)
+	b.usage.record(name, usageVerify)
+
+	return &logical.Response{
+		Data: result.toResponseData(),
+	}, nil
+}
+
+// verifyRequest is a signature to verify.
+type verifyRequest struct {
+	input         []byte
+	signature     string
+	format        string
+	signatureType string
+	config        *packet.Config
+	// allSignatures is set when every signature must be verified.
+	allSignatures bool
+	// threshold is the number of distinct keys that must have made a valid
+	// signature, 0 when all the signatures must be valid.
+	threshold int
+}
+
+// parseVerifyRequest validates the signature to verify described by the
+// verifyFields.
+func parseVerifyRequest(data *framework.FieldData) (*verifyRequest, error) {
+	input, err := base64.StdEncoding.DecodeString(data.Get("input").(string))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode input as base64: %s", err)
+	}
+
+	format := data.Get("format").(string)
 	switch format {
 	case "base64":
-		decoder := base64.NewDecoder(base64.StdEncoding, signature)
-		_, err = openpgp.CheckDetachedSignature(keyring, message, decoder, nil)
 	case "ascii-armor":
-		_, err = openpgp.CheckArmoredDetachedSignature(keyring, message, signature, nil)
+	default:
+		return nil, fmt.Errorf("unsupported encoding format %s; must be \"base64\" or \"ascii-armor\"", format)
 	}
 
-	resp := &logical.Response{
-		Data: map[string]interface{}{
-			"valid": err == nil,
-		},
+	signatureType := data.Get("signature_type").(string)
+	if signatureType == "" {
+		signatureType = "detached"
+		if len(input) == 0 {
+			signatureType = "inline"
+		}
 	}
+	switch signatureType {
+	case "detached":
+	case "cleartext", "inline":
+		if len(input) > 0 {
+			return nil, fmt.Errorf("the input must not be provided for %s signatures, it is part of the signed message", signatureType)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported signature type %s; must be \"detached\", \"cleartext\" or \"inline\"", signatureType)
+	}
+
+	var referenceTime time.Time
+	if t, ok := data.GetOk("reference_time"); ok {
+		referenceTime = t.(time.Time)
+	}
+
+	threshold := data.Get("threshold").(int)
+	if threshold < 0 {
+		return nil, fmt.Errorf("the threshold must not be negative")
+	}
+	allSignatures := data.Get("all_signatures").(bool) || threshold > 0
+	if allSignatures && signatureType == "inline" {
+		return nil, fmt.Errorf("all the signatures can only be verified for detached and cleartext signatures")
+	}
+
+	return &verifyRequest{
+		input:         input,
+		signature:     data.Get("signature").(string),
+		format:        format,
+		signatureType: signatureType,
+		config:        verificationConfig(data.Get("known_notations").([]string), referenceTime),
+		allSignatures: allSignatures,
+		threshold:     threshold,
+	}, nil
+}
+
+// verify verifies the signature with the keys of the key ring.
+func (r *verifyRequest) verify(keyring openpgp.EntityList) *verification {
+	switch r.signatureType {
+	case "cleartext":
+		document, err := r.cleartextDocument()
+		if err != nil {
+			return malformedSignature()
+		}
+		return verifyCleartext(keyring, document, r.config)
+	case "inline":
+		message, err := decodeSignature(r.signature, r.format, "PGP MESSAGE")
+		if err != nil {
+			return malformedSignature()
+		}
+		return verifyInline(keyring, message, r.config)
+	default:
+		packets, err := decodeSignature(r.signature, r.format, openpgp.SignatureType)
+		if err != nil {
+			return malformedSignature()
+		}
+		return verifyDetached(keyring, bytes.NewReader(r.input), packets, r.config)
+	}
+}
 
-	return resp, nil
+// verifyAll verifies every signature of a detached or cleartext signature
+// with the keys of the key ring. The signed text of cleartext signed documents
+// is returned with the results.
+func (r *verifyRequest) verifyAll(keyring openpgp.EntityList) ([]*verification, []byte) {
+	if r.signatureType == "cleartext" {
+		document, err := r.cleartextDocument()
+		if err != nil {
+			return []*verification{malformedSignature()}, nil
+		}
+		return verifyAllCleartext(keyring, document, r.config)
+	}
+
+	packets, err := decodeSignature(r.signature, r.format, openpgp.SignatureType)
+	if err != nil {
+		return []*verification{malformedSignature()}, nil
+	}
+	return verifyAllDetached(keyring, r.input, packets, r.config), nil
+}
+
+// cleartextDocument returns the cleartext signed document to verify.
+func (r *verifyRequest) cleartextDocument() ([]byte, error) {
+	// The cleartext signed document is already armored
+	if r.format == "base64" {
+		return base64.StdEncoding.DecodeString(r.signature)
+	}
+	return []byte(r.signature), nil
 }
 
 const pathSignHelpSyn = "Generate a signature for input data using the named GPG key"
@@ -1,9 +1,17 @@
 package gpg
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
@@ -135,6 +143,14 @@ func TestGPG_SignVerify(t *testing.T) {
 	signature = signRequest(req, "test", false, "")
 	verifyRequest(req, "test", false, true, signature)
 
+	req.Data["algorithm"] = "sha3-256"
+	signature = signRequest(req, "test", false, "")
+	verifyRequest(req, "test", false, true, signature)
+
+	req.Data["algorithm"] = "sha3-512"
+	signature = signRequest(req, "test", false, "")
+	verifyRequest(req, "test", false, true, signature)
+
 	req.Data["algorithm"] = "notexisting"
 	signRequest(req, "test", true, "")
 	delete(req.Data, "algorithm")
@@ -165,3 +181,671 @@ func TestGPG_SignVerify(t *testing.T) {
 	signRequest(req, "test", true, "")
 	verifyRequest(req, "test", true, false, signature)
 }
+
+func TestGPG_SignVerifyCleartext(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"generate": false,
+		"key":      gpgKey,
+	}, false)
+	testAccStepCreateKey(t, b, storage, "other", map[string]interface{}{
+		"real_name": "Vault GPG test",
+	}, false)
+
+	text := "Origin: Debian\n-----BEGIN PGP SIGNATURE-----\nFrom the start\nSHA256:\n 0123456789abcdef 1234 main/binary-amd64/Packages\n"
+
+	sign := func(data map[string]interface{}) *logical.Response {
+		data["signature_type"] = "cleartext"
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "sign/test",
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	verify := func(name string, data map[string]interface{}) *logical.Response {
+		data["signature_type"] = "cleartext"
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "verify/" + name,
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := sign(map[string]interface{}{
+		"input":  base64.StdEncoding.EncodeToString([]byte(text)),
+		"format": "ascii-armor",
+	})
+	if resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+	document := resp.Data["signature"].(string)
+	if !strings.HasPrefix(document, "-----BEGIN PGP SIGNED MESSAGE-----\nHash: SHA256\n\n") {
+		t.Fatalf("unexpected cleartext signed document: %s", document)
+	}
+	if !strings.Contains(document, "\n- -----BEGIN PGP SIGNATURE-----\n") {
+		t.Fatalf("expected the lines starting with a dash to be escaped: %s", document)
+	}
+
+	resp = verify("test", map[string]interface{}{
+		"signature": document,
+		"format":    "ascii-armor",
+	})
+	if resp.Data["valid"] != true {
+		t.Fatalf("expected a valid signature: %#v", resp)
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(resp.Data["plaintext"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != text {
+		t.Fatalf("unexpected recovered text: %q", plaintext)
+	}
+
+	// Canonical line endings, the signature does not depend on them
+	resp = verify("test", map[string]interface{}{
+		"signature": strings.ReplaceAll(document, "\n", "\r\n"),
+		"format":    "ascii-armor",
+	})
+	if resp.Data["valid"] != true {
+		t.Fatalf("expected a valid signature with CRLF line endings: %#v", resp)
+	}
+
+	// Tampered text
+	resp = verify("test", map[string]interface{}{
+		"signature": strings.Replace(document, "Origin: Debian", "Origin: Evil", 1),
+		"format":    "ascii-armor",
+	})
+	if resp.Data["valid"] != false || resp.Data["plaintext"] != nil {
+		t.Fatalf("expected an invalid signature: %#v", resp)
+	}
+
+	// Other key
+	resp = verify("other", map[string]interface{}{
+		"signature": document,
+		"format":    "ascii-armor",
+	})
+	if resp.Data["valid"] != false {
+		t.Fatalf("expected an invalid signature: %#v", resp)
+	}
+
+	// Base64 encoded document
+	resp = sign(map[string]interface{}{
+		"input": base64.StdEncoding.EncodeToString([]byte(text)),
+	})
+	encoded := resp.Data["signature"].(string)
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(decoded), "-----BEGIN PGP SIGNED MESSAGE-----") {
+		t.Fatalf("unexpected cleartext signed document: %s", decoded)
+	}
+	resp = verify("test", map[string]interface{}{
+		"signature": encoded,
+	})
+	if resp.Data["valid"] != true {
+		t.Fatalf("expected a valid signature: %#v", resp)
+	}
+
+	// Not a cleartext signed document
+	resp = verify("test", map[string]interface{}{
+		"signature": text,
+		"format":    "ascii-armor",
+	})
+	if resp.Data["valid"] != false {
+		t.Fatalf("expected an invalid signature: %#v", resp)
+	}
+
+	// The input is part of the document
+	resp = verify("test", map[string]interface{}{
+		"input":     base64.StdEncoding.EncodeToString([]byte(text)),
+		"signature": document,
+		"format":    "ascii-armor",
+	})
+	if !resp.IsError() {
+		t.Fatalf("expected an error: %#v", resp)
+	}
+
+	// Unknown signature type
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "sign/test",
+		Data: map[string]interface{}{
+			"input":          base64.StdEncoding.EncodeToString([]byte(text)),
+			"signature_type": "notexisting",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsError() {
+		t.Fatalf("expected an error: %#v", resp)
+	}
+}
+
+func TestGPG_SignVerifyInline(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"generate": false,
+		"key":      gpgKey,
+	}, false)
+	testAccStepCreateKey(t, b, storage, "other", map[string]interface{}{
+		"real_name": "Vault GPG test",
+	}, false)
+
+	input := base64.StdEncoding.EncodeToString([]byte("the quick brown fox"))
+
+	sign := func(data map[string]interface{}) *logical.Response {
+		data["input"] = input
+		data["signature_type"] = "inline"
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "sign/test",
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	verify := func(name string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "verify/" + name,
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	for _, compression := range []string{"none", "zip", "zlib"} {
+		resp := sign(map[string]interface{}{
+			"compression": compression,
+		})
+		if resp.IsError() {
+			t.Fatal(resp.Error())
+		}
+		message, err := base64.StdEncoding.DecodeString(resp.Data["signature"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := packet.Read(bytes.NewReader(message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch p.(type) {
+		case *packet.OnePassSignature:
+			if compression != "none" {
+				t.Fatalf("expected a compressed message with %s", compression)
+			}
+		case *packet.Compressed:
+			if compression == "none" {
+				t.Fatal("expected an uncompressed message")
+			}
+		default:
+			t.Fatalf("unexpected first packet %T", p)
+		}
+
+		// The type of signature is guessed when no input is given
+		resp = verify("test", map[string]interface{}{
+			"signature": resp.Data["signature"],
+		})
+		if resp.Data["valid"] != true || resp.Data["plaintext"] != input {
+			t.Fatalf("expected a valid signature with %s: %#v", compression, resp)
+		}
+	}
+
+	resp := sign(map[string]interface{}{
+		"format": "ascii-armor",
+	})
+	armored := resp.Data["signature"].(string)
+	if !strings.HasPrefix(armored, "-----BEGIN PGP MESSAGE-----") {
+		t.Fatalf("unexpected armored message: %s", armored)
+	}
+	resp = verify("test", map[string]interface{}{
+		"signature":      armored,
+		"format":         "ascii-armor",
+		"signature_type": "inline",
+	})
+	if resp.Data["valid"] != true || resp.Data["plaintext"] != input {
+		t.Fatalf("expected a valid signature: %#v", resp)
+	}
+
+	// Other key
+	resp = verify("other", map[string]interface{}{
+		"signature": armored,
+		"format":    "ascii-armor",
+	})
+	if resp.Data["valid"] != false || resp.Data["plaintext"] != nil {
+		t.Fatalf("expected an invalid signature: %#v", resp)
+	}
+
+	// Tampered message
+	message, err := base64.StdEncoding.DecodeString(sign(map[string]interface{}{}).Data["signature"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Replace(message, []byte("quick"), []byte("quack"), 1)
+	resp = verify("test", map[string]interface{}{
+		"signature": base64.StdEncoding.EncodeToString(tampered),
+	})
+	if resp.Data["valid"] != false || resp.Data["plaintext"] != nil {
+		t.Fatalf("expected an invalid signature: %#v", resp)
+	}
+
+	// Encrypted messages are not decrypted
+	el, err := openpgp.ReadArmoredKeyRing(strings.NewReader(gpgKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var encrypted bytes.Buffer
+	w, err := openpgp.Encrypt(&encrypted, el, el[0], nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte("the quick brown fox")); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	resp = verify("test", map[string]interface{}{
+		"signature": base64.StdEncoding.EncodeToString(encrypted.Bytes()),
+	})
+	if resp.Data["valid"] != false || resp.Data["plaintext"] != nil {
+		t.Fatalf("expected the encrypted message to be rejected: %#v", resp)
+	}
+
+	// Compression is only supported for inline signed messages
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "sign/test",
+		Data: map[string]interface{}{
+			"input":       input,
+			"compression": "zlib",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsError() {
+		t.Fatalf("expected an error: %#v", resp)
+	}
+}
+
+func TestGPG_SignVerifyTextMode(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"generate": false,
+		"key":      gpgKey,
+	}, false)
+
+	request := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	lf := base64.StdEncoding.EncodeToString([]byte("line one\nline two\n"))
+	crlf := base64.StdEncoding.EncodeToString([]byte("line one\r\nline two\r\n"))
+
+	for _, mode := range []string{"text", "binary"} {
+		resp := request("sign/test", map[string]interface{}{
+			"input": crlf,
+			"mode":  mode,
+		})
+		if resp.IsError() {
+			t.Fatal(resp.Error())
+		}
+		signature := resp.Data["signature"].(string)
+
+		resp = request("verify/test", map[string]interface{}{
+			"input":     crlf,
+			"signature": signature,
+		})
+		if resp.Data["valid"] != true || resp.Data["mode"] != mode {
+			t.Fatalf("expected a valid %s signature: %#v", mode, resp)
+		}
+
+		// Only text signatures do not depend on the line endings
+		resp = request("verify/test", map[string]interface{}{
+			"input":     lf,
+			"signature": signature,
+		})
+		if resp.Data["valid"] != (mode == "text") {
+			t.Fatalf("unexpected verification of the %s signature with LF line endings: %#v", mode, resp)
+		}
+	}
+
+	// Text mode of ascii-armored signatures
+	resp := request("sign/test", map[string]interface{}{
+		"input":  lf,
+		"mode":   "text",
+		"format": "ascii-armor",
+	})
+	resp = request("verify/test", map[string]interface{}{
+		"input":     crlf,
+		"signature": resp.Data["signature"],
+		"format":    "ascii-armor",
+	})
+	if resp.Data["valid"] != true || resp.Data["mode"] != "text" {
+		t.Fatalf("expected a valid text signature: %#v", resp)
+	}
+
+	// Invalid parameters
+	for _, data := range []map[string]interface{}{
+		{"mode": "unknown"},
+		{"mode": "text", "signature_type": "inline"},
+		{"mode": "text", "signature_type": "cleartext"},
+		{"mode": "text", "prehashed": true, "creation_time": "2024-01-02T10:00:00Z", "input": base64.StdEncoding.EncodeToString(make([]byte, 32))},
+	} {
+		if _, ok := data["input"]; !ok {
+			data["input"] = lf
+		}
+		resp = request("sign/test", data)
+		if !resp.IsError() {
+			t.Fatalf("expected an error with %#v: %#v", data, resp)
+		}
+	}
+}
+
+func TestGPG_SignHashAlgorithms(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	// armoredPrivateKey returns a new key generated with the configuration
+	armoredPrivateKey := func(config *packet.Config) string {
+		entity, err := openpgp.NewEntity("Vault GPG test", "", "vault@example.com", config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return testArmoredPrivateKey(t, entity)
+	}
+
+	testAccStepCreateKey(t, b, storage, "rsa", map[string]interface{}{
+		"generate": false,
+		"key":      gpgKey,
+	}, false)
+	testAccStepCreateKey(t, b, storage, "p384", map[string]interface{}{
+		"generate": false,
+		"key": armoredPrivateKey(&packet.Config{
+			Algorithm: packet.PubKeyAlgoECDSA,
+			Curve:     packet.CurveNistP384,
+		}),
+	}, false)
+	testAccStepCreateKey(t, b, storage, "v6", map[string]interface{}{
+		"generate": false,
+		"key": armoredPrivateKey(&packet.Config{
+			Algorithm: packet.PubKeyAlgoEd25519,
+			V6Keys:    true,
+		}),
+	}, false)
+
+	request := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	input := base64.StdEncoding.EncodeToString([]byte("the quick brown fox"))
+
+	for _, tc := range []struct {
+		key           string
+		algorithm     string
+		signatureType string
+		expected      string
+	}{
+		{"rsa", "sha2-224", "detached", "sha2-224"},
+		{"rsa", "sha3-512", "detached", "sha3-512"},
+		{"rsa", "sha2-512", "cleartext", "sha2-512"},
+		// SHA-224 is not used by the library for new signed documents, the
+		// request is refused rather than signed with another algorithm
+		{"rsa", "sha2-224", "cleartext", ""},
+		{"p384", "sha2-384", "detached", "sha2-384"},
+		{"p384", "sha3-512", "detached", "sha3-512"},
+		// The key only prefers SHA2-256, the library falls back to the
+		// first hash algorithm acceptable for the curve
+		{"p384", "sha2-384", "inline", "sha2-384"},
+		{"p384", "sha2-512", "inline", ""},
+		{"v6", "sha3-256", "detached", "sha3-256"},
+		{"v6", "sha3-512", "detached", "sha3-512"},
+		{"v6", "sha2-256", "inline", "sha2-256"},
+	} {
+		resp := request("sign/"+tc.key, map[string]interface{}{
+			"input":          input,
+			"algorithm":      tc.algorithm,
+			"signature_type": tc.signatureType,
+		})
+		if tc.expected == "" {
+			if !resp.IsError() || !strings.Contains(resp.Error().Error(), "the preferences of the key select") {
+				t.Fatalf("expected an error with %s and %s: %#v", tc.key, tc.algorithm, resp)
+			}
+			continue
+		}
+		if resp.IsError() {
+			t.Fatalf("unexpected error with %s and %s: %s", tc.key, tc.algorithm, resp.Error())
+		}
+		if resp.Data["hash_algorithm"] != tc.expected {
+			t.Fatalf("unexpected hash algorithm with %s and %s: %#v", tc.key, tc.algorithm, resp.Data)
+		}
+
+		data := map[string]interface{}{
+			"signature":      resp.Data["signature"],
+			"signature_type": tc.signatureType,
+		}
+		if tc.signatureType == "detached" {
+			data["input"] = input
+		}
+		resp = request("verify/"+tc.key, data)
+		if resp.Data["valid"] != true {
+			t.Fatalf("expected a valid signature with %s and %s: %#v", tc.key, tc.algorithm, resp)
+		}
+	}
+
+	// The digest must be as large as the security level of the curve
+	for _, algorithm := range []string{"sha2-224", "sha2-256", "sha3-256"} {
+		resp := request("sign/p384", map[string]interface{}{
+			"input":     input,
+			"algorithm": algorithm,
+		})
+		if !resp.IsError() {
+			t.Fatalf("expected an error with %s: %#v", algorithm, resp)
+		}
+		resp = request("sign/p384/sessions", map[string]interface{}{
+			"algorithm": algorithm,
+		})
+		if !resp.IsError() {
+			t.Fatalf("expected an error with %s: %#v", algorithm, resp)
+		}
+		resp = request("sign/p384/trailer", map[string]interface{}{
+			"algorithm": algorithm,
+		})
+		if !resp.IsError() {
+			t.Fatalf("expected an error with %s: %#v", algorithm, resp)
+		}
+	}
+	resp := request("sign/v6", map[string]interface{}{
+		"input":     input,
+		"algorithm": "sha2-224",
+	})
+	if !resp.IsError() {
+		t.Fatalf("expected an error: %#v", resp)
+	}
+}
+
+func TestGPG_SignSigningKeyID(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	past := time.Now().Add(-time.Hour)
+	pastConfig := func(lifetime uint32) *packet.Config {
+		return &packet.Config{
+			Algorithm:       packet.PubKeyAlgoEd25519,
+			KeyLifetimeSecs: lifetime,
+			Time: func() time.Time {
+				return past
+			},
+		}
+	}
+	entity, err := openpgp.NewEntity("Vault GPG test", "", "vault@example.com", pastConfig(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pinned, expired and revoked subkeys are older than the default subkey
+	for _, config := range []*packet.Config{pastConfig(0), pastConfig(60), pastConfig(0), {Algorithm: packet.PubKeyAlgoEd25519}} {
+		if err = entity.AddSigningSubkey(config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = entity.RevokeSubkey(&entity.Subkeys[3], packet.KeyRetired, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	keyID := func(key *packet.PublicKey) string {
+		return fmt.Sprintf("%016X", key.KeyId)
+	}
+	encryption, pinned, expired, revoked, newest := entity.Subkeys[0].PublicKey, entity.Subkeys[1].PublicKey,
+		entity.Subkeys[2].PublicKey, entity.Subkeys[3].PublicKey, entity.Subkeys[4].PublicKey
+
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"generate": false,
+		"key":      testArmoredPrivateKey(t, entity),
+	}, false)
+
+	request := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	input := base64.StdEncoding.EncodeToString([]byte("the quick brown fox"))
+	signingKeyOf := func(data map[string]interface{}) string {
+		resp := request("sign/test", data)
+		if resp.IsError() {
+			t.Fatal(resp.Error())
+		}
+		verifyData := map[string]interface{}{
+			"signature":      resp.Data["signature"],
+			"signature_type": data["signature_type"],
+		}
+		if data["signature_type"] == "detached" {
+			verifyData["input"] = input
+		}
+		resp = request("verify/test", verifyData)
+		if resp.Data["valid"] != true {
+			t.Fatalf("expected a valid signature with %#v: %#v", data, resp.Data)
+		}
+		return strings.ToUpper(resp.Data["key_id"].(string))
+	}
+
+	for _, signatureType := range []string{"detached", "cleartext", "inline"} {
+		if signingKey := signingKeyOf(map[string]interface{}{
+			"input":          input,
+			"signature_type": signatureType,
+		}); signingKey != keyID(newest) {
+			t.Fatalf("expected the newest subkey to sign the %s signature, got %s", signatureType, signingKey)
+		}
+		for _, key := range []*packet.PublicKey{pinned, entity.PrimaryKey} {
+			if signingKey := signingKeyOf(map[string]interface{}{
+				"input":          input,
+				"signature_type": signatureType,
+				"signing_key_id": "0x" + keyID(key),
+			}); signingKey != keyID(key) {
+				t.Fatalf("expected the key %s to sign the %s signature, got %s", keyID(key), signatureType, signingKey)
+			}
+		}
+	}
+
+	// Prehashed signature of the pinned subkey, the primary key is refused
+	// as it certifies the key
+	if resp := request("keys/test/config", map[string]interface{}{"allow_prehashed": true}); resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+	if resp := request("sign/test/trailer", map[string]interface{}{
+		"signing_key_id": keyID(entity.PrimaryKey),
+	}); !resp.IsError() || !strings.Contains(resp.Error().Error(), "certifies") {
+		t.Fatalf("expected the primary key to be refused: %#v", resp)
+	}
+	resp := request("sign/test/trailer", map[string]interface{}{
+		"signing_key_id": keyID(pinned),
+	})
+	if resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+	trailer, err := base64.StdEncoding.DecodeString(resp.Data["hash_trailer"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.New()
+	h.Write([]byte("the quick brown fox"))
+	h.Write(trailer)
+	if signingKey := signingKeyOf(map[string]interface{}{
+		"input":          base64.StdEncoding.EncodeToString(h.Sum(nil)),
+		"signature_type": "detached",
+		"prehashed":      true,
+		"creation_time":  resp.Data["creation_time"].(time.Time).Unix(),
+		"signing_key_id": keyID(pinned),
+	}); signingKey != keyID(pinned) {
+		t.Fatalf("expected the pinned subkey to sign the prehashed signature, got %s", signingKey)
+	}
+
+	for expected, signingKeyID := range map[string]string{
+		"is expired":           keyID(expired),
+		"is revoked":           keyID(revoked),
+		"is not a signing key": keyID(encryption),
+		"no key with":          "0123456789ABCDEF",
+		"must be 16":           "0123",
+	} {
+		for _, path := range []string{"sign/test", "sign/test/trailer"} {
+			resp = request(path, map[string]interface{}{
+				"input":          input,
+				"signing_key_id": signingKeyID,
+			})
+			if !resp.IsError() || !strings.Contains(resp.Error().Error(), expected) {
+				t.Fatalf("expected an error containing %q for %s: %#v", expected, path, resp)
+			}
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package gpg
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// keyEntryUpgrades contains the migrations of the key entries, the migration
+// at index i upgrades an entry from version i to version i+1. Entries written
+// before the storage was versioned are version 0.
+var keyEntryUpgrades = []func(*keyEntry) error{
+	// Version 1 only introduces the version marker
+	func(*keyEntry) error { return nil },
+}
+
+// latestStorageVersion is the version of the storage format written by this
+// version of the plugin.
+var latestStorageVersion = len(keyEntryUpgrades)
+
+func pathStorageVersion(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "storage-version",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathStorageVersionRead,
+			},
+		},
+		HelpSynopsis:    pathStorageVersionHelpSyn,
+		HelpDescription: pathStorageVersionHelpDesc,
+	}
+}
+
+func (b *backend) storageVersion(ctx context.Context, s logical.Storage) (int, error) {
+	entry, err := s.Get(ctx, "config/storage-version")
+	if err != nil {
+		return 0, err
+	}
+	if entry == nil {
+		return 0, nil
+	}
+
+	var result storageVersionEntry
+	if err := entry.DecodeJSON(&result); err != nil {
+		return 0, err
+	}
+
+	return result.Version, nil
+}
+
+func (b *backend) pathStorageVersionRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	version, err := b.storageVersion(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"version":        version,
+			"latest_version": latestStorageVersion,
+		},
+	}, nil
+}
+
+// upgradeKeyEntry migrates a key entry to the latest storage version. It
+// returns true when the entry has been modified.
+func upgradeKeyEntry(entry *keyEntry) (bool, error) {
+	if entry.Version > latestStorageVersion {
+		return false, fmt.Errorf("the key is stored with version %d of the storage format which is not supported, latest supported version is %d", entry.Version, latestStorageVersion)
+	}
+
+	upgraded := false
+	for entry.Version < latestStorageVersion {
+		if err := keyEntryUpgrades[entry.Version](entry); err != nil {
+			return false, err
+		}
+		entry.Version++
+		upgraded = true
+	}
+
+	return upgraded, nil
+}
+
+// upgradeStorage migrates in place the entries stored with an older version of
+// the storage format. It is called by Vault when the mount is initialized.
+func (b *backend) upgradeStorage(ctx context.Context, req *logical.InitializationRequest) error {
+	version, err := b.storageVersion(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+	if version > latestStorageVersion {
+		return fmt.Errorf("the storage is at version %d which is not supported, latest supported version is %d", version, latestStorageVersion)
+	}
+	if version == latestStorageVersion {
+		return nil
+	}
+
+	names, err := req.Storage.List(ctx, "key/")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := b.upgradeStoredKey(ctx, req.Storage, name); err != nil {
+			return fmt.Errorf("failed to upgrade the key %s: %w", name, err)
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON("config/storage-version", &storageVersionEntry{
+		Version: latestStorageVersion,
+	})
+	if err != nil {
+		return err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return err
+	}
+	b.Logger().Info("storage upgraded", "from", version, "to", latestStorageVersion)
+
+	return nil
+}
+
+func (b *backend) upgradeStoredKey(ctx context.Context, storage logical.Storage, name string) error {
+	lock := locksutil.LockForKey(b.keyLocks, name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	entry, err := storage.Get(ctx, "key/"+name)
+	if err != nil {
+		return err
+	}
+	// The key has been deleted in the meantime
+	if entry == nil {
+		return nil
+	}
+
+	var result keyEntry
+	if err := entry.DecodeJSON(&result); err != nil {
+		return err
+	}
+	upgraded, err := upgradeKeyEntry(&result)
+	if err != nil || !upgraded {
+		return err
+	}
+
+	return b.storeKeyEntry(ctx, storage, name, &result)
+}
+
+type storageVersionEntry struct {
+	Version int
+}
+
+const pathStorageVersionHelpSyn = "Read the version of the storage format"
+const pathStorageVersionHelpDesc = `
+This path returns the version of the storage format used by the mount and the
+latest version supported by the plugin. The storage is upgraded when the mount
+is initialized.
+`
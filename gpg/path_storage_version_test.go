@@ -0,0 +1,110 @@
+package gpg
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestGPG_StorageUpgrade(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	readVersion := func() int {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.ReadOperation,
+			Path:      "storage-version",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Data["latest_version"] != latestStorageVersion {
+			t.Fatalf("unexpected latest version: %#v", resp.Data)
+		}
+		return resp.Data["version"].(int)
+	}
+	rawVersion := func(name string) int {
+		entry, err := storage.Get(context.Background(), "key/"+name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var raw struct {
+			Version *int
+		}
+		if err := entry.DecodeJSON(&raw); err != nil {
+			t.Fatal(err)
+		}
+		if raw.Version == nil {
+			return 0
+		}
+		return *raw.Version
+	}
+
+	// Key written before the storage was versioned
+	testAccStepCreateKey(t, b, storage, "legacy", map[string]interface{}{
+		"generate":   false,
+		"key":        gpgKey,
+		"exportable": true,
+	}, false)
+	entry, err := b.key(context.Background(), storage, "legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := logical.StorageEntryJSON("key/legacy", map[string]interface{}{
+		"SerializedKey": entry.SerializedKey,
+		"Exportable":    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(context.Background(), legacy); err != nil {
+		t.Fatal(err)
+	}
+	if readVersion() != 0 || rawVersion("legacy") != 0 {
+		t.Fatal("expected the storage to not be versioned")
+	}
+
+	// Entries not migrated yet can still be used
+	testAccStepReadKey(t, b, storage, "legacy", map[string]interface{}{
+		"key_bits": 2048,
+	})
+
+	if err := b.Initialize(context.Background(), &logical.InitializationRequest{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	if readVersion() != latestStorageVersion {
+		t.Fatal("expected the storage to be upgraded")
+	}
+	if rawVersion("legacy") != latestStorageVersion {
+		t.Fatal("expected the key entry to be migrated in place")
+	}
+	testAccStepReadKey(t, b, storage, "legacy", map[string]interface{}{
+		"key_bits": 2048,
+	})
+
+	// New entries are written with the latest version
+	testAccStepCreateKey(t, b, storage, "new", map[string]interface{}{
+		"generate": false,
+		"key":      gpgKey,
+	}, false)
+	if rawVersion("new") != latestStorageVersion {
+		t.Fatal("expected the new key entry to be versioned")
+	}
+
+	// Entries written by a newer version of the plugin are rejected
+	newer, err := logical.StorageEntryJSON("key/newer", map[string]interface{}{
+		"Version":       latestStorageVersion + 1,
+		"SerializedKey": entry.SerializedKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(context.Background(), newer); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.key(context.Background(), storage, "newer"); err == nil {
+		t.Fatal("expected an error for an unsupported storage version")
+	}
+}
@@ -0,0 +1,132 @@
+package gpg
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathVerifyKeyring(b *backend) *framework.Path {
+	path := &framework.Path{
+		Pattern: "verify",
+		Fields: map[string]*framework.FieldSchema{
+			"public_keys": {
+				Type:        framework.TypeString,
+				Description: "ASCII-armored public keys to verify the signature with. By default, the signature is verified with the key of the mount matching its issuer.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathVerifyKeyringWrite,
+			},
+		},
+		HelpSynopsis:    pathVerifyKeyringHelpSyn,
+		HelpDescription: pathVerifyKeyringHelpDesc,
+	}
+	for name, schema := range verifyFields {
+		path.Fields[name] = schema
+	}
+	return path
+}
+
+func (b *backend) pathVerifyKeyringWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	request, err := parseVerifyRequest(data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	if publicKeys := data.Get("public_keys").(string); publicKeys != "" {
+		keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(publicKeys))
+		if err != nil {
+			return logical.ErrorResponse("unable to read the public keys: " + err.Error()), logical.ErrInvalidRequest
+		}
+		if request.allSignatures {
+			results, plaintext := request.verifyAll(keyring)
+			return &logical.Response{
+				Data: signaturesResponseData(results, plaintext, request.threshold, nil),
+			}, nil
+		}
+		return &logical.Response{
+			Data: request.verify(keyring).toResponseData(),
+		}, nil
+	}
+
+	keyring, names, err := b.mountKeyring(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.allSignatures {
+		results, plaintext := request.verifyAll(keyring)
+		recorded := make(map[string]bool)
+		for _, result := range results {
+			if result.signer == nil {
+				continue
+			}
+			if name := names[result.signer.Entity]; !recorded[name] {
+				b.usage.record(name, usageVerify)
+				recorded[name] = true
+			}
+		}
+		return &logical.Response{
+			Data: signaturesResponseData(results, plaintext, request.threshold, func(result *verification) string {
+				return names[result.signer.Entity]
+			}),
+		}, nil
+	}
+
+	result := request.verify(keyring)
+
+	resp := &logical.Response{
+		Data: result.toResponseData(),
+	}
+	if result.signer != nil {
+		name := names[result.signer.Entity]
+		b.usage.record(name, usageVerify)
+		resp.Data["key_name"] = name
+	}
+	return resp, nil
+}
+
+// mountKeyring returns a key ring with all the keys of the mount, sorted by
+// name, and the names of their entities.
+func (b *backend) mountKeyring(ctx context.Context, storage logical.Storage) (openpgp.EntityList, map[*openpgp.Entity]string, error) {
+	names, err := storage.List(ctx, "key/")
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(names)
+
+	var keyring openpgp.EntityList
+	entityNames := make(map[*openpgp.Entity]string, len(names))
+	for _, name := range names {
+		entry, err := b.key(ctx, storage, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		// The key has been deleted since the listing
+		if entry == nil {
+			continue
+		}
+		entity, err := b.entity(name, entry)
+		if err != nil {
+			return nil, nil, err
+		}
+		keyring = append(keyring, entity)
+		entityNames[entity] = name
+	}
+
+	return keyring, entityNames, nil
+}
+
+const pathVerifyKeyringHelpSyn = "Verify a signature with supplied public keys or the keys of the mount"
+const pathVerifyKeyringHelpDesc = `
+This path verifies a signature with the ASCII-armored public keys given in the
+request. When no public keys are given, the signature is verified with the key
+of the mount matching the issuer of the signature and the name of this key is
+returned.
+`
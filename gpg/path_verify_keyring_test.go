@@ -0,0 +1,140 @@
+package gpg
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestGPG_VerifyKeyring(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	newEntity := func() *openpgp.Entity {
+		entity, err := openpgp.NewEntity("Vault GPG test", "", "vault@example.com", &packet.Config{
+			Algorithm: packet.PubKeyAlgoEd25519,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return entity
+	}
+
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"generate": false,
+		"key":      gpgKey,
+	}, false)
+	testAccStepCreateKey(t, b, storage, "other", map[string]interface{}{
+		"generate": false,
+		"key":      testArmoredPrivateKey(t, newEntity()),
+	}, false)
+
+	request := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	input := base64.StdEncoding.EncodeToString([]byte("the quick brown fox"))
+
+	// Keys of the mount matched by issuer
+	for _, name := range []string{"test", "other"} {
+		for _, signatureType := range []string{"detached", "cleartext", "inline"} {
+			resp := request("sign/"+name, map[string]interface{}{
+				"input":          input,
+				"signature_type": signatureType,
+			})
+			if resp.IsError() {
+				t.Fatal(resp.Error())
+			}
+			data := map[string]interface{}{
+				"signature":      resp.Data["signature"],
+				"signature_type": signatureType,
+			}
+			if signatureType == "detached" {
+				data["input"] = input
+			}
+			resp = request("verify", data)
+			if resp.Data["valid"] != true || resp.Data["key_name"] != name {
+				t.Fatalf("expected a valid %s signature of %s: %#v", signatureType, name, resp.Data)
+			}
+		}
+	}
+	if b.usage.get("other").Counts[usageVerify] != 3 {
+		t.Fatalf("expected the verifications to be recorded: %#v", b.usage.get("other"))
+	}
+
+	// Signature of a key that is not in the mount
+	vendor := newEntity()
+	var signature bytes.Buffer
+	if err := openpgp.DetachSign(&signature, vendor, strings.NewReader("the quick brown fox"), nil); err != nil {
+		t.Fatal(err)
+	}
+	resp := request("verify", map[string]interface{}{
+		"input":     input,
+		"signature": base64.StdEncoding.EncodeToString(signature.Bytes()),
+	})
+	if resp.Data["valid"] != false || resp.Data["reason"] != reasonUnknownIssuer || resp.Data["key_name"] != nil {
+		t.Fatalf("expected an unknown issuer: %#v", resp.Data)
+	}
+
+	// Supplied public keys
+	var publicKeys bytes.Buffer
+	w, err := armor.Encode(&publicKeys, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entity := range []*openpgp.Entity{newEntity(), vendor} {
+		if err = entity.Serialize(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	resp = request("verify", map[string]interface{}{
+		"input":       input,
+		"signature":   base64.StdEncoding.EncodeToString(signature.Bytes()),
+		"public_keys": publicKeys.String(),
+	})
+	if resp.Data["valid"] != true || resp.Data["key_name"] != nil {
+		t.Fatalf("expected a valid signature: %#v", resp.Data)
+	}
+
+	// The keys of the mount are not used with supplied public keys
+	resp = request("sign/test", map[string]interface{}{
+		"input": input,
+	})
+	resp = request("verify", map[string]interface{}{
+		"input":       input,
+		"signature":   resp.Data["signature"],
+		"public_keys": publicKeys.String(),
+	})
+	if resp.Data["valid"] != false || resp.Data["reason"] != reasonUnknownIssuer {
+		t.Fatalf("expected an unknown issuer: %#v", resp.Data)
+	}
+
+	// Invalid public keys
+	resp = request("verify", map[string]interface{}{
+		"input":       input,
+		"signature":   base64.StdEncoding.EncodeToString(signature.Bytes()),
+		"public_keys": "not a key",
+	})
+	if !resp.IsError() {
+		t.Fatalf("expected an error: %#v", resp)
+	}
+}
@@ -0,0 +1,145 @@
+package gpg
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/hashicorp/vault/sdk/framework"
+)
+
+// notationNameRegex matches the names of the notations in the user namespace,
+// names without a domain are reserved to the IETF.
+var notationNameRegex = regexp.MustCompile(`^[^@\s=]+@(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?\.)+[a-zA-Z]{2,}$`)
+
+// signatureOptionsFields are the fields of the optional subpackets that can be
+// set on the signatures.
+var signatureOptionsFields = map[string]*framework.FieldSchema{
+	"notations": {
+		Type:        framework.TypeKVPairs,
+		Description: "Human-readable notations to add to the signature, for example build-id@example.com=42. Names must be in the name@domain form.",
+	},
+	"critical_notations": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Names of the notations that must be flagged as critical. Verifiers reject signatures with critical notations they do not know.",
+	},
+	"policy_uri": {
+		Type:        framework.TypeString,
+		Description: "URI of the policy under which the signature was issued. Only supported for detached signatures.",
+	},
+	"signer_user_id": {
+		Type:        framework.TypeString,
+		Description: "User ID of the key responsible for the signature. Must be one of the identities of the key. Only supported for detached signatures.",
+	},
+	"expiration": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Duration after the creation time after which the signature expires. By default, the signature does not expire.",
+	},
+}
+
+// signatureOptions are the optional subpackets set on the signatures.
+type signatureOptions struct {
+	notations    []*packet.Notation
+	policyURI    string
+	signerUserID string
+	lifetime     uint32
+}
+
+// parseSignatureOptions validates the signature options requested.
+func parseSignatureOptions(data *framework.FieldData) (*signatureOptions, error) {
+	options := &signatureOptions{
+		policyURI:    data.Get("policy_uri").(string),
+		signerUserID: data.Get("signer_user_id").(string),
+	}
+
+	notations := data.Get("notations").(map[string]string)
+	critical := make(map[string]bool)
+	for _, name := range data.Get("critical_notations").([]string) {
+		if _, ok := notations[name]; !ok {
+			return nil, fmt.Errorf("the critical notation %s is not part of the notations", name)
+		}
+		critical[name] = true
+	}
+	names := make([]string, 0, len(notations))
+	for name := range notations {
+		if !notationNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("invalid notation name %s, names must be in the name@domain form", name)
+		}
+		names = append(names, name)
+	}
+	// The order of the subpackets is kept stable between requests
+	sort.Strings(names)
+	for _, name := range names {
+		options.notations = append(options.notations, &packet.Notation{
+			Name:            name,
+			Value:           []byte(notations[name]),
+			IsCritical:      critical[name],
+			IsHumanReadable: true,
+		})
+	}
+
+	if options.policyURI != "" {
+		u, err := url.Parse(options.policyURI)
+		if err != nil || u.Scheme == "" {
+			return nil, fmt.Errorf("invalid policy URI %s", options.policyURI)
+		}
+	}
+
+	expiration := data.Get("expiration").(int)
+	if expiration < 0 || expiration > math.MaxUint32 {
+		return nil, fmt.Errorf("invalid expiration %d", expiration)
+	}
+	options.lifetime = uint32(expiration)
+
+	return options, nil
+}
+
+// validateFor checks the options can be applied to a signature of the
+// entity.
+func (o *signatureOptions) validateFor(entity *openpgp.Entity, signatureType string) error {
+	if signatureType != "detached" && (o.policyURI != "" || o.signerUserID != "") {
+		return fmt.Errorf("the policy URI and the signer user ID are only supported for detached signatures")
+	}
+	if o.signerUserID != "" {
+		if _, ok := entity.Identities[o.signerUserID]; !ok {
+			return fmt.Errorf("the signer user ID %s is not an identity of the key", o.signerUserID)
+		}
+	}
+	return nil
+}
+
+// isSet returns true when at least one option is requested.
+func (o *signatureOptions) isSet() bool {
+	return len(o.notations) > 0 || o.policyURI != "" || o.signerUserID != "" || o.lifetime != 0
+}
+
+// applyTo sets the options supported by the library on the configuration
+// used to generate the signatures.
+func (o *signatureOptions) applyTo(config *packet.Config, creationTime time.Time) {
+	config.SignatureNotations = o.notations
+	config.SigLifetimeSecs = o.lifetime
+	if !creationTime.IsZero() {
+		config.Time = func() time.Time {
+			return creationTime
+		}
+	}
+}
+
+// applyToSignature sets the options on a signature built by the plugin.
+func (o *signatureOptions) applyToSignature(sig *packet.Signature) {
+	sig.Notations = o.notations
+	if o.lifetime != 0 {
+		sig.SigLifetimeSecs = &o.lifetime
+	}
+	if o.policyURI != "" {
+		sig.PolicyURI = o.policyURI
+	}
+	if o.signerUserID != "" {
+		sig.SignerUserId = &o.signerUserID
+	}
+}
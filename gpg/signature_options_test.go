@@ -0,0 +1,191 @@
+package gpg
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestGPG_SignatureOptions(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"generate": false,
+		"key":      gpgKey,
+	}, false)
+
+	input := base64.StdEncoding.EncodeToString([]byte("the quick brown fox"))
+
+	request := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	readSignature := func(encoded string) *packet.Signature {
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets := packet.NewReader(bytes.NewReader(raw))
+		for {
+			p, err := packets.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sig, ok := p.(*packet.Signature); ok {
+				return sig
+			}
+		}
+	}
+
+	creationTime := time.Now().Add(-10 * time.Minute).Truncate(time.Second)
+	resp := request("sign/test", map[string]interface{}{
+		"input": input,
+		"notations": map[string]interface{}{
+			"build-id@example.com": "42",
+			"commit@example.com":   "0123abcd",
+		},
+		"critical_notations": "commit@example.com",
+		"policy_uri":         "https://example.com/policy",
+		"signer_user_id":     "Vault (Comment) <vault@example.com>",
+		"expiration":         "1h",
+		"creation_time":      creationTime.Format(time.RFC3339),
+	})
+	if resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+	signature := resp.Data["signature"].(string)
+
+	sig := readSignature(signature)
+	if !sig.CreationTime.Equal(creationTime) {
+		t.Fatalf("unexpected creation time %s, expected %s", sig.CreationTime, creationTime)
+	}
+	if sig.SigLifetimeSecs == nil || *sig.SigLifetimeSecs != 3600 {
+		t.Fatalf("unexpected signature lifetime %v", sig.SigLifetimeSecs)
+	}
+	if sig.PolicyURI != "https://example.com/policy" {
+		t.Fatalf("unexpected policy URI %s", sig.PolicyURI)
+	}
+	if sig.SignerUserId == nil || *sig.SignerUserId != "Vault (Comment) <vault@example.com>" {
+		t.Fatalf("unexpected signer user ID %v", sig.SignerUserId)
+	}
+	notations := make(map[string]*packet.Notation)
+	for _, notation := range sig.Notations {
+		notations[notation.Name] = notation
+	}
+	if n := notations["build-id@example.com"]; n == nil || string(n.Value) != "42" || n.IsCritical || !n.IsHumanReadable {
+		t.Fatalf("unexpected notation %#v", n)
+	}
+	if n := notations["commit@example.com"]; n == nil || string(n.Value) != "0123abcd" || !n.IsCritical {
+		t.Fatalf("unexpected notation %#v", n)
+	}
+
+	// Critical notations must be known by the verifier
+	resp = request("verify/test", map[string]interface{}{
+		"input":     input,
+		"signature": signature,
+	})
+	if resp.Data["valid"] != false {
+		t.Fatalf("expected the signature to be rejected because of its critical notation: %#v", resp)
+	}
+	resp = request("verify/test", map[string]interface{}{
+		"input":           input,
+		"signature":       signature,
+		"known_notations": "commit@example.com",
+	})
+	if resp.Data["valid"] != true {
+		t.Fatalf("expected a valid signature: %#v", resp)
+	}
+
+	// Expired signature
+	resp = request("sign/test", map[string]interface{}{
+		"input":         input,
+		"expiration":    "1h",
+		"creation_time": time.Now().Add(-2 * time.Hour).Unix(),
+	})
+	resp = request("verify/test", map[string]interface{}{
+		"input":     input,
+		"signature": resp.Data["signature"],
+	})
+	if resp.Data["valid"] != false {
+		t.Fatalf("expected the expired signature to be rejected: %#v", resp)
+	}
+
+	// Options supported by inline signed messages
+	resp = request("sign/test", map[string]interface{}{
+		"input":          input,
+		"signature_type": "inline",
+		"notations":      "build-id@example.com=42",
+		"expiration":     "1h",
+	})
+	if resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+	raw, err := base64.StdEncoding.DecodeString(resp.Data["signature"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	el, err := openpgp.ReadArmoredKeyRing(bytes.NewReader([]byte(gpgKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err := openpgp.ReadMessage(bytes.NewReader(raw), el, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = md.UnverifiedBody.Read(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, err = md.UnverifiedBody.Read(make([]byte, 100)); err != nil {
+			break
+		}
+	}
+	if md.SignatureError != nil || md.Signature == nil {
+		t.Fatalf("expected a valid signature: %v", md.SignatureError)
+	}
+	if len(md.Signature.Notations) == 0 || md.Signature.Notations[0].Name != "build-id@example.com" {
+		t.Fatalf("expected the notation to be set: %#v", md.Signature.Notations)
+	}
+
+	// Invalid options
+	for _, data := range []map[string]interface{}{
+		{"notations": "build-id=42"},
+		{"notations": "build-id@example=42"},
+		{"notations": "build-id@example.com=42", "critical_notations": "commit@example.com"},
+		{"policy_uri": "not an uri"},
+		{"signer_user_id": "Someone <someone@example.com>"},
+		{"policy_uri": "https://example.com/policy", "signature_type": "cleartext"},
+		{"signer_user_id": "Vault (Comment) <vault@example.com>", "signature_type": "inline"},
+		{"expiration": "-1h"},
+	} {
+		data["input"] = input
+		resp = request("sign/test", data)
+		if !resp.IsError() {
+			t.Fatalf("expected an error with %#v: %#v", data, resp)
+		}
+	}
+	resp = request("sign/test", map[string]interface{}{
+		"input":         base64.StdEncoding.EncodeToString(make([]byte, 32)),
+		"prehashed":     true,
+		"creation_time": creationTime.Unix(),
+		"notations":     "build-id@example.com=42",
+	})
+	if !resp.IsError() {
+		t.Fatalf("expected an error: %#v", resp)
+	}
+}
@@ -0,0 +1,367 @@
+package gpg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// Reasons returned when a signature is not valid.
+const (
+	reasonMalformed               = "malformed"
+	reasonUnknownIssuer           = "unknown_issuer"
+	reasonBadSignature            = "bad_signature"
+	reasonKeyExpired              = "key_expired"
+	reasonKeyRevoked              = "key_revoked"
+	reasonSignatureExpired        = "signature_expired"
+	reasonWeakHash                = "weak_hash"
+	reasonUnknownCriticalNotation = "unknown_critical_notation"
+)
+
+// verification is the result of the verification of a signature.
+type verification struct {
+	// signature is the signature packet, nil when the signature cannot be
+	// parsed.
+	signature *packet.Signature
+	// signer is the key matching the issuer of the signature, nil when the
+	// issuer is unknown.
+	signer *openpgp.Key
+	// reason is the reason why the signature is not valid, empty when the
+	// signature is valid.
+	reason string
+	// plaintext is the signed data of cleartext signed documents and inline
+	// signed messages, only set when the signature is valid.
+	plaintext []byte
+}
+
+func malformedSignature() *verification {
+	return &verification{reason: reasonMalformed}
+}
+
+func (v *verification) valid() bool {
+	return v.reason == ""
+}
+
+func (v *verification) toResponseData() map[string]interface{} {
+	data := map[string]interface{}{
+		"valid": v.valid(),
+	}
+	if !v.valid() {
+		data["reason"] = v.reason
+	}
+	if v.plaintext != nil {
+		data["plaintext"] = base64.StdEncoding.EncodeToString(v.plaintext)
+	}
+	if v.signature == nil {
+		return data
+	}
+
+	sig := v.signature
+	if sig.IssuerKeyId != nil {
+		data["key_id"] = fmt.Sprintf("%016x", *sig.IssuerKeyId)
+	}
+	data["creation_time"] = sig.CreationTime.UTC()
+	data["hash_algorithm"] = hashAlgorithmName(sig.Hash)
+	data["mode"] = signatureMode(sig.SigType)
+	notations := make([]map[string]interface{}, 0, len(sig.Notations))
+	for _, notation := range sig.Notations {
+		value := string(notation.Value)
+		if !notation.IsHumanReadable {
+			value = base64.StdEncoding.EncodeToString(notation.Value)
+		}
+		notations = append(notations, map[string]interface{}{
+			"name":           notation.Name,
+			"value":          value,
+			"critical":       notation.IsCritical,
+			"human_readable": notation.IsHumanReadable,
+		})
+	}
+	data["notations"] = notations
+
+	if v.signer != nil {
+		data["fingerprint"] = hex.EncodeToString(v.signer.Entity.PrimaryKey.Fingerprint)
+		if v.signer.PublicKey != v.signer.Entity.PrimaryKey {
+			data["subkey_fingerprint"] = hex.EncodeToString(v.signer.PublicKey.Fingerprint)
+		}
+	}
+
+	return data
+}
+
+// signatureMode returns the mode of a signature as named by the sign
+// endpoint.
+func signatureMode(sigType packet.SignatureType) string {
+	if sigType == packet.SigTypeText {
+		return "text"
+	}
+	return "binary"
+}
+
+// verificationConfig returns the configuration used to verify signatures as
+// of the reference time.
+func verificationConfig(knownNotations []string, referenceTime time.Time) *packet.Config {
+	config := &packet.Config{
+		KnownNotations: make(map[string]bool),
+	}
+	for _, name := range knownNotations {
+		config.KnownNotations[name] = true
+	}
+	if !referenceTime.IsZero() {
+		config.Time = func() time.Time {
+			return referenceTime
+		}
+	}
+	return config
+}
+
+// decodeSignature returns the OpenPGP packets of a signature encoded with the
+// format, blockType is the expected type of the armor block.
+func decodeSignature(signature, format, blockType string) ([]byte, error) {
+	if format == "base64" {
+		return base64.StdEncoding.DecodeString(signature)
+	}
+
+	block, err := armor.Decode(strings.NewReader(signature))
+	if err != nil {
+		return nil, err
+	}
+	if block.Type != blockType {
+		return nil, fmt.Errorf("unexpected armor block type %s", block.Type)
+	}
+	return io.ReadAll(block.Body)
+}
+
+// verifyDetached verifies a detached signature of the input.
+func verifyDetached(keyring openpgp.KeyRing, input io.Reader, signature []byte, config *packet.Config) *verification {
+	p, err := packet.Read(bytes.NewReader(signature))
+	if err != nil {
+		return malformedSignature()
+	}
+	sig, ok := p.(*packet.Signature)
+	if !ok || sig.IssuerKeyId == nil {
+		return malformedSignature()
+	}
+
+	result := &verification{
+		signature: sig,
+		signer:    issuerKey(keyring, sig),
+	}
+	if !isStrongHash(sig) {
+		result.reason = reasonWeakHash
+		return result
+	}
+	// The key ring may have several keys with the key ID of the issuer, the
+	// signature is only checked against the key reported as its signer
+	if result.signer == nil {
+		result.reason = reasonUnknownIssuer
+		return result
+	}
+	_, _, err = openpgp.VerifyDetachedSignature(openpgp.EntityList{result.signer.Entity}, input, bytes.NewReader(signature), config)
+	result.reason = failureReason(err)
+	return result
+}
+
+// verifyCleartext verifies a cleartext signed document. The signed text is
+// only returned when the signature is valid.
+func verifyCleartext(keyring openpgp.KeyRing, document []byte, config *packet.Config) *verification {
+	block, _ := clearsign.Decode(document)
+	if block == nil {
+		return malformedSignature()
+	}
+	signature, err := io.ReadAll(block.ArmoredSignature.Body)
+	if err != nil {
+		return malformedSignature()
+	}
+
+	result := verifyDetached(keyring, bytes.NewReader(block.Bytes), signature, config)
+	if result.valid() {
+		result.plaintext = block.Plaintext
+	}
+	return result
+}
+
+// verifyAllDetached verifies every signature packet of a detached signature
+// of the input.
+func verifyAllDetached(keyring openpgp.KeyRing, input, signature []byte, config *packet.Config) []*verification {
+	signatures, err := splitPackets(signature)
+	if err != nil || len(signatures) == 0 {
+		return []*verification{malformedSignature()}
+	}
+
+	results := make([]*verification, 0, len(signatures))
+	for _, sig := range signatures {
+		results = append(results, verifyDetached(keyring, bytes.NewReader(input), sig, config))
+	}
+	return results
+}
+
+// verifyAllCleartext verifies every signature of a cleartext signed document.
+// The signed text is returned when it can be decoded, whether the signatures
+// are valid or not.
+func verifyAllCleartext(keyring openpgp.KeyRing, document []byte, config *packet.Config) ([]*verification, []byte) {
+	block, _ := clearsign.Decode(document)
+	if block == nil {
+		return []*verification{malformedSignature()}, nil
+	}
+	signature, err := io.ReadAll(block.ArmoredSignature.Body)
+	if err != nil {
+		return []*verification{malformedSignature()}, nil
+	}
+	return verifyAllDetached(keyring, block.Bytes, signature, config), block.Plaintext
+}
+
+// splitPackets returns the serialized OpenPGP packets of the data.
+func splitPackets(data []byte) ([][]byte, error) {
+	var packets [][]byte
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		start := len(data) - r.Len()
+		// packet.Read consumes exactly one packet from the reader
+		if _, err := packet.Read(r); err != nil {
+			return nil, err
+		}
+		packets = append(packets, data[start:len(data)-r.Len()])
+	}
+	return packets, nil
+}
+
+// signaturesResponseData returns the results of the verification of several
+// signatures. The verification succeeds when threshold distinct keys made a
+// valid signature or, when threshold is 0, when all the signatures are valid.
+// The signed text is only returned when the verification succeeds. keyName
+// returns the name of the key that made a signature, it may be nil.
+func signaturesResponseData(results []*verification, plaintext []byte, threshold int, keyName func(*verification) string) map[string]interface{} {
+	signers := make(map[string]bool)
+	allValid := len(results) > 0
+	signatures := make([]map[string]interface{}, 0, len(results))
+	for _, result := range results {
+		if result.valid() && result.signer != nil {
+			signers[hex.EncodeToString(result.signer.Entity.PrimaryKey.Fingerprint)] = true
+		} else {
+			allValid = false
+		}
+		signature := result.toResponseData()
+		if keyName != nil && result.signer != nil {
+			signature["key_name"] = keyName(result)
+		}
+		signatures = append(signatures, signature)
+	}
+
+	valid := allValid
+	if threshold > 0 {
+		valid = len(signers) >= threshold
+	}
+	data := map[string]interface{}{
+		"valid":         valid,
+		"valid_signers": len(signers),
+		"signatures":    signatures,
+	}
+	if valid && plaintext != nil {
+		data["plaintext"] = base64.StdEncoding.EncodeToString(plaintext)
+	}
+	return data
+}
+
+// verifyInline verifies a signed message. The embedded data is only returned
+// when the signature is valid.
+func verifyInline(keyring openpgp.EntityList, message []byte, config *packet.Config) *verification {
+	// The private key must not be used to decrypt messages sent for verification
+	md, err := openpgp.ReadMessage(bytes.NewReader(message), verificationKeyRing{keyring}, nil, config)
+	if err != nil || md.IsEncrypted || !md.IsSigned {
+		return malformedSignature()
+	}
+	// The whole message must be read before the signature is checked
+	plaintext, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return malformedSignature()
+	}
+
+	result := &verification{
+		signature: md.Signature,
+		signer:    md.SignedBy,
+	}
+	if result.signature == nil && len(md.UnverifiedSignatures) > 0 {
+		result.signature = md.UnverifiedSignatures[0]
+	}
+	switch {
+	case md.SignedBy == nil:
+		result.reason = reasonUnknownIssuer
+	case result.signature != nil && !isStrongHash(result.signature):
+		result.reason = reasonWeakHash
+	default:
+		result.reason = failureReason(md.SignatureError)
+	}
+	if result.valid() {
+		result.plaintext = plaintext
+	}
+	return result
+}
+
+// issuerKey returns the key of the key ring matching the issuer of the
+// signature. The issuer fingerprint is checked when the signature has one.
+func issuerKey(keyring openpgp.KeyRing, sig *packet.Signature) *openpgp.Key {
+	for _, key := range keyring.KeysByIdUsage(*sig.IssuerKeyId, packet.KeyFlagSign) {
+		if sig.IssuerFingerprint == nil || bytes.Equal(key.PublicKey.Fingerprint, sig.IssuerFingerprint) {
+			return &key
+		}
+	}
+	return nil
+}
+
+// isStrongHash returns false for signatures using a hash algorithm that can
+// not be used to sign with the plugin, such as SHA-1 or MD5.
+func isStrongHash(sig *packet.Signature) bool {
+	for _, h := range hashAlgorithms {
+		if h == sig.Hash {
+			return true
+		}
+	}
+	return false
+}
+
+// failureReason returns the reason matching the error returned by the
+// verification of a signature.
+func failureReason(err error) string {
+	var signatureError pgperrors.SignatureError
+	var structuralError pgperrors.StructuralError
+	var unsupportedError pgperrors.UnsupportedError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, pgperrors.ErrUnknownIssuer):
+		return reasonUnknownIssuer
+	case errors.Is(err, pgperrors.ErrKeyRevoked):
+		return reasonKeyRevoked
+	case errors.Is(err, pgperrors.ErrKeyExpired):
+		return reasonKeyExpired
+	case errors.Is(err, pgperrors.ErrSignatureExpired):
+		return reasonSignatureExpired
+	case errors.As(err, &signatureError) && strings.HasPrefix(string(signatureError), "unknown critical notation"):
+		return reasonUnknownCriticalNotation
+	case errors.As(err, &structuralError), errors.As(err, &unsupportedError):
+		return reasonMalformed
+	default:
+		return reasonBadSignature
+	}
+}
+
+// verificationKeyRing is a key ring that can only be used to verify
+// signatures.
+type verificationKeyRing struct {
+	openpgp.EntityList
+}
+
+func (verificationKeyRing) DecryptionKeys() []openpgp.Key {
+	return nil
+}
@@ -0,0 +1,576 @@
+package gpg
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestGPG_VerifyDetails(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"generate": false,
+		"key":      gpgKey,
+	}, false)
+	testAccStepCreateKey(t, b, storage, "other", map[string]interface{}{
+		"real_name": "Vault GPG test",
+	}, false)
+
+	request := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	sign := func(data map[string]interface{}) string {
+		resp := request("sign/test", data)
+		if resp.IsError() {
+			t.Fatal(resp.Error())
+		}
+		return resp.Data["signature"].(string)
+	}
+
+	el, err := openpgp.ReadArmoredKeyRing(strings.NewReader(gpgKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingKey, ok := el[0].SigningKey(time.Now())
+	if !ok {
+		t.Fatal("no signing key")
+	}
+
+	input := base64.StdEncoding.EncodeToString([]byte("the quick brown fox"))
+	creationTime := time.Now().Add(-time.Hour).Truncate(time.Second).UTC()
+	signature := sign(map[string]interface{}{
+		"input":         input,
+		"algorithm":     "sha2-512",
+		"mode":          "text",
+		"notations":     "build-id@example.com=42",
+		"creation_time": creationTime.Unix(),
+	})
+
+	resp := request("verify/test", map[string]interface{}{
+		"input":     input,
+		"signature": signature,
+	})
+	expected := map[string]interface{}{
+		"valid":          true,
+		"key_id":         fmt.Sprintf("%016x", signingKey.PublicKey.KeyId),
+		"fingerprint":    hex.EncodeToString(el[0].PrimaryKey.Fingerprint),
+		"creation_time":  creationTime,
+		"hash_algorithm": "sha2-512",
+		"mode":           "text",
+	}
+	for field, value := range expected {
+		if resp.Data[field] != value {
+			t.Fatalf("unexpected %s: %#v", field, resp.Data)
+		}
+	}
+	if _, ok := resp.Data["reason"]; ok {
+		t.Fatalf("unexpected reason for a valid signature: %#v", resp.Data)
+	}
+	// The library also adds a salt notation to the signatures
+	var notation map[string]interface{}
+	for _, n := range resp.Data["notations"].([]map[string]interface{}) {
+		if n["name"] == "build-id@example.com" {
+			notation = n
+		}
+	}
+	if notation == nil || notation["value"] != "42" || notation["critical"] != false || notation["human_readable"] != true {
+		t.Fatalf("unexpected notations: %#v", resp.Data["notations"])
+	}
+
+	// Details are returned for invalid signatures
+	resp = request("verify/test", map[string]interface{}{
+		"input":     base64.StdEncoding.EncodeToString([]byte("the quick brown dog")),
+		"signature": signature,
+	})
+	if resp.Data["valid"] != false || resp.Data["reason"] != reasonBadSignature || resp.Data["key_id"] != expected["key_id"] {
+		t.Fatalf("expected a bad signature: %#v", resp.Data)
+	}
+
+	resp = request("verify/other", map[string]interface{}{
+		"input":     input,
+		"signature": signature,
+	})
+	if resp.Data["valid"] != false || resp.Data["reason"] != reasonUnknownIssuer || resp.Data["fingerprint"] != nil {
+		t.Fatalf("expected an unknown issuer: %#v", resp.Data)
+	}
+
+	for _, data := range []map[string]interface{}{
+		{"input": input, "signature": "not a signature"},
+		{"input": input, "signature": input},
+		{"signature": input, "signature_type": "inline"},
+		{"signature": "not a document", "signature_type": "cleartext", "format": "ascii-armor"},
+	} {
+		resp = request("verify/test", data)
+		if resp.Data["valid"] != false || resp.Data["reason"] != reasonMalformed {
+			t.Fatalf("expected a malformed signature with %#v: %#v", data, resp.Data)
+		}
+	}
+
+	// Critical notations
+	signature = sign(map[string]interface{}{
+		"input":              input,
+		"notations":          "build-id@example.com=42",
+		"critical_notations": "build-id@example.com",
+	})
+	resp = request("verify/test", map[string]interface{}{
+		"input":     input,
+		"signature": signature,
+	})
+	if resp.Data["valid"] != false || resp.Data["reason"] != reasonUnknownCriticalNotation {
+		t.Fatalf("expected an unknown critical notation: %#v", resp.Data)
+	}
+
+	// Expired signature, valid at the reference time
+	signature = sign(map[string]interface{}{
+		"input":         input,
+		"expiration":    "1h",
+		"creation_time": time.Now().Add(-2 * time.Hour).Unix(),
+	})
+	resp = request("verify/test", map[string]interface{}{
+		"input":     input,
+		"signature": signature,
+	})
+	if resp.Data["valid"] != false || resp.Data["reason"] != reasonSignatureExpired {
+		t.Fatalf("expected an expired signature: %#v", resp.Data)
+	}
+	resp = request("verify/test", map[string]interface{}{
+		"input":          input,
+		"signature":      signature,
+		"reference_time": time.Now().Add(-90 * time.Minute).Format(time.RFC3339),
+	})
+	if resp.Data["valid"] != true {
+		t.Fatalf("expected a valid signature at the reference time: %#v", resp.Data)
+	}
+
+	// Weak hash algorithm
+	sig := newDetachedSignature(signingKey.PrivateKey, crypto.SHA1, time.Now())
+	h, err := sig.PrepareSign(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Write([]byte("the quick brown fox"))
+	if err = sig.Sign(h, signingKey.PrivateKey, deterministicSignatureConfig()); err != nil {
+		t.Fatal(err)
+	}
+	var weak bytes.Buffer
+	if err = sig.Serialize(&weak); err != nil {
+		t.Fatal(err)
+	}
+	resp = request("verify/test", map[string]interface{}{
+		"input":     input,
+		"signature": base64.StdEncoding.EncodeToString(weak.Bytes()),
+	})
+	if resp.Data["valid"] != false || resp.Data["reason"] != reasonWeakHash || resp.Data["hash_algorithm"] != "SHA-1" {
+		t.Fatalf("expected a weak hash: %#v", resp.Data)
+	}
+
+	// Details of cleartext and inline signatures
+	for _, signatureType := range []string{"cleartext", "inline"} {
+		signature = sign(map[string]interface{}{
+			"input":          input,
+			"signature_type": signatureType,
+		})
+		resp = request("verify/test", map[string]interface{}{
+			"signature":      signature,
+			"signature_type": signatureType,
+		})
+		if resp.Data["valid"] != true || resp.Data["key_id"] != expected["key_id"] || resp.Data["plaintext"] == nil {
+			t.Fatalf("expected a valid %s signature: %#v", signatureType, resp.Data)
+		}
+		resp = request("verify/other", map[string]interface{}{
+			"signature":      signature,
+			"signature_type": signatureType,
+		})
+		if resp.Data["valid"] != false || resp.Data["reason"] != reasonUnknownIssuer || resp.Data["plaintext"] != nil {
+			t.Fatalf("expected an unknown issuer for the %s signature: %#v", signatureType, resp.Data)
+		}
+	}
+}
+
+func TestGPG_VerifyKeyValidity(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	request := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	detachSign := func(entity *openpgp.Entity, config *packet.Config) string {
+		var signature bytes.Buffer
+		if err := openpgp.DetachSign(&signature, entity, strings.NewReader("the quick brown fox"), config); err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(signature.Bytes())
+	}
+	input := base64.StdEncoding.EncodeToString([]byte("the quick brown fox"))
+
+	// Key expired after the signature was made
+	past := time.Now().Add(-48 * time.Hour)
+	pastConfig := &packet.Config{
+		Algorithm:       packet.PubKeyAlgoEd25519,
+		KeyLifetimeSecs: 3600,
+		Time: func() time.Time {
+			return past
+		},
+	}
+	expired, err := openpgp.NewEntity("Vault GPG test", "", "vault@example.com", pastConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAccStepCreateKey(t, b, storage, "expired", map[string]interface{}{
+		"generate": false,
+		"key":      testArmoredPrivateKey(t, expired),
+	}, false)
+	signature := detachSign(expired, pastConfig)
+	resp := request("verify/expired", map[string]interface{}{
+		"input":     input,
+		"signature": signature,
+	})
+	if resp.Data["valid"] != false || resp.Data["reason"] != reasonKeyExpired {
+		t.Fatalf("expected an expired key: %#v", resp.Data)
+	}
+	resp = request("verify/expired", map[string]interface{}{
+		"input":          input,
+		"signature":      signature,
+		"reference_time": past.Add(time.Minute).Unix(),
+	})
+	if resp.Data["valid"] != true {
+		t.Fatalf("expected a valid signature at the reference time: %#v", resp.Data)
+	}
+
+	// Revoked key
+	revoked, err := openpgp.NewEntity("Vault GPG test", "", "vault@example.com", &packet.Config{
+		Algorithm: packet.PubKeyAlgoEd25519,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature = detachSign(revoked, nil)
+	if err = revoked.RevokeKey(packet.KeyCompromised, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	testAccStepCreateKey(t, b, storage, "revoked", map[string]interface{}{
+		"generate": false,
+		"key":      testArmoredPrivateKey(t, revoked),
+	}, false)
+	resp = request("verify/revoked", map[string]interface{}{
+		"input":     input,
+		"signature": signature,
+	})
+	if resp.Data["valid"] != false || resp.Data["reason"] != reasonKeyRevoked {
+		t.Fatalf("expected a revoked key: %#v", resp.Data)
+	}
+
+	// Signature of a subkey
+	withSubkey, err := openpgp.NewEntity("Vault GPG test", "", "vault@example.com", &packet.Config{
+		Algorithm: packet.PubKeyAlgoEd25519,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = withSubkey.AddSigningSubkey(&packet.Config{Algorithm: packet.PubKeyAlgoEd25519}); err != nil {
+		t.Fatal(err)
+	}
+	testAccStepCreateKey(t, b, storage, "subkey", map[string]interface{}{
+		"generate": false,
+		"key":      testArmoredPrivateKey(t, withSubkey),
+	}, false)
+	resp = request("sign/subkey", map[string]interface{}{
+		"input": input,
+	})
+	resp = request("verify/subkey", map[string]interface{}{
+		"input":     input,
+		"signature": resp.Data["signature"],
+	})
+	subkey := withSubkey.Subkeys[len(withSubkey.Subkeys)-1].PublicKey
+	if resp.Data["valid"] != true ||
+		resp.Data["fingerprint"] != hex.EncodeToString(withSubkey.PrimaryKey.Fingerprint) ||
+		resp.Data["subkey_fingerprint"] != hex.EncodeToString(subkey.Fingerprint) ||
+		resp.Data["key_id"] != fmt.Sprintf("%016x", subkey.KeyId) {
+		t.Fatalf("expected a signature of the subkey: %#v", resp.Data)
+	}
+}
+
+func TestGPG_VerifyMultipleSignatures(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	newEntity := func() *openpgp.Entity {
+		entity, err := openpgp.NewEntity("Vault GPG test", "", "vault@example.com", &packet.Config{
+			Algorithm: packet.PubKeyAlgoEd25519,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return entity
+	}
+	request := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"generate": false,
+		"key":      gpgKey,
+	}, false)
+	testAccStepCreateKey(t, b, storage, "other", map[string]interface{}{
+		"generate": false,
+		"key":      testArmoredPrivateKey(t, newEntity()),
+	}, false)
+
+	input := base64.StdEncoding.EncodeToString([]byte("the quick brown fox"))
+	var signatures, first []byte
+	for _, name := range []string{"test", "other"} {
+		resp := request("sign/"+name, map[string]interface{}{
+			"input": input,
+		})
+		if resp.IsError() {
+			t.Fatal(resp.Error())
+		}
+		signature, err := base64.StdEncoding.DecodeString(resp.Data["signature"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first == nil {
+			first = signature
+		}
+		signatures = append(signatures, signature...)
+	}
+	var vendorSignature bytes.Buffer
+	if err := openpgp.DetachSign(&vendorSignature, newEntity(), strings.NewReader("the quick brown fox"), nil); err != nil {
+		t.Fatal(err)
+	}
+	signatures = append(signatures, vendorSignature.Bytes()...)
+	signature := base64.StdEncoding.EncodeToString(signatures)
+
+	// Every signature is reported, the unknown issuer fails the verification
+	resp := request("verify", map[string]interface{}{
+		"input":          input,
+		"signature":      signature,
+		"all_signatures": true,
+	})
+	results := resp.Data["signatures"].([]map[string]interface{})
+	if resp.Data["valid"] != false || resp.Data["valid_signers"] != 2 || len(results) != 3 {
+		t.Fatalf("expected two valid signatures out of three: %#v", resp.Data)
+	}
+	for i, name := range []string{"test", "other"} {
+		if results[i]["valid"] != true || results[i]["key_name"] != name {
+			t.Fatalf("expected a valid signature of %s: %#v", name, results[i])
+		}
+	}
+	if results[2]["valid"] != false || results[2]["reason"] != reasonUnknownIssuer || results[2]["key_name"] != nil {
+		t.Fatalf("expected an unknown issuer: %#v", results[2])
+	}
+	if b.usage.get("other").Counts[usageVerify] != 1 {
+		t.Fatalf("expected the verification to be recorded: %#v", b.usage.get("other"))
+	}
+
+	for threshold, valid := range map[int]bool{1: true, 2: true, 3: false} {
+		resp = request("verify", map[string]interface{}{
+			"input":     input,
+			"signature": signature,
+			"threshold": threshold,
+		})
+		if resp.Data["valid"] != valid {
+			t.Fatalf("unexpected validity with a threshold of %d: %#v", threshold, resp.Data)
+		}
+	}
+
+	// Only the signatures of the named key are valid
+	resp = request("verify/test", map[string]interface{}{
+		"input":     input,
+		"signature": signature,
+		"threshold": 2,
+	})
+	if resp.Data["valid"] != false || resp.Data["valid_signers"] != 1 {
+		t.Fatalf("expected a single valid signer: %#v", resp.Data)
+	}
+
+	// Signatures of the same key are counted once
+	resp = request("sign/test", map[string]interface{}{
+		"input": input,
+	})
+	again, err := base64.StdEncoding.DecodeString(resp.Data["signature"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = request("verify/test", map[string]interface{}{
+		"input":     input,
+		"signature": base64.StdEncoding.EncodeToString(append(append([]byte{}, first...), again...)),
+		"threshold": 2,
+	})
+	if resp.Data["valid"] != false || resp.Data["valid_signers"] != 1 || len(resp.Data["signatures"].([]map[string]interface{})) != 2 {
+		t.Fatalf("expected a single valid signer: %#v", resp.Data)
+	}
+
+	// Cleartext document signed by the supplied public keys
+	managers := []*openpgp.Entity{newEntity(), newEntity(), newEntity()}
+	var document bytes.Buffer
+	w, err := clearsign.EncodeMulti(&document, []*packet.PrivateKey{managers[0].PrivateKey, managers[1].PrivateKey}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte("the quick brown fox")); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var publicKeys bytes.Buffer
+	aw, err := armor.Encode(&publicKeys, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entity := range managers {
+		if err = entity.Serialize(aw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for threshold, valid := range map[int]bool{2: true, 3: false} {
+		resp = request("verify", map[string]interface{}{
+			"signature":      document.String(),
+			"format":         "ascii-armor",
+			"signature_type": "cleartext",
+			"public_keys":    publicKeys.String(),
+			"threshold":      threshold,
+		})
+		if resp.Data["valid"] != valid || resp.Data["valid_signers"] != 2 {
+			t.Fatalf("unexpected validity with a threshold of %d: %#v", threshold, resp.Data)
+		}
+		if (resp.Data["plaintext"] != nil) != valid {
+			t.Fatalf("the plaintext must only be returned for valid documents: %#v", resp.Data)
+		}
+	}
+
+	for _, data := range []map[string]interface{}{
+		{"signature": signature, "signature_type": "inline", "all_signatures": true},
+		{"input": input, "signature": signature, "threshold": -1},
+	} {
+		resp = request("verify/test", data)
+		if !resp.IsError() {
+			t.Fatalf("expected an error with %#v: %#v", data, resp)
+		}
+	}
+}
+
+// withUnhashedIssuerFingerprint returns the v4 detached signature with an
+// issuer fingerprint subpacket added to its unhashed area. The signature
+// stays valid, its issuer is the fingerprint.
+func withUnhashedIssuerFingerprint(t *testing.T, signature, fingerprint []byte) []byte {
+	if signature[0] != 0xc2 || signature[1] >= 192 || signature[2] != 4 {
+		t.Fatal("expected a v4 signature packet with a one-octet length")
+	}
+	body := signature[2:]
+	hashedEnd := 6 + (int(body[4])<<8 | int(body[5]))
+	unhashedLength := int(body[hashedEnd])<<8 | int(body[hashedEnd+1])
+	unhashedEnd := hashedEnd + 2 + unhashedLength
+
+	subpacket := append([]byte{byte(2 + len(fingerprint)), 33, 4}, fingerprint...)
+	unhashedLength += len(subpacket)
+	var forged []byte
+	forged = append(forged, body[:hashedEnd]...)
+	forged = append(forged, byte(unhashedLength>>8), byte(unhashedLength))
+	forged = append(forged, body[hashedEnd+2:unhashedEnd]...)
+	forged = append(forged, subpacket...)
+	forged = append(forged, body[unhashedEnd:]...)
+	if len(forged) >= 192 {
+		t.Fatal("expected the forged signature to fit in a one-octet length")
+	}
+	return append([]byte{0xc2, byte(len(forged))}, forged...)
+}
+
+func TestGPG_VerifyIssuerFingerprintMismatch(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := Backend()
+
+	entity, err := openpgp.NewEntity("Vault GPG test", "", "vault@example.com", &packet.Config{
+		Algorithm: packet.PubKeyAlgoEd25519,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAccStepCreateKey(t, b, storage, "test", map[string]interface{}{
+		"generate": false,
+		"key":      testArmoredPrivateKey(t, entity),
+	}, false)
+	request := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		})
+		if err != nil && !resp.IsError() {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	var signature bytes.Buffer
+	if err := openpgp.DetachSign(&signature, entity, strings.NewReader("the quick brown fox"), nil); err != nil {
+		t.Fatal(err)
+	}
+	// The fingerprint of another key sharing the key ID of the signer
+	fingerprint := append([]byte{}, entity.PrimaryKey.Fingerprint...)
+	fingerprint[0] ^= 0xff
+	forged := base64.StdEncoding.EncodeToString(withUnhashedIssuerFingerprint(t, signature.Bytes(), fingerprint))
+	input := base64.StdEncoding.EncodeToString([]byte("the quick brown fox"))
+
+	resp := request("verify/test", map[string]interface{}{
+		"input":     input,
+		"signature": forged,
+	})
+	if resp.Data["valid"] != false || resp.Data["reason"] != reasonUnknownIssuer {
+		t.Fatalf("expected the issuer to be unknown: %#v", resp.Data)
+	}
+	resp = request("verify/test", map[string]interface{}{
+		"input":          input,
+		"signature":      forged,
+		"all_signatures": true,
+	})
+	if resp.Data["valid"] != false || resp.Data["valid_signers"] != 0 {
+		t.Fatalf("expected the issuer to be unknown: %#v", resp.Data)
+	}
+}
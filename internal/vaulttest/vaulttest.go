@@ -0,0 +1,66 @@
+// Package vaulttest provides the helpers shared by the tests of the commands
+// talking to the GPG secret backend through the Vault API.
+package vaulttest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/LeSuisse/vault-gpg-plugin/gpg"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Logical sends the requests of the Vault logical API to a backend mounted at
+// gpg/, without a Vault server.
+type Logical struct {
+	backend logical.Backend
+	storage logical.Storage
+}
+
+// NewLogical returns a Logical sending the requests to a new backend with an
+// empty storage.
+func NewLogical() *Logical {
+	return &Logical{backend: gpg.Backend(), storage: &logical.InmemStorage{}}
+}
+
+func (l *Logical) request(operation logical.Operation, path string, data map[string]interface{}) (*api.Secret, error) {
+	resp, err := l.backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:   l.storage,
+		Operation: operation,
+		Path:      strings.TrimPrefix(path, "gpg/"),
+		Data:      data,
+	})
+	if resp.IsError() {
+		return nil, resp.Error()
+	}
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	// The response goes through JSON as it would with the HTTP API
+	encoded, err := json.Marshal(resp.Data)
+	if err != nil {
+		return nil, err
+	}
+	secret := &api.Secret{}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	return secret, decoder.Decode(&secret.Data)
+}
+
+// Read is the equivalent of api.Logical.Read.
+func (l *Logical) Read(path string) (*api.Secret, error) {
+	return l.request(logical.ReadOperation, path, nil)
+}
+
+// Write is the equivalent of api.Logical.Write.
+func (l *Logical) Write(path string, data map[string]interface{}) (*api.Secret, error) {
+	return l.request(logical.UpdateOperation, path, data)
+}
+
+// Delete is the equivalent of api.Logical.Delete.
+func (l *Logical) Delete(path string) (*api.Secret, error) {
+	return l.request(logical.DeleteOperation, path, nil)
+}